@@ -0,0 +1,743 @@
+package filters
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/AliyunContainerService/kube-eventer/util"
+	v1 "k8s.io/api/core/v1"
+	log "k8s.io/klog/v2"
+)
+
+// ExpressionFilter matches the events for which a boolean expression is true. The expressions
+// are a small subset of CEL, e.g.
+//
+//	reason == "BackOff" && count > 5 && !namespace.startsWith("dev-")
+//	kind in ["Pod", "Node"] || message.matches("OOM|Evicted")
+//	labels["team"] == "web" and age < duration("10m")
+//
+// Supported are the operators || && ! (also written or, and, not), == != < <= > >= and in,
+// the string methods startsWith, endsWith, contains and matches, size(), string, int, bool and
+// list literals, and duration("1h30m"). See eventVariables for the fields of the event.
+type ExpressionFilter struct {
+	expression string
+	root       node
+}
+
+func (ef *ExpressionFilter) Filter(event *v1.Event) (matched bool) {
+	value, err := ef.root.eval(event)
+	if err != nil {
+		log.V(2).Infof("Failed to evaluate %q for event %s/%s: %v", ef.expression, event.Namespace, event.Name, err)
+		return false
+	}
+	matched, ok := value.(bool)
+	return ok && matched
+}
+
+// NewExpressionFilter parses the expression.
+func NewExpressionFilter(expression string) (*ExpressionFilter, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", expression, err)
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = fmt.Errorf("unexpected %q", p.peek().text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", expression, err)
+	}
+	return &ExpressionFilter{expression: expression, root: root}, nil
+}
+
+// eventVariables are the fields of the event an expression can use.
+var eventVariables = map[string]func(event *v1.Event) interface{}{
+	"type":    func(e *v1.Event) interface{} { return e.Type },
+	"reason":  func(e *v1.Event) interface{} { return e.Reason },
+	"message": func(e *v1.Event) interface{} { return e.Message },
+	"name":    func(e *v1.Event) interface{} { return e.Name },
+	// The namespace of the involved object, or of the event for cluster scoped objects.
+	"namespace": func(e *v1.Event) interface{} {
+		if e.InvolvedObject.Namespace != "" {
+			return e.InvolvedObject.Namespace
+		}
+		return e.Namespace
+	},
+	"kind":                      func(e *v1.Event) interface{} { return e.InvolvedObject.Kind },
+	"involvedObject.kind":       func(e *v1.Event) interface{} { return e.InvolvedObject.Kind },
+	"involvedObject.name":       func(e *v1.Event) interface{} { return e.InvolvedObject.Name },
+	"involvedObject.namespace":  func(e *v1.Event) interface{} { return e.InvolvedObject.Namespace },
+	"involvedObject.fieldPath":  func(e *v1.Event) interface{} { return e.InvolvedObject.FieldPath },
+	"involvedObject.apiVersion": func(e *v1.Event) interface{} { return e.InvolvedObject.APIVersion },
+	"source.component":          func(e *v1.Event) interface{} { return e.Source.Component },
+	"source.host":               func(e *v1.Event) interface{} { return e.Source.Host },
+	"reportingController":       func(e *v1.Event) interface{} { return e.ReportingController },
+	"cluster":                   func(e *v1.Event) interface{} { return util.GetClusterName(e, "") },
+	"count":                     func(e *v1.Event) interface{} { return int64(e.Count) },
+	"age":                       func(e *v1.Event) interface{} { return time.Since(util.GetLastEventTimestamp(e)) },
+	"labels":                    func(e *v1.Event) interface{} { return stringMap(e.Labels) },
+	"annotations":               func(e *v1.Event) interface{} { return stringMap(e.Annotations) },
+}
+
+func stringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
+
+// Tokens
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenInt
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ",", "."}
+
+func tokenize(s string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(s) && rune(s[end]) != c {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			text := s[i : end+1]
+			if c == '\'' {
+				text = `"` + strings.Replace(s[i+1:end], `"`, `\"`, -1) + `"`
+			}
+			value, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s: %v", s[i:end+1], err)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: value})
+			i = end + 1
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1]))):
+			end := i + 1
+			for end < len(s) && unicode.IsDigit(rune(s[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenInt, text: s[i:end]})
+			i = end
+		case unicode.IsLetter(c) || c == '_':
+			end := i + 1
+			for end < len(s) && (unicode.IsLetter(rune(s[end])) || unicode.IsDigit(rune(s[end])) || s[end] == '_') {
+				end++
+			}
+			word := s[i:end]
+			switch word {
+			case "and":
+				tokens = append(tokens, token{kind: tokenOperator, text: "&&"})
+			case "or":
+				tokens = append(tokens, token{kind: tokenOperator, text: "||"})
+			case "not":
+				tokens = append(tokens, token{kind: tokenOperator, text: "!"})
+			default:
+				tokens = append(tokens, token{kind: tokenIdent, text: word})
+			}
+			i = end
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// Parser
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(offset int) token {
+	if p.pos+offset < len(p.tokens) {
+		return p.tokens[p.pos+offset]
+	}
+	return token{kind: tokenEOF}
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOperator(op string) bool {
+	t := p.peek()
+	return t.kind == tokenOperator && t.text == op
+}
+
+func (p *parser) expect(op string) error {
+	if !p.isOperator(op) {
+		return fmt.Errorf("expected %q, got %q", op, p.peek().text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{or: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("&&") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOperator("!") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch {
+	case t.kind == tokenOperator && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+	case t.kind == tokenIdent && t.text == "in":
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	return &comparisonNode{op: t.text, left: left, right: right}, nil
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.isOperator("."):
+			p.next()
+			method := p.next()
+			if method.kind != tokenIdent {
+				return nil, fmt.Errorf("expected method name, got %q", method.text)
+			}
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			n, err = newMethodNode(method.text, n, args)
+			if err != nil {
+				return nil, err
+			}
+		case p.isOperator("["):
+			p.next()
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &indexNode{operand: n, index: index}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) parseArgs() ([]node, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	args := []node{}
+	for !p.isOperator(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+	return args, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return &literalNode{value: t.text}, nil
+	case tokenInt:
+		value, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &literalNode{value: value}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		}
+		if p.isOperator("(") {
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			return newFunctionNode(t.text, args)
+		}
+		// Dotted variables like source.host. A dot followed by a call is a method.
+		name := t.text
+		for p.isOperator(".") && p.peekAt(1).kind == tokenIdent && !(p.peekAt(2).kind == tokenOperator && p.peekAt(2).text == "(") {
+			name += "." + p.peekAt(1).text
+			p.next()
+			p.next()
+		}
+		get, ok := eventVariables[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown variable %q", name)
+		}
+		return &variableNode{name: name, get: get}, nil
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			items := []node{}
+			for !p.isOperator("]") {
+				if len(items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				item, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			p.next()
+			return &listNode{items: items}, nil
+		}
+	}
+	if t.kind == tokenEOF {
+		return nil, fmt.Errorf("unexpected end")
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+// Nodes
+
+type node interface {
+	eval(event *v1.Event) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(*v1.Event) (interface{}, error) {
+	return n.value, nil
+}
+
+type variableNode struct {
+	name string
+	get  func(event *v1.Event) interface{}
+}
+
+func (n *variableNode) eval(event *v1.Event) (interface{}, error) {
+	return n.get(event), nil
+}
+
+type listNode struct {
+	items []node
+}
+
+func (n *listNode) eval(event *v1.Event) (interface{}, error) {
+	values := make([]interface{}, 0, len(n.items))
+	for _, item := range n.items {
+		value, err := item.eval(event)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+type logicalNode struct {
+	or          bool
+	left, right node
+}
+
+func (n *logicalNode) eval(event *v1.Event) (interface{}, error) {
+	left, err := evalBool(n.left, event)
+	if err != nil {
+		return nil, err
+	}
+	// Short circuit.
+	if left == n.or {
+		return left, nil
+	}
+	return evalBool(n.right, event)
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) eval(event *v1.Event) (interface{}, error) {
+	value, err := evalBool(n.operand, event)
+	if err != nil {
+		return nil, err
+	}
+	return !value, nil
+}
+
+func evalBool(n node, event *v1.Event) (bool, error) {
+	value, err := n.eval(event)
+	if err != nil {
+		return false, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected bool, got %v", value)
+	}
+	return b, nil
+}
+
+type comparisonNode struct {
+	op          string
+	left, right node
+}
+
+func (n *comparisonNode) eval(event *v1.Event) (interface{}, error) {
+	left, err := n.left.eval(event)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(event)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op == "in" {
+		switch r := right.(type) {
+		case []interface{}:
+			for _, item := range r {
+				eq, err := equal(item, left)
+				if err != nil {
+					return nil, err
+				}
+				if eq {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]string:
+			key, ok := left.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string key, got %v", left)
+			}
+			_, found := r[key]
+			return found, nil
+		}
+		return nil, fmt.Errorf("expected list or map after in, got %v", right)
+	}
+
+	cmp, err := compare(left, right)
+	if err != nil {
+		return nil, err
+	}
+	// Bools are only equal or not.
+	if _, ok := left.(bool); ok && n.op != "==" && n.op != "!=" {
+		return nil, fmt.Errorf("can not order %v and %v", left, right)
+	}
+	switch n.op {
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+// equal reports whether two values are equal. Lists and maps can not be compared.
+func equal(left, right interface{}) (bool, error) {
+	if !isScalar(left) || !isScalar(right) {
+		return false, fmt.Errorf("can not compare %v and %v", left, right)
+	}
+	return left == right, nil
+}
+
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, int64, time.Duration, bool:
+		return true
+	}
+	return false
+}
+
+// compare returns -1, 0 or 1 for two values of the same type. Two different bools are 1.
+func compare(left, right interface{}) (int, error) {
+	switch l := left.(type) {
+	case string:
+		if r, ok := right.(string); ok {
+			return strings.Compare(l, r), nil
+		}
+	case int64:
+		if r, ok := right.(int64); ok {
+			return compareInt(l, r), nil
+		}
+	case time.Duration:
+		if r, ok := right.(time.Duration); ok {
+			return compareInt(int64(l), int64(r)), nil
+		}
+	case bool:
+		if r, ok := right.(bool); ok {
+			if l == r {
+				return 0, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, fmt.Errorf("can not compare %v and %v", left, right)
+}
+
+func compareInt(l, r int64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+type indexNode struct {
+	operand, index node
+}
+
+func (n *indexNode) eval(event *v1.Event) (interface{}, error) {
+	operand, err := n.operand.eval(event)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(event)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := operand.(map[string]string)
+	if !ok {
+		return nil, fmt.Errorf("can not index %v", operand)
+	}
+	key, ok := index.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected string key, got %v", index)
+	}
+	// A missing key is an empty string, so that labels["team"] == "web" is false if the label is not set.
+	return m[key], nil
+}
+
+type methodNode struct {
+	name     string
+	receiver node
+	args     []node
+	// Compiled pattern of matches with a literal argument.
+	pattern *regexp.Regexp
+}
+
+func newMethodNode(name string, receiver node, args []node) (node, error) {
+	n := &methodNode{name: name, receiver: receiver, args: args}
+	switch name {
+	case "startsWith", "endsWith", "contains", "matches":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s takes 1 argument", name)
+		}
+		if literal, ok := args[0].(*literalNode); ok && name == "matches" {
+			pattern, ok := literal.value.(string)
+			if !ok {
+				return nil, fmt.Errorf("matches takes a string")
+			}
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+			}
+			n.pattern = compiled
+		}
+	case "size":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("size takes no arguments")
+		}
+	default:
+		return nil, fmt.Errorf("unknown method %q", name)
+	}
+	return n, nil
+}
+
+func (n *methodNode) eval(event *v1.Event) (interface{}, error) {
+	receiver, err := n.receiver.eval(event)
+	if err != nil {
+		return nil, err
+	}
+	if n.name == "size" {
+		switch r := receiver.(type) {
+		case string:
+			return int64(len(r)), nil
+		case []interface{}:
+			return int64(len(r)), nil
+		case map[string]string:
+			return int64(len(r)), nil
+		}
+		return nil, fmt.Errorf("size of %v is not supported", receiver)
+	}
+
+	s, ok := receiver.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s expects a string, got %v", n.name, receiver)
+	}
+	value, err := n.args[0].eval(event)
+	if err != nil {
+		return nil, err
+	}
+	arg, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s takes a string, got %v", n.name, value)
+	}
+	switch n.name {
+	case "startsWith":
+		return strings.HasPrefix(s, arg), nil
+	case "endsWith":
+		return strings.HasSuffix(s, arg), nil
+	case "contains":
+		return strings.Contains(s, arg), nil
+	default:
+		pattern := n.pattern
+		if pattern == nil {
+			if pattern, err = regexp.Compile(arg); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", arg, err)
+			}
+		}
+		return pattern.MatchString(s), nil
+	}
+}
+
+// newFunctionNode supports size(x) and duration("1h30m"), which is evaluated while parsing.
+func newFunctionNode(name string, args []node) (node, error) {
+	if name == "size" {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("size takes 1 argument")
+		}
+		return newMethodNode(name, args[0], nil)
+	}
+	if name != "duration" {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("duration takes 1 argument")
+	}
+	literal, ok := args[0].(*literalNode)
+	if !ok {
+		return nil, fmt.Errorf("duration takes a string literal")
+	}
+	s, ok := literal.value.(string)
+	if !ok {
+		return nil, fmt.Errorf("duration takes a string literal")
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, err
+	}
+	return &literalNode{value: d}, nil
+}
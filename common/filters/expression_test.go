@@ -0,0 +1,84 @@
+package filters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newExpressionTestEvent() *v1.Event {
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nginx.16b",
+			Namespace: "prod-web",
+			Labels:    map[string]string{"team": "web"},
+		},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "nginx-7d9", Namespace: "prod-web"},
+		Reason:         "BackOff",
+		Message:        "Back-off restarting failed container",
+		Type:           v1.EventTypeWarning,
+		Count:          7,
+		Source:         v1.EventSource{Component: "kubelet", Host: "node-1"},
+		LastTimestamp:  metav1.NewTime(time.Now().Add(-2 * time.Minute)),
+	}
+}
+
+func TestExpressionFilter(t *testing.T) {
+	event := newExpressionTestEvent()
+	for expression, expected := range map[string]bool{
+		`reason == "BackOff" && count > 5 && !namespace.startsWith("dev-")`:  true,
+		`reason == "BackOff" && count > 10`:                                  false,
+		`kind in ["Node", "Pod"]`:                                            true,
+		`kind in ['Node'] || message.matches("restarting .* container")`:     true,
+		`source.component == "kubelet" and source.host != "node-2"`:          true,
+		`involvedObject.name.startsWith("nginx") and not (type == "Normal")`: true,
+		`labels["team"] == "web" && labels["missing"] == ""`:                 true,
+		`"team" in labels && size(labels) == 1 && reason.size() == 7`:        true,
+		`age < duration("5m") && age > duration("1m")`:                       true,
+		`message.contains("OOM") || name.endsWith(".16b")`:                   true,
+		`count >= 7 && count <= 7 && count != 8`:                             true,
+	} {
+		f, err := NewExpressionFilter(expression)
+		if !assert.NoError(t, err, expression) {
+			continue
+		}
+		assert.Equal(t, expected, f.Filter(event), expression)
+	}
+}
+
+func TestExpressionFilterErrors(t *testing.T) {
+	for _, expression := range []string{
+		`reason ==`,
+		`unknown == "x"`,
+		`reason.startsWith()`,
+		`message.matches("(")`,
+		`duration("soon") > age`,
+		`reason == "BackOff" )`,
+		`"unterminated`,
+	} {
+		_, err := NewExpressionFilter(expression)
+		assert.Error(t, err, expression)
+	}
+
+	// Type errors do not match.
+	for _, expression := range []string{
+		`count == "7"`,
+		`labels in [labels]`,
+		`[1] in [[1]]`,
+		`(count > 5) > false`,
+		`(count > 5) <= true`,
+	} {
+		f, err := NewExpressionFilter(expression)
+		if !assert.NoError(t, err, expression) {
+			continue
+		}
+		assert.False(t, f.Filter(newExpressionTestEvent()), expression)
+	}
+
+	f, err := NewExpressionFilter(`(count > 5) == true && (count > 10) != true`)
+	assert.NoError(t, err)
+	assert.True(t, f.Filter(newExpressionTestEvent()))
+}
@@ -30,6 +30,10 @@ func (gf *GenericFilter) Filter(event *v1.Event) (matched bool) {
 		field = reflect.Indirect(reflect.ValueOf(event)).FieldByNameFunc(func(name string) bool {
 			return name == "InvolvedObject"
 		}).FieldByName("Namespace")
+		// Cluster scoped objects like nodes have no namespace, their events do.
+		if IsZero(field) {
+			field = reflect.Indirect(reflect.ValueOf(event)).FieldByName("ObjectMeta").FieldByName("Namespace")
+		}
 	case "Type":
 		field = reflect.Indirect(reflect.ValueOf(event)).FieldByName("Type")
 	case "Reason":
@@ -37,3 +37,40 @@ func TestEvents(t *testing.T) {
 	regexReasonsFilter := NewGenericFilter("Reason", []string{"Unhealthy", "BackOff"}, true)
 	assert.True(t, regexReasonsFilter.Filter(TestEvent), "")
 }
+
+func TestRuleFilter(t *testing.T) {
+	f, err := NewRuleFilter(map[string][]string{})
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+
+	f, err = NewRuleFilter(map[string][]string{
+		"level":          {"Normal"},
+		"namespaces":     {"default,kube-system"},
+		"exclude_reason": {"^Pulled$,Scheduled"},
+	})
+	assert.NoError(t, err)
+	assert.True(t, f.Filter(TestEvent))
+	assert.False(t, f.Filter(&v1.Event{Type: "Normal", Reason: "Pulled", InvolvedObject: v1.ObjectReference{Namespace: "default"}}))
+	assert.False(t, f.Filter(&v1.Event{Type: "Normal", Reason: "Started", InvolvedObject: v1.ObjectReference{Namespace: "prod"}}))
+
+	f, err = NewRuleFilter(map[string][]string{"level": {"Warning"}, "exclude_kinds": {"Node"}})
+	assert.NoError(t, err)
+	assert.False(t, f.Filter(TestEvent))
+
+	f, err = NewRuleFilter(map[string][]string{"expr": {`kind == "Node" and count == 0`}, "kinds": {"Node"}})
+	assert.NoError(t, err)
+	assert.True(t, f.Filter(TestEvent))
+
+	_, err = NewRuleFilter(map[string][]string{"expr": {`kind ==`}})
+	assert.Error(t, err)
+	_, err = NewRuleFilter(map[string][]string{"level": {"Critical"}})
+	assert.Error(t, err)
+	_, err = NewRuleFilter(map[string][]string{"reason": {"Back("}})
+	assert.Error(t, err)
+}
+
+func TestNamespaceFilterOfClusterScopedObject(t *testing.T) {
+	event := &v1.Event{InvolvedObject: v1.ObjectReference{Kind: "Node"}}
+	event.Namespace = "default"
+	assert.True(t, NewGenericFilter("Namespace", []string{"default"}, false).Filter(event))
+}
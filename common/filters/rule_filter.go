@@ -0,0 +1,117 @@
+package filters
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// RuleFilter matches the events that match all include rules and none of the exclude rules.
+// The same rules configure the global event filter and the filter of every sink.
+type RuleFilter struct {
+	include []Filter
+	exclude []Filter
+}
+
+func (rf *RuleFilter) Filter(event *v1.Event) (matched bool) {
+	for _, f := range rf.include {
+		if !f.Filter(event) {
+			return false
+		}
+	}
+	for _, f := range rf.exclude {
+		if f.Filter(event) {
+			return false
+		}
+	}
+	return true
+}
+
+// RuleOptions are the options NewRuleFilter reads.
+var RuleOptions = []string{"level", "namespaces", "kinds", "reason", "expr",
+	"exclude_namespaces", "exclude_kinds", "exclude_reason"}
+
+// NewRuleFilter builds a RuleFilter from the options:
+//
+//	level=Normal|Warning   events of the level or above
+//	namespaces=a,b         events in the namespaces
+//	kinds=Pod,Node         events of involved objects of the kinds
+//	reason=regexp,...      events with a matching reason
+//	expr=expression        events the expression is true for, see ExpressionFilter
+//	exclude_namespaces, exclude_kinds, exclude_reason  the opposite
+//
+// It returns nil if no rule is set.
+func NewRuleFilter(opts map[string][]string) (Filter, error) {
+	rf := &RuleFilter{}
+
+	if level := GetValues(opts["level"]); len(level) > 0 {
+		types, err := typesOfLevel(level[0])
+		if err != nil {
+			return nil, err
+		}
+		rf.include = append(rf.include, NewGenericFilter("Type", types, false))
+	}
+	if namespaces := GetValues(opts["namespaces"]); len(namespaces) > 0 {
+		rf.include = append(rf.include, NewGenericFilter("Namespace", namespaces, false))
+	}
+	if kinds := GetValues(opts["kinds"]); len(kinds) > 0 {
+		rf.include = append(rf.include, NewGenericFilter("Kind", kinds, false))
+	}
+	if reasons := GetValues(opts["reason"]); len(reasons) > 0 {
+		if err := validatePatterns(reasons); err != nil {
+			return nil, err
+		}
+		rf.include = append(rf.include, NewGenericFilter("Reason", reasons, true))
+	}
+	// Expressions contain commas, so every expr option is one expression.
+	for _, expression := range opts["expr"] {
+		if expression == "" {
+			continue
+		}
+		ef, err := NewExpressionFilter(expression)
+		if err != nil {
+			return nil, err
+		}
+		rf.include = append(rf.include, ef)
+	}
+
+	if namespaces := GetValues(opts["exclude_namespaces"]); len(namespaces) > 0 {
+		rf.exclude = append(rf.exclude, NewGenericFilter("Namespace", namespaces, false))
+	}
+	if kinds := GetValues(opts["exclude_kinds"]); len(kinds) > 0 {
+		rf.exclude = append(rf.exclude, NewGenericFilter("Kind", kinds, false))
+	}
+	if reasons := GetValues(opts["exclude_reason"]); len(reasons) > 0 {
+		if err := validatePatterns(reasons); err != nil {
+			return nil, err
+		}
+		rf.exclude = append(rf.exclude, NewGenericFilter("Reason", reasons, true))
+	}
+
+	if len(rf.include) == 0 && len(rf.exclude) == 0 {
+		return nil, nil
+	}
+	return rf, nil
+}
+
+// typesOfLevel returns the event types of the level and above.
+func typesOfLevel(level string) ([]string, error) {
+	switch {
+	case strings.EqualFold(level, v1.EventTypeNormal):
+		return []string{v1.EventTypeNormal, v1.EventTypeWarning}, nil
+	case strings.EqualFold(level, v1.EventTypeWarning):
+		return []string{v1.EventTypeWarning}, nil
+	}
+	return nil, fmt.Errorf("unknown level %q, supported: %s, %s", level, v1.EventTypeNormal, v1.EventTypeWarning)
+}
+
+func validatePatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid reason pattern %q: %v", p, err)
+		}
+	}
+	return nil
+}
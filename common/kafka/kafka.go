@@ -18,10 +18,13 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 
 	kafka "github.com/Shopify/sarama"
@@ -44,35 +47,148 @@ const (
 	EventsTopic     = "eventstopic"
 )
 
+const (
+	SyncProducer  = "sync"
+	AsyncProducer = "async"
+)
+
+// errMarshal is the error of a message whose value can't be marshaled.
+var errMarshal = errors.New("failed to transform the items to json")
+
+// permanentErrors are the errors of the brokers which a message gets again when it is sent again.
+var permanentErrors = map[kafka.KError]bool{
+	kafka.ErrInvalidMessageSize:          true,
+	kafka.ErrMessageSizeTooLarge:         true,
+	kafka.ErrInvalidTopic:                true,
+	kafka.ErrMessageSetSizeTooLarge:      true,
+	kafka.ErrInvalidRequiredAcks:         true,
+	kafka.ErrTopicAuthorizationFailed:    true,
+	kafka.ErrClusterAuthorizationFailed:  true,
+	kafka.ErrInvalidTimestamp:            true,
+	kafka.ErrUnsupportedSASLMechanism:    true,
+	kafka.ErrUnsupportedVersion:          true,
+	kafka.ErrUnsupportedForMessageFormat: true,
+	kafka.ErrPolicyViolation:             true,
+	kafka.ErrSASLAuthenticationFailed:    true,
+	kafka.ErrUnsupportedCompressionType:  true,
+}
+
+// IsRetryableError returns whether an error of ProduceKafkaMessages may go away when the message is
+// sent again, like an unreachable broker or a leader election. A message which is too large, goes
+// to an invalid topic or can't be encoded fails again.
+func IsRetryableError(err error) bool {
+	var kerr kafka.KError
+	if errors.As(err, &kerr) {
+		return !permanentErrors[kerr]
+	}
+	switch {
+	case errors.Is(err, errMarshal), errors.Is(err, kafka.ErrInvalidPartition):
+		return false
+	case errors.As(err, new(kafka.ConfigurationError)), errors.As(err, new(kafka.PacketEncodingError)):
+		return false
+	}
+	return true
+}
+
 type KafkaClient interface {
 	Name() string
 	Stop()
-	ProduceKafkaMessage(msgData interface{}) error
+	// ProduceKafkaMessages sends the messages and returns the error of each message, nil if it was sent.
+	// IsRetryableError tells the errors which may go away when the message is sent again.
+	ProduceKafkaMessages(messages []*Message) []error
+}
+
+// Message is a message of the sink, with its value marshaled to json.
+type Message struct {
+	// Key is the key the message is partitioned by, no key if empty.
+	Key     string
+	Headers map[string]string
+	Value   interface{}
 }
 
 type kafkaSink struct {
-	producer  kafka.SyncProducer
-	dataTopic string
+	producer      kafka.SyncProducer
+	asyncProducer kafka.AsyncProducer
+	dataTopic     string
+	// headers need Kafka 0.11 or later, they are dropped for an older version.
+	headers bool
+	wg      sync.WaitGroup
 }
 
-func (sink *kafkaSink) ProduceKafkaMessage(msgData interface{}) error {
+func (sink *kafkaSink) ProduceKafkaMessages(messages []*Message) []error {
 	start := time.Now()
-	msgJson, err := json.Marshal(msgData)
-	if err != nil {
-		return fmt.Errorf("failed to transform the items to json : %s", err)
+	errs := make([]error, len(messages))
+	var msgs []*kafka.ProducerMessage
+	// index of the producer messages in messages, to map their errors back.
+	indexes := map[*kafka.ProducerMessage]int{}
+	size := 0
+	for i, message := range messages {
+		msgJson, err := json.Marshal(message.Value)
+		if err != nil {
+			errs[i] = fmt.Errorf("%w: %s", errMarshal, err)
+			continue
+		}
+		msg := sink.toProducerMessage(message, msgJson)
+		msgs = append(msgs, msg)
+		indexes[msg] = i
+		size += len(msgJson)
+	}
+	if len(msgs) == 0 {
+		return errs
 	}
 
-	_, _, err = sink.producer.SendMessage(&kafka.ProducerMessage{
+	if sink.asyncProducer != nil {
+		// the errors of the async producer are only logged.
+		for _, msg := range msgs {
+			sink.asyncProducer.Input() <- msg
+		}
+		klog.V(4).Infof("Queued %d data to kafka in %s", size, time.Since(start))
+		return errs
+	}
+
+	if err := sink.producer.SendMessages(msgs); err != nil {
+		producerErrs, ok := err.(kafka.ProducerErrors)
+		if !ok {
+			for _, msg := range msgs {
+				errs[indexes[msg]] = fmt.Errorf("failed to produce message to %s: %w", sink.dataTopic, err)
+			}
+			return errs
+		}
+		for _, producerErr := range producerErrs {
+			errs[indexes[producerErr.Msg]] = fmt.Errorf("failed to produce message to %s: %w", sink.dataTopic, producerErr.Err)
+		}
+	}
+	klog.V(4).Infof("Exported %d data to kafka in %s", size, time.Since(start))
+	return errs
+}
+
+func (sink *kafkaSink) toProducerMessage(message *Message, value []byte) *kafka.ProducerMessage {
+	msg := &kafka.ProducerMessage{
 		Topic: sink.dataTopic,
-		Key:   nil,
-		Value: kafka.ByteEncoder(msgJson),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to produce message to %s: %s", sink.dataTopic, err)
+		Value: kafka.ByteEncoder(value),
+	}
+	if message.Key != "" {
+		msg.Key = kafka.StringEncoder(message.Key)
+	}
+	if sink.headers && len(message.Headers) > 0 {
+		keys := make([]string, 0, len(message.Headers))
+		for key := range message.Headers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			msg.Headers = append(msg.Headers, kafka.RecordHeader{Key: []byte(key), Value: []byte(message.Headers[key])})
+		}
+	}
+	return msg
+}
+
+// handleErrors logs the errors of the async producer until it is closed.
+func (sink *kafkaSink) handleErrors() {
+	defer sink.wg.Done()
+	for err := range sink.asyncProducer.Errors() {
+		klog.Errorf("failed to produce message to %s: %s", sink.dataTopic, err.Err)
 	}
-	end := time.Now()
-	klog.V(4).Infof("Exported %d data to kafka in %s", len(msgJson), end.Sub(start))
-	return nil
 }
 
 func (sink *kafkaSink) Name() string {
@@ -80,6 +196,12 @@ func (sink *kafkaSink) Name() string {
 }
 
 func (sink *kafkaSink) Stop() {
+	if sink.asyncProducer != nil {
+		// the buffered messages are flushed before the errors channel is closed.
+		sink.asyncProducer.AsyncClose()
+		sink.wg.Wait()
+		return
+	}
 	sink.producer.Close()
 }
 
@@ -120,6 +242,91 @@ func getCompression(opts url.Values) (kafka.CompressionCodec, error) {
 	}
 }
 
+func getRequiredAcks(opts url.Values) (kafka.RequiredAcks, error) {
+	if len(opts["required_acks"]) == 0 {
+		return kafka.WaitForLocal, nil
+	}
+	acks := opts["required_acks"][0]
+	switch acks {
+	case "none", "0":
+		return kafka.NoResponse, nil
+	case "local", "1":
+		return kafka.WaitForLocal, nil
+	case "all", "-1":
+		return kafka.WaitForAll, nil
+	default:
+		return kafka.WaitForLocal, fmt.Errorf("RequiredAcks '%s' is illegal. Use none, local or all", acks)
+	}
+}
+
+// getPartitioner returns the partitioner of the options. By default the messages are spread round
+// robin, unless they have a message_key, whose messages are hashed to a partition to keep them in order.
+func getPartitioner(opts url.Values) (kafka.PartitionerConstructor, error) {
+	if len(opts["partitioner"]) == 0 {
+		if len(opts["message_key"]) >= 1 && opts["message_key"][0] != "" {
+			return kafka.NewHashPartitioner, nil
+		}
+		return kafka.NewRoundRobinPartitioner, nil
+	}
+	partitioner := opts["partitioner"][0]
+	switch partitioner {
+	case "hash":
+		return kafka.NewHashPartitioner, nil
+	case "roundrobin":
+		return kafka.NewRoundRobinPartitioner, nil
+	case "random":
+		return kafka.NewRandomPartitioner, nil
+	default:
+		return nil, fmt.Errorf("Partitioner '%s' is illegal. Use hash, roundrobin or random", partitioner)
+	}
+}
+
+func getProducerType(opts url.Values) (string, error) {
+	if len(opts["producer"]) == 0 {
+		return SyncProducer, nil
+	}
+	producer := opts["producer"][0]
+	switch producer {
+	case SyncProducer, AsyncProducer:
+		return producer, nil
+	default:
+		return "", fmt.Errorf("Producer '%s' is illegal. Use sync or async", producer)
+	}
+}
+
+func getVersion(opts url.Values) (kafka.KafkaVersion, error) {
+	if len(opts["version"]) == 0 {
+		return kafka.NewConfig().Version, nil
+	}
+	version, err := kafka.ParseKafkaVersion(opts["version"][0])
+	if err != nil {
+		return version, fmt.Errorf("Version '%s' is illegal: %v", opts["version"][0], err)
+	}
+	return version, nil
+}
+
+func getInt(opts url.Values, name string, defaultValue int) (int, error) {
+	if len(opts[name]) == 0 {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(opts[name][0])
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative number, got %q", name, opts[name][0])
+	}
+	return value, nil
+}
+
+func getDuration(opts url.Values, name string, defaultValue time.Duration) (time.Duration, error) {
+	if len(opts[name]) == 0 {
+		return defaultValue, nil
+	}
+	value, err := time.ParseDuration(opts[name][0])
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative duration, got %q", name, opts[name][0])
+	}
+	return value, nil
+}
+
 func getTlsConfiguration(opts url.Values) (*tls.Config, bool, error) {
 	if len(opts["cacert"]) == 0 &&
 		(len(opts["cert"]) == 0 || len(opts["key"]) == 0) {
@@ -198,6 +405,11 @@ func NewKafkaClient(uri *url.URL, topicType string) (KafkaClient, error) {
 		return nil, err
 	}
 
+	producerType, err := getProducerType(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	var kafkaBrokers []string
 	if len(opts["brokers"]) < 1 {
 		return nil, fmt.Errorf("There is no broker assigned for connecting kafka")
@@ -213,13 +425,36 @@ func NewKafkaClient(uri *url.URL, topicType string) (KafkaClient, error) {
 	config.Net.DialTimeout = brokerDialTimeout
 	config.Metadata.Retry.Max = brokerDialRetryLimit
 	config.Metadata.Retry.Backoff = brokerDialRetryWait
-	config.Producer.Retry.Max = brokerLeaderRetryLimit
-	config.Producer.Retry.Backoff = brokerLeaderRetryWait
 	config.Producer.Compression = compression
-	config.Producer.Partitioner = kafka.NewRoundRobinPartitioner
-	config.Producer.RequiredAcks = kafka.WaitForLocal
 	config.Producer.Return.Errors = true
-	config.Producer.Return.Successes = true
+	// the successes of the async producer are not read.
+	config.Producer.Return.Successes = producerType == SyncProducer
+
+	if config.Producer.Retry.Max, err = getInt(opts, "max_retries", brokerLeaderRetryLimit); err != nil {
+		return nil, err
+	}
+	if config.Producer.Retry.Backoff, err = getDuration(opts, "retry_backoff", brokerLeaderRetryWait); err != nil {
+		return nil, err
+	}
+	if config.Producer.RequiredAcks, err = getRequiredAcks(opts); err != nil {
+		return nil, err
+	}
+	if config.Producer.Partitioner, err = getPartitioner(opts); err != nil {
+		return nil, err
+	}
+	if config.Producer.Flush.Messages, err = getInt(opts, "batch_size", 0); err != nil {
+		return nil, err
+	}
+	if config.Producer.Flush.Frequency, err = getDuration(opts, "linger", 0); err != nil {
+		return nil, err
+	}
+	if config.Version, err = getVersion(opts); err != nil {
+		return nil, err
+	}
+	headers := config.Version.IsAtLeast(kafka.V0_11_0_0)
+	if !headers {
+		klog.V(2).Infof("kafka version %s doesn't support headers, the messages are sent without headers", config.Version)
+	}
 
 	config.Net.TLS.Config, config.Net.TLS.Enable, err = getTlsConfiguration(opts)
 	if err != nil {
@@ -233,14 +468,24 @@ func NewKafkaClient(uri *url.URL, topicType string) (KafkaClient, error) {
 
 	// set up producer of kafka server.
 	klog.V(3).Infof("attempting to setup kafka sink")
-	sinkProducer, err := kafka.NewSyncProducer(kafkaBrokers, config)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to setup Producer: - %v", err)
+	sink := &kafkaSink{
+		dataTopic: topic,
+		headers:   headers,
+	}
+	if producerType == AsyncProducer {
+		sink.asyncProducer, err = kafka.NewAsyncProducer(kafkaBrokers, config)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to setup Producer: - %v", err)
+		}
+		sink.wg.Add(1)
+		go sink.handleErrors()
+	} else {
+		sink.producer, err = kafka.NewSyncProducer(kafkaBrokers, config)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to setup Producer: - %v", err)
+		}
 	}
 
 	klog.V(3).Infof("kafka sink setup successfully")
-	return &kafkaSink{
-		producer:  sinkProducer,
-		dataTopic: topic,
-	}, nil
+	return sink, nil
 }
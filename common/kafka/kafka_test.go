@@ -0,0 +1,174 @@
+package kafka
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockBroker(t *testing.T, produceResponse *kafka.MockProduceResponse) *kafka.MockBroker {
+	broker := kafka.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]kafka.MockResponse{
+		"MetadataRequest": kafka.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("events", 0, broker.BrokerID()),
+		"ProduceRequest": produceResponse,
+	})
+	return broker
+}
+
+func newClient(t *testing.T, broker *kafka.MockBroker, query string) KafkaClient {
+	uri, err := url.Parse("?brokers=" + broker.Addr() + "&eventstopic=events&" + query)
+	assert.NoError(t, err)
+	client, err := NewKafkaClient(uri, EventsTopic)
+	assert.NoError(t, err)
+	return client
+}
+
+func TestProducerOptions(t *testing.T) {
+	opts, _ := url.ParseQuery("required_acks=all&partitioner=roundrobin&producer=async&version=2.0.0&max_retries=3&linger=50ms")
+	acks, err := getRequiredAcks(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, kafka.WaitForAll, acks)
+	_, err = getPartitioner(opts)
+	assert.NoError(t, err)
+	producer, err := getProducerType(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, AsyncProducer, producer)
+	version, err := getVersion(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, kafka.V2_0_0_0, version)
+	retries, err := getInt(opts, "max_retries", brokerLeaderRetryLimit)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, retries)
+	linger, err := getDuration(opts, "linger", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 50*time.Millisecond, linger)
+
+	// the defaults keep the former behavior, roundrobin unless the messages have a message key.
+	opts = url.Values{}
+	partitioner, _ := getPartitioner(opts)
+	assert.False(t, partitioner("events").RequiresConsistency(), "roundrobin without a message key")
+	opts.Set("message_key", "{{.InvolvedObject.Name}}")
+	partitioner, _ = getPartitioner(opts)
+	assert.True(t, partitioner("events").RequiresConsistency(), "hash with a message key")
+	opts = url.Values{}
+	acks, _ = getRequiredAcks(opts)
+	assert.Equal(t, kafka.WaitForLocal, acks)
+	producer, _ = getProducerType(opts)
+	assert.Equal(t, SyncProducer, producer)
+	retries, _ = getInt(opts, "max_retries", brokerLeaderRetryLimit)
+	assert.Equal(t, brokerLeaderRetryLimit, retries)
+
+	for _, invalid := range []string{"required_acks=2", "partitioner=sticky", "producer=batch", "version=latest", "max_retries=-1", "linger=soon"} {
+		uri, _ := url.Parse("?brokers=localhost:9092&" + invalid)
+		_, err := NewKafkaClient(uri, EventsTopic)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// writeClientCertificate writes a self-signed client certificate and its private key to dir.
+func writeClientCertificate(t *testing.T, dir string) (string, string) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kube-eventer"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	assert.NoError(t, err)
+	key, err := x509.MarshalECPrivateKey(privateKey)
+	assert.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600))
+	return certFile, keyFile
+}
+
+func TestTlsConfigurationWithMessageKey(t *testing.T) {
+	certFile, keyFile := writeClientCertificate(t, t.TempDir())
+	opts := url.Values{
+		"cert":        {certFile},
+		"key":         {keyFile},
+		"message_key": {"{{.InvolvedObject.Namespace}}/{{.InvolvedObject.Name}}"},
+	}
+	config, enabled, err := getTlsConfiguration(opts)
+	assert.NoError(t, err)
+	assert.True(t, enabled)
+	assert.Len(t, config.Certificates, 1)
+	partitioner, err := getPartitioner(opts)
+	assert.NoError(t, err)
+	assert.True(t, partitioner("events").RequiresConsistency())
+}
+
+func TestProduceKafkaMessages(t *testing.T) {
+	// the produce requests of Kafka 0.11 and later are of version 3, with the record headers.
+	broker := newMockBroker(t, kafka.NewMockProduceResponse(t).SetVersion(3))
+	defer broker.Close()
+
+	client := newClient(t, broker, "version=2.0.0&required_acks=all")
+	defer client.Stop()
+	errs := client.ProduceKafkaMessages([]*Message{
+		{Key: "default/Pod/nginx", Headers: map[string]string{"reason": "BackOff"}, Value: "a"},
+		{Value: func() {}},
+		{Value: "b"},
+	})
+	assert.Len(t, errs, 3)
+	assert.NoError(t, errs[0])
+	// a value which can't be marshaled fails alone, and again if it is retried.
+	assert.Error(t, errs[1])
+	assert.False(t, IsRetryableError(errs[1]))
+	assert.NoError(t, errs[2])
+}
+
+func TestProduceKafkaMessagesFailures(t *testing.T) {
+	for kerr, retryable := range map[kafka.KError]bool{
+		kafka.ErrNotEnoughReplicas:   true,
+		kafka.ErrMessageSizeTooLarge: false,
+		kafka.ErrInvalidTopic:        false,
+	} {
+		broker := newMockBroker(t, kafka.NewMockProduceResponse(t).SetError("events", 0, kerr))
+		client := newClient(t, broker, "max_retries=0")
+		errs := client.ProduceKafkaMessages([]*Message{{Value: "a"}, {Value: "b"}})
+		assert.Len(t, errs, 2)
+		assert.Error(t, errs[0])
+		assert.Error(t, errs[1])
+		assert.Equal(t, retryable, IsRetryableError(errs[0]), kerr.Error())
+		client.Stop()
+		broker.Close()
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	assert.True(t, IsRetryableError(kafka.ErrOutOfBrokers))
+	assert.True(t, IsRetryableError(kafka.ErrLeaderNotAvailable))
+	assert.False(t, IsRetryableError(kafka.ErrMessageSizeTooLarge))
+	assert.False(t, IsRetryableError(kafka.ConfigurationError("invalid")))
+	assert.False(t, IsRetryableError(kafka.PacketEncodingError{Info: "invalid"}))
+}
+
+func TestProduceKafkaMessagesAsync(t *testing.T) {
+	broker := newMockBroker(t, kafka.NewMockProduceResponse(t).SetError("events", 0, kafka.ErrInvalidMessage))
+	defer broker.Close()
+
+	client := newClient(t, broker, "producer=async&batch_size=10&linger=10ms&max_retries=0")
+	// the errors of the async producer are logged, not returned.
+	errs := client.ProduceKafkaMessages([]*Message{{Value: "a"}, {Value: "b"}})
+	assert.Equal(t, []error{nil, nil}, errs)
+	client.Stop()
+}
@@ -75,6 +75,12 @@ func GetKubeClientConfig(uri *url.URL) (*kube_rest.Config, error) {
 		return nil, err
 	}
 
+	// The kubeconfig context to use instead of the current one.
+	context := ""
+	if len(opts["context"]) > 0 {
+		context = opts["context"][0]
+	}
+
 	inClusterConfig := defaultInClusterConfig
 	if len(opts["inClusterConfig"]) > 0 {
 		inClusterConfig, err = strconv.ParseBool(opts["inClusterConfig"][0])
@@ -102,8 +108,10 @@ func GetKubeClientConfig(uri *url.URL) (*kube_rest.Config, error) {
 		localKubeConfig := filepath.Join(homedir.HomeDir(), ".kube", "config")
 		if len(opts["localKubeConfig"]) > 0 {
 			localKubeConfig = opts["localKubeConfig"][0]
-			// use the current context in kubeconfig
-			kubeConfig, err := kubeClientCmd.BuildConfigFromFlags("", localKubeConfig)
+			// use the current context in kubeconfig unless a context is given
+			kubeConfig, err := kubeClientCmd.NewNonInteractiveDeferredLoadingClientConfig(
+				&kubeClientCmd.ClientConfigLoadingRules{ExplicitPath: localKubeConfig},
+				&kubeClientCmd.ConfigOverrides{CurrentContext: context}).ClientConfig()
 			if err != nil {
 				panic(err.Error())
 			}
@@ -123,10 +131,14 @@ func GetKubeClientConfig(uri *url.URL) (*kube_rest.Config, error) {
 				return nil, err
 			}
 
-			// Flatten the loaded data to a particular restclient.Config based on the current context.
+			if context == "" {
+				context = loadedConfig.CurrentContext
+			}
+
+			// Flatten the loaded data to a particular restclient.Config based on the context.
 			if kubeConfig, err = kubeClientCmd.NewNonInteractiveClientConfig(
 				*loadedConfig,
-				loadedConfig.CurrentContext,
+				context,
 				&kubeClientCmd.ConfigOverrides{},
 				loader).ClientConfig(); err != nil {
 				return nil, err
@@ -177,6 +189,9 @@ func GetKubernetesClient(uri *url.URL) (client kubernetes.Interface, err error)
 	if err != nil {
 		return nil, err
 	}
-	KubernetesClientSingleton = kubeClient
+	// Several sources may watch several clusters, the first one is the cluster kube-eventer runs in.
+	if KubernetesClientSingleton == nil {
+		KubernetesClientSingleton = kubeClient
+	}
 	return kubeClient, nil
 }
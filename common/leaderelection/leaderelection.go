@@ -0,0 +1,131 @@
+package leaderelection
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	RoleLeader  = "leader"
+	RoleStandby = "standby"
+)
+
+var (
+	// Whether this replica currently holds the lease.
+	isLeader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "eventer",
+			Subsystem: "leader_election",
+			Name:      "is_leader",
+			Help:      "1 if this replica is the leader and exports events, 0 if it is a standby.",
+		})
+
+	leading int32
+)
+
+func init() {
+	prometheus.MustRegister(isLeader)
+}
+
+// Config of the leader election.
+type Config struct {
+	LeaseName      string
+	LeaseNamespace string
+	// Identity of this replica in the lease, defaults to the hostname.
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// Role returns RoleLeader if this replica holds the lease and RoleStandby otherwise.
+func Role() string {
+	if atomic.LoadInt32(&leading) == 1 {
+		return RoleLeader
+	}
+	return RoleStandby
+}
+
+func setLeading(leader bool) {
+	if leader {
+		atomic.StoreInt32(&leading, 1)
+		isLeader.Set(1)
+	} else {
+		atomic.StoreInt32(&leading, 0)
+		isLeader.Set(0)
+	}
+}
+
+// Run competes for the lease until the context is done. onStartedLeading is called once
+// this replica becomes the leader. A leader that loses the lease exits the process, so that
+// it restarts as a standby and never exports events concurrently with the new leader. A leader
+// releases the lease when the context is done.
+func Run(ctx context.Context, client kubernetes.Interface, config Config, onStartedLeading func()) error {
+	identity := config.Identity
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to get hostname as leader election identity: %v", err)
+		}
+		identity = hostname
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: config.LeaseNamespace,
+			Name:      config.LeaseName,
+		},
+		Client: client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            config.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				klog.Infof("%s became the leader of lease %s/%s", identity, config.LeaseNamespace, config.LeaseName)
+				setLeading(true)
+				onStartedLeading()
+			},
+			OnStoppedLeading: func() {
+				setLeading(false)
+				if ctx.Err() != nil {
+					// released on cancel, e.g. on termination.
+					klog.Infof("%s stopped competing for lease %s/%s", identity, config.LeaseNamespace, config.LeaseName)
+					return
+				}
+				klog.Fatalf("%s lost the leadership of lease %s/%s", identity, config.LeaseNamespace, config.LeaseName)
+			},
+			OnNewLeader: func(current string) {
+				if current != identity {
+					klog.Infof("Standing by, the current leader is %s", current)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	setLeading(false)
+	klog.Infof("%s is waiting for the leadership of lease %s/%s", identity, config.LeaseNamespace, config.LeaseName)
+	elector.Run(ctx)
+	return nil
+}
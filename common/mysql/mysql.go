@@ -34,6 +34,8 @@ type MysqlService struct {
 }
 
 type MysqlKubeEventPoint struct {
+	// Name of the cluster of the event, empty without a cluster name.
+	Cluster                  string
 	Namespace                string
 	Kind                     string
 	Name                     string
@@ -52,23 +54,42 @@ func (mySvc MysqlService) SaveData(sinkData []interface{}) error {
 		return nil
 	}
 
-	prepareStatement := fmt.Sprintf("INSERT INTO %s (namespace,kind,name,type,reason,message,event_id,first_occurrence_time,last_occurrence_time) VALUES(?,?,?,?,?,?,?,?,?)", mySvc.table)
-
-	// Prepare statement for inserting data
-	stmtIns, err := mySvc.db.Prepare(prepareStatement)
-	if err != nil {
-		klog.Errorf("failed to Prepare statement for inserting data. SQL: %v, err: %v", prepareStatement, err)
-		return err
-	}
-
-	defer stmtIns.Close()
+	// Only the events of a source with a cluster name are inserted with the cluster column, so the
+	// tables without it keep working for a single cluster.
+	statements := map[bool]*sql.Stmt{}
+	defer func() {
+		for _, stmt := range statements {
+			stmt.Close()
+		}
+	}()
 
 	for _, data := range sinkData {
 
 		ked := data.(MysqlKubeEventPoint)
+		withCluster := ked.Cluster != ""
+		stmtIns, found := statements[withCluster]
+		if !found {
+			prepareStatement := fmt.Sprintf("INSERT INTO %s (namespace,kind,name,type,reason,message,event_id,first_occurrence_time,last_occurrence_time) VALUES(?,?,?,?,?,?,?,?,?)", mySvc.table)
+			if withCluster {
+				prepareStatement = fmt.Sprintf("INSERT INTO %s (namespace,kind,name,type,reason,message,event_id,first_occurrence_time,last_occurrence_time,cluster) VALUES(?,?,?,?,?,?,?,?,?,?)", mySvc.table)
+			}
+			// Prepare statement for inserting data
+			var err error
+			stmtIns, err = mySvc.db.Prepare(prepareStatement)
+			if err != nil {
+				klog.Errorf("failed to Prepare statement for inserting data. SQL: %v, err: %v", prepareStatement, err)
+				return err
+			}
+			statements[withCluster] = stmtIns
+		}
+
 		klog.V(7).Infof("Begin Insert Mysql Data ...")
-		klog.V(8).Infof("Namespace: %s, Kind: %s, Name: %s, Type: %s, Reason: %s, Message: %s, EventID: %s, FirstOccurrenceTimestamp: %s, LastOccurrenceTimestamp: %s ", ked.Namespace, ked.Kind, ked.Name, ked.Type, ked.Reason, ked.Message, ked.EventID, ked.FirstOccurrenceTimestamp, ked.LastOccurrenceTimestamp)
-		_, err = stmtIns.Exec(ked.Namespace, ked.Kind, ked.Name, ked.Type, ked.Reason, ked.Message, ked.EventID, ked.FirstOccurrenceTimestamp, ked.LastOccurrenceTimestamp)
+		klog.V(8).Infof("Cluster: %s, Namespace: %s, Kind: %s, Name: %s, Type: %s, Reason: %s, Message: %s, EventID: %s, FirstOccurrenceTimestamp: %s, LastOccurrenceTimestamp: %s ", ked.Cluster, ked.Namespace, ked.Kind, ked.Name, ked.Type, ked.Reason, ked.Message, ked.EventID, ked.FirstOccurrenceTimestamp, ked.LastOccurrenceTimestamp)
+		args := []interface{}{ked.Namespace, ked.Kind, ked.Name, ked.Type, ked.Reason, ked.Message, ked.EventID, ked.FirstOccurrenceTimestamp, ked.LastOccurrenceTimestamp}
+		if withCluster {
+			args = append(args, ked.Cluster)
+		}
+		_, err := stmtIns.Exec(args...)
 		if err != nil {
 			klog.Errorf("failed to Prepare statement for inserting data ")
 			return err
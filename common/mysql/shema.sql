@@ -13,6 +13,7 @@ CREATE TABLE `kube_event` (
   `event_id` varchar(255) DEFAULT '',
   `first_occurrence_time` varchar(255) DEFAULT '',
   `last_occurrence_time` varchar(255) DEFAULT '',
+  `cluster` varchar(255) DEFAULT '',
   `update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
 
   PRIMARY KEY (`id`)
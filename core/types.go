@@ -15,6 +15,7 @@
 package core
 
 import (
+	"errors"
 	"time"
 
 	kube_api "k8s.io/api/core/v1"
@@ -34,6 +35,24 @@ type EventSource interface {
 	GetNewEvents() *EventBatch
 }
 
+// A source which persists the position of the events it handed out, to resume from there after a restart.
+type CheckpointedSource interface {
+	EventSource
+	// Enables or disables persisting the position. A standby replica must not overwrite the position of the leader.
+	SetCheckpointing(enabled bool)
+	// Loads the persisted position and returns whether an event was handed out before it was persisted,
+	// or nil if no position is persisted.
+	LoadDelivered() (func(*kube_api.Event) bool, error)
+}
+
+// A stage between the source and the sinks, e.g. filtering the events all sinks receive.
+type EventProcessor interface {
+	Name() string
+
+	// Returns the batch to pass on to the next processor or the sinks. It may modify the given batch.
+	Process(*EventBatch) *EventBatch
+}
+
 type EventSink interface {
 	Name() string
 
@@ -44,3 +63,61 @@ type EventSink interface {
 	// Stops the sink at earliest convenience.
 	Stop()
 }
+
+// ExportFailure describes an event that a sink failed to export.
+type ExportFailure struct {
+	Event *kube_api.Event
+	Err   error
+	// Whether exporting the event again may succeed, e.g. after a network error.
+	Retryable bool
+}
+
+// A sink that reports the events it failed to export, so that the sink manager can retry them.
+type ReportingEventSink interface {
+	EventSink
+
+	// Exports data like ExportEvents and returns the events of the batch that were not exported.
+	ExportEventsWithResult(*EventBatch) []ExportFailure
+}
+
+// A sink that holds events back to send them later, e.g. in the summary of a time window. The spooled
+// sink manager keeps the events it holds in the spool until they are sent.
+type HoldingEventSink interface {
+	EventSink
+
+	// Returns when the oldest event the sink holds was exported to it, or the zero time if it holds none.
+	HeldSince() time.Time
+}
+
+// RetryableError marks an export error as transient.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// NewRetryableError marks err as transient.
+func NewRetryableError(err error) error {
+	return &RetryableError{Err: err}
+}
+
+// IsRetryable reports whether err or any error it wraps is a RetryableError.
+func IsRetryable(err error) bool {
+	var retryable *RetryableError
+	return errors.As(err, &retryable)
+}
+
+// NewExportFailure returns the failure of event, retryable if err is a RetryableError.
+func NewExportFailure(event *kube_api.Event, err error) ExportFailure {
+	return ExportFailure{
+		Event:     event,
+		Err:       err,
+		Retryable: IsRetryable(err),
+	}
+}
@@ -17,20 +17,30 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/AliyunContainerService/kube-eventer/api"
+	"github.com/AliyunContainerService/kube-eventer/common/filters"
 	"github.com/AliyunContainerService/kube-eventer/common/flags"
+	"github.com/AliyunContainerService/kube-eventer/common/kubernetes"
+	"github.com/AliyunContainerService/kube-eventer/common/leaderelection"
+	"github.com/AliyunContainerService/kube-eventer/core"
 	"github.com/AliyunContainerService/kube-eventer/manager"
+	eventprocessors "github.com/AliyunContainerService/kube-eventer/processors"
 	"github.com/AliyunContainerService/kube-eventer/sinks"
+	"github.com/AliyunContainerService/kube-eventer/sinks/spool"
 	"github.com/AliyunContainerService/kube-eventer/sources"
 	"github.com/AliyunContainerService/kube-eventer/version"
 	"k8s.io/klog/v2"
@@ -45,6 +55,25 @@ var (
 	argEventMetrics bool
 	argHealthzIP    = flag.String("healthz-ip", "0.0.0.0", "ip eventer health check service uses")
 	argHealthzPort  = flag.Uint("healthz-port", 8084, "port eventer health check listens on")
+	argSpoolDir     = flag.String("spool-dir", "", "directory of the on-disk event spool between source and sinks. Empty disables the spool")
+	argSpoolMaxSize = flag.Int64("spool-max-size", 1<<30, "max bytes kept in the event spool, older events are dropped first. 0 for no limit")
+	argSpoolMaxAge  = flag.Duration("spool-max-age", 24*time.Hour, "max age of events kept in the event spool. 0 for no limit")
+
+	argSinkRetryMax            = flag.Int("sink-retry-max", sinks.DefaultRetryPolicy.MaxRetries, "max number of retries of events a sink failed to export with a retryable error. 0 disables retries")
+	argSinkRetryInitialBackoff = flag.Duration("sink-retry-initial-backoff", sinks.DefaultRetryPolicy.InitialBackoff, "time to wait before the first retry, doubled with every further retry")
+	argSinkRetryMaxBackoff     = flag.Duration("sink-retry-max-backoff", sinks.DefaultRetryPolicy.MaxBackoff, "max time to wait between two retries")
+	argSinkRetryJitter         = flag.Float64("sink-retry-jitter", sinks.DefaultRetryPolicy.Jitter, "fraction between 0 and 1 by which the time between two retries is randomized")
+
+	argDedupWindow = flag.Duration("dedup-window", 0, "window in which repetitions of an event are suppressed and then summarized, for all sinks. 0 disables deduplication")
+	argEventFilter = flag.String("event-filter", "", "filter rules applied to the events of all sinks, in the query format of the sink options, e.g. level=Warning&exclude_namespaces=kube-system")
+
+	argLeaderElect              = flag.Bool("leader-elect", false, "elect a leader among the replicas with a Lease, only the leader exports events")
+	argLeaderElectLeaseName     = flag.String("leader-elect-lease-name", "kube-eventer", "name of the Lease used for leader election")
+	argLeaderElectNamespace     = flag.String("leader-elect-namespace", "kube-system", "namespace of the Lease used for leader election")
+	argLeaderElectKubernetes    = flag.String("leader-elect-kubernetes", "", "cluster of the Lease in the format of the kubernetes source, e.g. https://kubernetes.default. Empty for the cluster of the first kubernetes source")
+	argLeaderElectLeaseDuration = flag.Duration("leader-elect-lease-duration", 15*time.Second, "time standbys wait before taking over the lease of a leader that stopped renewing it")
+	argLeaderElectRenewDeadline = flag.Duration("leader-elect-renew-deadline", 10*time.Second, "time the leader retries renewing the lease before it gives up the leadership")
+	argLeaderElectRetryPeriod   = flag.Duration("leader-elect-retry-period", 2*time.Second, "time between two attempts to acquire or renew the lease")
 )
 
 func main() {
@@ -68,24 +97,25 @@ func main() {
 
 	setMaxProcs()
 
-	klog.Infof(strings.Join(os.Args, " "))
+	klog.Info(strings.Join(os.Args, " "))
 	klog.Info(version.VersionInfo())
 	if err := validateFlags(); err != nil {
 		klog.Fatal(err)
 	}
 
 	// sources
-	if len(argSources) != 1 {
+	if len(argSources) == 0 {
 		klog.Fatal("Wrong number of sources specified")
 	}
 	sourceFactory := sources.NewSourceFactory()
-	sources, err := sourceFactory.BuildAll(argSources, argEventMetrics)
+	sourceList, err := sourceFactory.BuildAll(argSources, argEventMetrics)
 	if err != nil {
 		klog.Fatalf("Failed to create sources: %v", err)
 	}
-	if len(sources) != 1 {
-		klog.Fatal("Requires exactly 1 source")
+	if len(sourceList) == 0 {
+		klog.Fatal("No available source to use")
 	}
+	source := sources.NewCombinedSource(sourceList)
 
 	// sinks
 	sinksFactory := sinks.NewSinkFactory()
@@ -97,13 +127,41 @@ func main() {
 	for _, sink := range sinkList {
 		klog.Infof("Starting with %s sink", sink.Name())
 	}
-	sinkManager, err := sinks.NewEventSinkManager(sinkList, sinks.DefaultSinkExportEventsTimeout, sinks.DefaultSinkStopTimeout)
+	retryPolicy := sinks.RetryPolicy{
+		MaxRetries:     *argSinkRetryMax,
+		InitialBackoff: *argSinkRetryInitialBackoff,
+		MaxBackoff:     *argSinkRetryMaxBackoff,
+		Multiplier:     sinks.DefaultRetryPolicy.Multiplier,
+		Jitter:         *argSinkRetryJitter,
+	}
+	var sinkManager core.EventSink
+	if *argSpoolDir != "" {
+		eventSpool, spoolErr := spool.Open(*argSpoolDir, *argSpoolMaxSize, *argSpoolMaxAge)
+		if spoolErr != nil {
+			klog.Fatalf("Failed to open event spool: %v", spoolErr)
+		}
+		sinkManager, err = sinks.NewSpooledEventSinkManager(sinkList, eventSpool, sinks.DefaultSinkStopTimeout, retryPolicy)
+	} else {
+		sinkManager, err = sinks.NewEventSinkManager(sinkList, sinks.DefaultSinkExportEventsTimeout, sinks.DefaultSinkStopTimeout, retryPolicy)
+	}
 	if err != nil {
 		klog.Fatalf("Failed to create sink manager: %v", err)
 	}
 
+	// processors
+	processors, err := buildProcessors()
+	if err != nil {
+		klog.Fatalf("Failed to create processors: %v", err)
+	}
+
 	// main manager
-	manager, err := manager.NewManager(sources[0], sinkManager, *argFrequency)
+	if *argLeaderElect {
+		go startHTTPServer()
+		runWithLeaderElection(sourceList, processors, sinkManager)
+		return
+	}
+
+	manager, err := manager.NewManager(source, processors, sinkManager, *argFrequency)
 	if err != nil {
 		klog.Fatalf("Failed to create main manager: %v", err)
 	}
@@ -116,9 +174,102 @@ func main() {
 	<-quitChannel
 }
 
+// buildProcessors returns the processors applied to the events of all sinks.
+func buildProcessors() ([]core.EventProcessor, error) {
+	processors := []core.EventProcessor{}
+	if *argEventFilter != "" {
+		opts, err := url.ParseQuery(*argEventFilter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event filter %q: %v", *argEventFilter, err)
+		}
+		filter, err := filters.NewRuleFilter(opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event filter %q: %v", *argEventFilter, err)
+		}
+		if filter != nil {
+			processors = append(processors, eventprocessors.NewFilterProcessor("global", filter))
+		}
+	}
+	if *argDedupWindow > 0 {
+		processors = append(processors, eventprocessors.NewDedupProcessor("global", *argDedupWindow))
+	}
+	return processors, nil
+}
+
+// runWithLeaderElection keeps the sources watching on a standby and starts exporting events
+// once this replica becomes the leader.
+func runWithLeaderElection(sourceList []core.EventSource, processors []core.EventProcessor, sinkManager core.EventSink) {
+	// with several clusters, the lease is taken in the cluster of the first kubernetes source unless configured.
+	var leaseCluster *url.URL
+	if *argLeaderElectKubernetes != "" {
+		var err error
+		if leaseCluster, err = url.Parse(*argLeaderElectKubernetes); err != nil {
+			klog.Fatalf("Invalid leader-elect-kubernetes %q: %v", *argLeaderElectKubernetes, err)
+		}
+	}
+	kubeClient, err := kubernetes.GetKubernetesClient(leaseCluster)
+	if err != nil || kubeClient == nil {
+		klog.Fatalf("Leader election requires the kubernetes source or leader-elect-kubernetes: %v", err)
+	}
+
+	// Keep the events the previous leader may not have exported before its lease expired. Every
+	// source is wrapped on its own, as each persists its own position.
+	var standbys []*manager.StandbySource
+	var standbySources []core.EventSource
+	for _, source := range sourceList {
+		standby := manager.NewStandbySource(source, *argLeaderElectLeaseDuration+*argFrequency, *argFrequency)
+		standbys = append(standbys, standby)
+		standbySources = append(standbySources, standby)
+	}
+	eventManager, err := manager.NewManager(sources.NewCombinedSource(standbySources), processors, sinkManager, *argFrequency)
+	if err != nil {
+		klog.Fatalf("Failed to create main manager: %v", err)
+	}
+
+	// Cancelling the context on termination releases the lease, so a new replica does not wait for it to expire.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		klog.Infof("Received %v, releasing the lease", sig)
+		cancel()
+	}()
+
+	started := make(chan struct{})
+	err = leaderelection.Run(ctx, kubeClient, leaderelection.Config{
+		LeaseName:      *argLeaderElectLeaseName,
+		LeaseNamespace: *argLeaderElectNamespace,
+		LeaseDuration:  *argLeaderElectLeaseDuration,
+		RenewDeadline:  *argLeaderElectRenewDeadline,
+		RetryPeriod:    *argLeaderElectRetryPeriod,
+	}, func() {
+		for _, standby := range standbys {
+			standby.Promote()
+		}
+		eventManager.Start()
+		close(started)
+		klog.Infof("Starting eventer")
+	})
+	if err != nil {
+		klog.Fatalf("Failed to run leader election: %v", err)
+	}
+	select {
+	case <-started:
+		// stops the sinks.
+		eventManager.Stop()
+	default:
+	}
+}
+
 func startHTTPServer() {
 	http.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(200)
+		if *argLeaderElect {
+			w.Write([]byte("ok: " + leaderelection.Role()))
+			return
+		}
 		w.Write([]byte("ok"))
 	})
 
@@ -137,6 +288,31 @@ func validateFlags() error {
 			*argFrequency)
 	}
 
+	if *argSpoolMaxSize < 0 || *argSpoolMaxAge < 0 {
+		return fmt.Errorf("spool caps can not be negative")
+	}
+
+	if *argDedupWindow < 0 {
+		return fmt.Errorf("dedup window can not be negative, supplied %s", *argDedupWindow)
+	}
+
+	if *argSinkRetryMax < 0 {
+		return fmt.Errorf("sink retries can not be negative, supplied %d", *argSinkRetryMax)
+	}
+	if *argSinkRetryJitter < 0 || *argSinkRetryJitter > 1 {
+		return fmt.Errorf("sink retry jitter needs to be between 0 and 1, supplied %v", *argSinkRetryJitter)
+	}
+
+	if *argLeaderElect {
+		if *argLeaderElectLeaseName == "" || *argLeaderElectNamespace == "" {
+			return fmt.Errorf("leader election needs a lease name and namespace")
+		}
+		if *argLeaderElectRetryPeriod <= 0 || *argLeaderElectRenewDeadline <= *argLeaderElectRetryPeriod ||
+			*argLeaderElectLeaseDuration <= *argLeaderElectRenewDeadline {
+			return fmt.Errorf("leader election needs 0 < retry period < renew deadline < lease duration")
+		}
+	}
+
 	if *argFrequency > api.MaxEventsScrapeDelay {
 		return fmt.Errorf("frequency needs to be no greater than %s, supplied %s",
 			api.MaxEventsScrapeDelay, *argFrequency)
@@ -20,7 +20,9 @@ import (
 
 	"github.com/AliyunContainerService/kube-eventer/core"
 	"github.com/AliyunContainerService/kube-eventer/util"
+	"github.com/stretchr/testify/assert"
 	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestFlow(t *testing.T) {
@@ -32,7 +34,7 @@ func TestFlow(t *testing.T) {
 	source := util.NewDummySource(batch)
 	sink := util.NewDummySink("sink", time.Millisecond)
 
-	manager, _ := NewManager(source, sink, time.Second)
+	manager, _ := NewManager(source, nil, sink, time.Second)
 	manager.Start()
 
 	// 4-5 cycles
@@ -43,3 +45,74 @@ func TestFlow(t *testing.T) {
 		t.Fatalf("Wrong number of exports executed: %d", sink.GetExportCount())
 	}
 }
+
+func TestStandbySourceRetainsRecentEvents(t *testing.T) {
+	now := time.Now()
+	batch := &core.EventBatch{
+		Timestamp: now,
+		Events: []*kube_api.Event{
+			{Message: "old", LastTimestamp: metav1.NewTime(now.Add(-time.Hour))},
+			{Message: "recent", LastTimestamp: metav1.NewTime(now)},
+		},
+	}
+	standby := NewStandbySource(util.NewDummySource(batch), time.Minute, time.Hour)
+
+	assert.Empty(t, standby.GetNewEvents().Events)
+
+	standby.Promote()
+	events := standby.GetNewEvents().Events
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, "recent", events[0].Message)
+	assert.Equal(t, "old", events[1].Message)
+
+	assert.Equal(t, 2, len(standby.GetNewEvents().Events))
+}
+
+// checkpointedSource records whether it may persist its position.
+type checkpointedSource struct {
+	core.EventSource
+	checkpointing []bool
+	delivered     func(*kube_api.Event) bool
+}
+
+func (c *checkpointedSource) SetCheckpointing(enabled bool) {
+	c.checkpointing = append(c.checkpointing, enabled)
+}
+
+func (c *checkpointedSource) LoadDelivered() (func(*kube_api.Event) bool, error) {
+	return c.delivered, nil
+}
+
+func TestStandbySourceCheckpointsOnlyWhenPromoted(t *testing.T) {
+	source := &checkpointedSource{EventSource: util.NewDummySource(&core.EventBatch{Events: []*kube_api.Event{}})}
+	standby := NewStandbySource(source, time.Minute, time.Hour)
+	assert.Equal(t, []bool{false}, source.checkpointing)
+
+	standby.Promote()
+	assert.Equal(t, []bool{false, true}, source.checkpointing)
+}
+
+func TestStandbySourceSkipsEventsOfPreviousLeader(t *testing.T) {
+	now := time.Now()
+	batch := &core.EventBatch{
+		Timestamp: now,
+		Events: []*kube_api.Event{
+			{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "10"}, LastTimestamp: metav1.NewTime(now)},
+			{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "20"}, LastTimestamp: metav1.NewTime(now)},
+		},
+	}
+	source := &checkpointedSource{
+		EventSource: util.NewDummySource(batch),
+		delivered: func(event *kube_api.Event) bool {
+			return event.ResourceVersion == "10"
+		},
+	}
+	standby := NewStandbySource(source, time.Minute, time.Hour)
+	assert.Empty(t, standby.GetNewEvents().Events)
+
+	standby.Promote()
+	events := standby.GetNewEvents().Events
+	// the retained event the previous leader exported is skipped, the events of the batch are not retained.
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, "20", events[0].ResourceVersion)
+}
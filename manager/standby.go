@@ -0,0 +1,122 @@
+package manager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	kube_api "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// StandbySource wraps the source of a standby replica. Until Promote is called it keeps
+// draining the source, so the watch stays warm and its buffer does not overflow, and it
+// retains the events of the last retention period. The first GetNewEvents after Promote
+// returns the retained events too, covering the events the previous leader may have missed
+// before its lease expired. The events the checkpoint of the previous leader covers are skipped.
+type StandbySource struct {
+	source    core.EventSource
+	retention time.Duration
+
+	lock     sync.Mutex
+	promoted bool
+	retained []*kube_api.Event
+	stopChan chan struct{}
+}
+
+func NewStandbySource(source core.EventSource, retention time.Duration, frequency time.Duration) *StandbySource {
+	// Only the leader persists the position of the source.
+	if checkpointed, ok := source.(core.CheckpointedSource); ok {
+		checkpointed.SetCheckpointing(false)
+	}
+	s := &StandbySource{
+		source:    source,
+		retention: retention,
+		stopChan:  make(chan struct{}),
+	}
+	go s.drain(frequency)
+	return s
+}
+
+func (s *StandbySource) drain(frequency time.Duration) {
+	ticker := time.NewTicker(frequency)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.lock.Lock()
+			s.retain(s.source.GetNewEvents().Events)
+			s.lock.Unlock()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// retain adds events and drops the ones older than the retention period.
+func (s *StandbySource) retain(events []*kube_api.Event) {
+	s.retained = append(s.retained, events...)
+	oldest := time.Now().Add(-s.retention)
+	kept := s.retained[:0]
+	for _, event := range s.retained {
+		if !util.GetLastEventTimestamp(event).Before(oldest) {
+			kept = append(kept, event)
+		}
+	}
+	for i := len(kept); i < len(s.retained); i++ {
+		s.retained[i] = nil
+	}
+	s.retained = kept
+}
+
+func (s *StandbySource) dropDelivered(delivered func(*kube_api.Event) bool) {
+	kept := make([]*kube_api.Event, 0, len(s.retained))
+	for _, event := range s.retained {
+		if !delivered(event) {
+			kept = append(kept, event)
+		}
+	}
+	klog.Infof("Skipping %d retained events the previous leader has exported", len(s.retained)-len(kept))
+	s.retained = kept
+}
+
+// Promote stops draining the source. It is called once the replica becomes the leader.
+func (s *StandbySource) Promote() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.promoted {
+		return
+	}
+	s.promoted = true
+	close(s.stopChan)
+	if checkpointed, ok := s.source.(core.CheckpointedSource); ok {
+		// The previous leader has already exported the events up to the position it persisted.
+		delivered, err := checkpointed.LoadDelivered()
+		if err != nil {
+			klog.Errorf("Failed to load the checkpoint of the previous leader, exporting all retained events: %v", err)
+		} else if delivered != nil {
+			s.dropDelivered(delivered)
+		}
+		checkpointed.SetCheckpointing(true)
+	}
+	klog.Infof("Promoted to leader with %d retained events", len(s.retained))
+}
+
+func (s *StandbySource) GetNewEvents() *core.EventBatch {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	batch := s.source.GetNewEvents()
+	if !s.promoted {
+		s.retain(batch.Events)
+		return &core.EventBatch{Timestamp: batch.Timestamp, Events: []*kube_api.Event{}}
+	}
+	if len(s.retained) > 0 {
+		batch = &core.EventBatch{
+			Timestamp: batch.Timestamp,
+			Events:    append(s.retained, batch.Events...),
+		}
+		s.retained = nil
+	}
+	return batch
+}
@@ -1,6 +1,8 @@
 package prometheus
 
 import (
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 	v1 "k8s.io/api/core/v1"
 )
@@ -143,7 +145,12 @@ var (
 	}
 )
 
+// InitMetrics registers the event metrics. It is safe to call it once per source.
 func InitMetrics() {
+	initMetricsOnce.Do(initMetrics)
+}
+
+func initMetrics() {
 	normalEventCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "eventer",
@@ -174,6 +181,8 @@ func InitMetrics() {
 	prometheus.MustRegister(errorEventCounter)
 }
 
+var initMetricsOnce sync.Once
+
 func event2Labels(kind AbnormalEventReason, event *v1.Event) []string {
 	return []string{
 		string(kind),
@@ -211,6 +220,11 @@ func triageEvent(event *v1.Event) (AbnormalEventReason, bool) {
 	return "", false
 }
 
+// TriageEvent classifies an abnormal event, it returns false for events which are not abnormal.
+func TriageEvent(event *v1.Event) (AbnormalEventReason, bool) {
+	return triageEvent(event)
+}
+
 // RecordEvent records event to prometheus metrics
 func RecordEvent(event *v1.Event) {
 	if kind, ok := triageEvent(event); ok {
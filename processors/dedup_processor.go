@@ -0,0 +1,172 @@
+package processors
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	"github.com/prometheus/client_golang/prometheus"
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DedupWindowOption is the option of a sink setting the window of its DedupProcessor.
+const DedupWindowOption = "dedup_window"
+
+var (
+	// Events suppressed as duplicates.
+	suppressedEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "eventer",
+			Subsystem: "processor",
+			Name:      "suppressed_events_total",
+			Help:      "Events suppressed as duplicates of an event passed on in the same window.",
+		},
+		[]string{"processor"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(suppressedEvents)
+}
+
+// dedupKey identifies repeated events.
+type dedupKey struct {
+	cluster   string
+	kind      string
+	namespace string
+	name      string
+	reason    string
+	message   string
+}
+
+func newDedupKey(event *kube_api.Event) dedupKey {
+	return dedupKey{
+		cluster:   util.GetClusterName(event, ""),
+		kind:      event.InvolvedObject.Kind,
+		namespace: event.InvolvedObject.Namespace,
+		name:      event.InvolvedObject.Name,
+		reason:    event.Reason,
+		message:   event.Message,
+	}
+}
+
+// dedupEntry is the state of a key within its current window.
+type dedupEntry struct {
+	windowStart time.Time
+	// Occurrences in the window, including the event passed on.
+	occurrences int32
+	// Occurrences not passed on yet.
+	suppressed int32
+	last       *kube_api.Event
+	lastUID    types.UID
+	lastCount  int32
+}
+
+// DedupProcessor passes on the first occurrence of an event and suppresses its repetitions,
+// keyed on involved object, reason and message, for the window. Once the window is over, an
+// event with the message "<message> (occurred N times in the last M minutes)" summarizes
+// the suppressed repetitions and a new window starts.
+type DedupProcessor struct {
+	name    string
+	window  time.Duration
+	entries map[dedupKey]*dedupEntry
+	now     func() time.Time
+}
+
+func NewDedupProcessor(name string, window time.Duration) *DedupProcessor {
+	return &DedupProcessor{
+		name:    name,
+		window:  window,
+		entries: make(map[dedupKey]*dedupEntry),
+		now:     time.Now,
+	}
+}
+
+func (dp *DedupProcessor) Name() string {
+	return "DedupProcessor"
+}
+
+func (dp *DedupProcessor) Process(batch *core.EventBatch) *core.EventBatch {
+	now := dp.now()
+	result := &core.EventBatch{
+		Timestamp: batch.Timestamp,
+		Events:    dp.flush(now),
+	}
+
+	for _, event := range batch.Events {
+		key := newDedupKey(event)
+		entry, found := dp.entries[key]
+		if !found {
+			dp.entries[key] = &dedupEntry{
+				windowStart: now,
+				occurrences: 1,
+				last:        event,
+				lastUID:     event.UID,
+				lastCount:   event.Count,
+			}
+			result.Events = append(result.Events, event)
+			continue
+		}
+
+		// The watch delivers every update of a repeated event, its count tells how often it occurred meanwhile.
+		occurrences := int32(1)
+		if event.UID == entry.lastUID && event.Count > entry.lastCount {
+			occurrences = event.Count - entry.lastCount
+		}
+		entry.occurrences += occurrences
+		entry.suppressed += occurrences
+		entry.last = event
+		entry.lastUID = event.UID
+		entry.lastCount = event.Count
+		suppressedEvents.WithLabelValues(dp.name).Add(float64(occurrences))
+	}
+	return result
+}
+
+// flush returns the summaries of the windows that are over and starts new windows for them.
+func (dp *DedupProcessor) flush(now time.Time) []*kube_api.Event {
+	summaries := []*kube_api.Event{}
+	for key, entry := range dp.entries {
+		if now.Sub(entry.windowStart) < dp.window {
+			continue
+		}
+		if entry.suppressed == 0 {
+			delete(dp.entries, key)
+			continue
+		}
+		summaries = append(summaries, dp.summarize(entry, now))
+		entry.windowStart = now
+		entry.occurrences = 0
+		entry.suppressed = 0
+	}
+	return summaries
+}
+
+func (dp *DedupProcessor) summarize(entry *dedupEntry, now time.Time) *kube_api.Event {
+	summary := entry.last.DeepCopy()
+	times := "times"
+	if entry.occurrences == 1 {
+		times = "time"
+	}
+	summary.Message = fmt.Sprintf("%s (occurred %d %s in the last %s)",
+		entry.last.Message, entry.occurrences, times, formatWindow(now.Sub(entry.windowStart)))
+	summary.Count = entry.occurrences
+	if summary.LastTimestamp.IsZero() {
+		summary.LastTimestamp = metav1.NewTime(now)
+	}
+	return summary
+}
+
+func formatWindow(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	minutes := int(d.Round(time.Minute) / time.Minute)
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
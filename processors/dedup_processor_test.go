@@ -0,0 +1,76 @@
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newBackOffEvent(count int32) *kube_api.Event {
+	return &kube_api.Event{
+		ObjectMeta:     metav1.ObjectMeta{UID: types.UID("backoff")},
+		InvolvedObject: kube_api.ObjectReference{Kind: "Pod", Namespace: "default", Name: "nginx"},
+		Reason:         "BackOff",
+		Message:        "Back-off restarting failed container",
+		Count:          count,
+	}
+}
+
+func TestDedupProcessor(t *testing.T) {
+	now := time.Now()
+	dp := NewDedupProcessor("test", 5*time.Minute)
+	dp.now = func() time.Time { return now }
+	process := func(events ...*kube_api.Event) []*kube_api.Event {
+		return dp.Process(&core.EventBatch{Timestamp: now, Events: events}).Events
+	}
+
+	// The first occurrence is passed on.
+	events := process(newBackOffEvent(1))
+	assert.Equal(t, 1, len(events))
+
+	// Repetitions within the window are suppressed, another event is not.
+	other := newBackOffEvent(1)
+	other.InvolvedObject.Name = "redis"
+	events = process(newBackOffEvent(2), newBackOffEvent(5), other)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "redis", events[0].InvolvedObject.Name)
+
+	// Once the window is over, the repetitions are summarized.
+	now = now.Add(5 * time.Minute)
+	events = process()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "Back-off restarting failed container (occurred 5 times in the last 5 minutes)", events[0].Message)
+	assert.Equal(t, int32(5), events[0].Count)
+	assert.Equal(t, "Back-off restarting failed container", newBackOffEvent(1).Message)
+
+	// A new window started, an event without repetitions is forgotten.
+	events = process(newBackOffEvent(6))
+	assert.Empty(t, events)
+	now = now.Add(5 * time.Minute)
+	events = process()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "Back-off restarting failed container (occurred 1 time in the last 5 minutes)", events[0].Message)
+	assert.Equal(t, 1, len(dp.entries))
+
+	now = now.Add(5 * time.Minute)
+	assert.Empty(t, process())
+	assert.Empty(t, dp.entries)
+	assert.Equal(t, 1, len(process(newBackOffEvent(7))))
+}
+
+func TestDedupProcessorSuppressedMetric(t *testing.T) {
+	dp := NewDedupProcessor("metric", 5*time.Minute)
+	dp.Process(&core.EventBatch{Events: []*kube_api.Event{newBackOffEvent(1)}})
+	dp.Process(&core.EventBatch{Events: []*kube_api.Event{newBackOffEvent(2), newBackOffEvent(5)}})
+
+	// The updates with the counts 2 and 5 stand for 4 suppressed occurrences.
+	metric := &dto.Metric{}
+	assert.NoError(t, suppressedEvents.WithLabelValues("metric").Write(metric))
+	assert.Equal(t, float64(4), metric.GetCounter().GetValue())
+}
@@ -0,0 +1,54 @@
+package processors
+
+import (
+	"github.com/AliyunContainerService/kube-eventer/common/filters"
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/prometheus/client_golang/prometheus"
+	kube_api "k8s.io/api/core/v1"
+)
+
+var (
+	// Events dropped by event filters.
+	filteredEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "eventer",
+			Subsystem: "processor",
+			Name:      "filtered_events_total",
+			Help:      "Events dropped by event filters.",
+		},
+		[]string{"processor"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(filteredEvents)
+}
+
+// FilterProcessor passes on the events matching the filter.
+type FilterProcessor struct {
+	// Name of the stage in the metrics, e.g. global or the name of a sink.
+	name   string
+	filter filters.Filter
+}
+
+func NewFilterProcessor(name string, filter filters.Filter) *FilterProcessor {
+	return &FilterProcessor{name: name, filter: filter}
+}
+
+func (fp *FilterProcessor) Name() string {
+	return "FilterProcessor"
+}
+
+func (fp *FilterProcessor) Process(batch *core.EventBatch) *core.EventBatch {
+	events := make([]*kube_api.Event, 0, len(batch.Events))
+	for _, event := range batch.Events {
+		if fp.filter.Filter(event) {
+			events = append(events, event)
+		}
+	}
+	filteredEvents.WithLabelValues(fp.name).Add(float64(len(batch.Events) - len(events)))
+	return &core.EventBatch{
+		Timestamp: batch.Timestamp,
+		Events:    events,
+	}
+}
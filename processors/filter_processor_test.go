@@ -0,0 +1,27 @@
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/common/filters"
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/stretchr/testify/assert"
+	kube_api "k8s.io/api/core/v1"
+)
+
+func TestFilterProcessor(t *testing.T) {
+	filter, err := filters.NewRuleFilter(map[string][]string{"exclude_namespaces": {"kube-system"}})
+	assert.NoError(t, err)
+	batch := &core.EventBatch{
+		Timestamp: time.Now(),
+		Events: []*kube_api.Event{
+			{Message: "a", InvolvedObject: kube_api.ObjectReference{Namespace: "default"}},
+			{Message: "b", InvolvedObject: kube_api.ObjectReference{Namespace: "kube-system"}},
+		},
+	}
+	result := NewFilterProcessor("test", filter).Process(batch)
+	assert.Equal(t, batch.Timestamp, result.Timestamp)
+	assert.Equal(t, 1, len(result.Events))
+	assert.Equal(t, "a", result.Events[0].Message)
+}
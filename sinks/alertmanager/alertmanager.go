@@ -0,0 +1,239 @@
+package alertmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	metrics "github.com/AliyunContainerService/kube-eventer/metrics/prometheus"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	SinkName = "AlertmanagerSink"
+
+	alertsPath       = "/api/v2/alerts"
+	defaultAlertName = "KubernetesEvent"
+	// Alerts resolve an hour after the event was last seen, unless it happens again.
+	defaultEndsAt = time.Hour
+)
+
+// Label names of Prometheus.
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Alert is a postable alert of the Alertmanager v2 API.
+type Alert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt,omitempty"`
+	EndsAt       *time.Time        `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+/*
+alertmanager sink usage
+--sink=alertmanager:http://alertmanager.monitoring:9093?level=Warning&ends_at=1h&label=team:infra
+
+level: Normal or Warning. The event level greater than global level will emit.
+label: <name>:<value>, a label added to every alert.
+alertname: alertname label of the alerts (default KubernetesEvent).
+ends_at: duration after the last occurrence of an event before its alert resolves (default 1h), 0 leaves it to Alertmanager.
+generator_url: link shown with the alerts in Alertmanager.
+*/
+type AlertmanagerSink struct {
+	endpoint     string
+	username     string
+	password     string
+	level        int
+	alertName    string
+	labels       map[string]string
+	endsAt       time.Duration
+	generatorURL string
+	client       *http.Client
+}
+
+func (a *AlertmanagerSink) Name() string {
+	return SinkName
+}
+
+func (a *AlertmanagerSink) Stop() {
+	// do nothing
+}
+
+func (a *AlertmanagerSink) ExportEvents(batch *core.EventBatch) {
+	for _, failure := range a.ExportEventsWithResult(batch) {
+		klog.Errorf("failed to send event to alertmanager, because of %v", failure.Err)
+	}
+}
+
+func (a *AlertmanagerSink) ExportEventsWithResult(batch *core.EventBatch) []core.ExportFailure {
+	var events []*v1.Event
+	var alerts []*Alert
+	for _, event := range batch.Events {
+		if util.GetLevel(event.Type) < a.level {
+			continue
+		}
+		events = append(events, event)
+		alerts = append(alerts, a.createAlertFromEvent(event))
+	}
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	// Alertmanager validates the alerts of a request together, so they succeed or fail together.
+	if err := a.send(alerts); err != nil {
+		failures := make([]core.ExportFailure, 0, len(events))
+		for _, event := range events {
+			failures = append(failures, core.NewExportFailure(event, err))
+		}
+		return failures
+	}
+	return nil
+}
+
+// createAlertFromEvent labels the alert with the involved object and reason of the event, so that
+// repeated occurrences update the same alert instead of creating new ones.
+func (a *AlertmanagerSink) createAlertFromEvent(event *v1.Event) *Alert {
+	namespace := event.InvolvedObject.Namespace
+	if namespace == "" {
+		namespace = event.Namespace
+	}
+
+	labels := map[string]string{}
+	for name, value := range a.labels {
+		labels[name] = value
+	}
+	setLabel(labels, "alertname", a.alertName)
+	setLabel(labels, "severity", severity(event.Type))
+	setLabel(labels, "kind", event.InvolvedObject.Kind)
+	setLabel(labels, "namespace", namespace)
+	setLabel(labels, "name", event.InvolvedObject.Name)
+	setLabel(labels, "reason", event.Reason)
+	setLabel(labels, "cluster", util.GetClusterName(event, ""))
+	if abnormal, ok := metrics.TriageEvent(event); ok {
+		setLabel(labels, "abnormal_reason", string(abnormal))
+	}
+
+	annotations := map[string]string{
+		"summary": fmt.Sprintf("%s %s/%s: %s", event.InvolvedObject.Kind, namespace, event.InvolvedObject.Name, event.Reason),
+		"message": event.Message,
+	}
+	if event.Count > 1 {
+		annotations["count"] = strconv.Itoa(int(event.Count))
+	}
+	if event.Source.Host != "" {
+		annotations["node"] = event.Source.Host
+	}
+
+	lastSeen := util.GetLastEventTimestamp(event)
+	startsAt := event.FirstTimestamp.Time
+	if startsAt.IsZero() {
+		startsAt = lastSeen
+	}
+	alert := &Alert{
+		Labels:       labels,
+		Annotations:  annotations,
+		StartsAt:     startsAt.UTC(),
+		GeneratorURL: a.generatorURL,
+	}
+	if a.endsAt > 0 {
+		endsAt := lastSeen.Add(a.endsAt).UTC()
+		alert.EndsAt = &endsAt
+	}
+	return alert
+}
+
+func (a *AlertmanagerSink) send(alerts []*Alert) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alerts: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request to alertmanager: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.username != "" || a.password != "" {
+		req.SetBasicAuth(a.username, a.password)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return core.NewRetryableError(fmt.Errorf("failed to send alerts to alertmanager: %v", err))
+	}
+	defer resp.Body.Close()
+	return util.CheckResponse(resp, "send alerts to alertmanager")
+}
+
+// setLabel sets non-empty labels only, Alertmanager treats an empty label as a missing one.
+func setLabel(labels map[string]string, name, value string) {
+	if value != "" {
+		labels[name] = value
+	}
+}
+
+func severity(eventType string) string {
+	if eventType == v1.EventTypeWarning {
+		return "warning"
+	}
+	return "info"
+}
+
+func NewAlertmanagerSink(uri *url.URL) (*AlertmanagerSink, error) {
+	if uri.Host == "" {
+		return nil, fmt.Errorf("you must provide the alertmanager url")
+	}
+	// the path is kept as a prefix for Alertmanagers served under a route prefix.
+	path := strings.TrimSuffix(uri.Path, "/")
+	if !strings.HasSuffix(path, alertsPath) {
+		path += alertsPath
+	}
+	a := &AlertmanagerSink{
+		endpoint:  (&url.URL{Scheme: uri.Scheme, Host: uri.Host, Path: path}).String(),
+		level:     util.GetLevel(v1.EventTypeWarning),
+		alertName: defaultAlertName,
+		labels:    map[string]string{},
+		endsAt:    defaultEndsAt,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+	if uri.User != nil {
+		a.username = uri.User.Username()
+		a.password, _ = uri.User.Password()
+	}
+	opts := uri.Query()
+
+	if len(opts["level"]) >= 1 {
+		a.level = util.GetLevel(opts["level"][0])
+	}
+	if len(opts["alertname"]) >= 1 && opts["alertname"][0] != "" {
+		a.alertName = opts["alertname"][0]
+	}
+	for _, label := range opts["label"] {
+		parts := strings.SplitN(label, ":", 2)
+		if len(parts) != 2 || !labelNameRegexp.MatchString(parts[0]) {
+			return nil, fmt.Errorf("label must look like <name>:<value> with a valid label name, got %q", label)
+		}
+		a.labels[parts[0]] = parts[1]
+	}
+	if len(opts["ends_at"]) >= 1 {
+		endsAt, err := time.ParseDuration(opts["ends_at"][0])
+		if err != nil || endsAt < 0 {
+			return nil, fmt.Errorf("ends_at must be a non-negative duration, got %q", opts["ends_at"][0])
+		}
+		a.endsAt = endsAt
+	}
+	if len(opts["generator_url"]) >= 1 {
+		a.generatorURL = opts["generator_url"][0]
+	}
+
+	return a, nil
+}
@@ -0,0 +1,316 @@
+package batch
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	kube_api "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Messages listed per group by default.
+	DefaultTop = 3
+	// Events kept for a window, later ones are dropped.
+	MaxEvents = 10000
+)
+
+// Message is a distinct message of a group and how often it occurred.
+type Message struct {
+	Message string
+	Count   int
+}
+
+// Group holds the events of a namespace with the same reason.
+type Group struct {
+	Namespace string
+	Reason    string
+	// Warning if any of the events is a warning.
+	Type  string
+	Count int
+	// The most frequent messages, at most top of them.
+	Messages []Message
+	// Number of distinct messages, including those not listed.
+	DistinctMessages int
+}
+
+// Summary renders many events as a single message.
+type Summary struct {
+	Events []*kube_api.Event
+	// Groups ordered by count, the largest first.
+	Groups []*Group
+	// Events dropped because the window held too many of them.
+	Dropped int
+	// Messages listed per group.
+	top int
+}
+
+type groupKey struct {
+	namespace string
+	reason    string
+}
+
+// Summarize groups the events by namespace and reason and lists the top most frequent messages of every group.
+func Summarize(events []*kube_api.Event, top int) *Summary {
+	summary := &Summary{Events: events, top: top}
+	groups := map[groupKey]*Group{}
+	messages := map[groupKey]map[string]int{}
+	// Order of first occurrence, to break ties.
+	order := map[string]int{}
+	for _, event := range events {
+		namespace := event.InvolvedObject.Namespace
+		if namespace == "" {
+			namespace = event.Namespace
+		}
+		key := groupKey{namespace: namespace, reason: event.Reason}
+		group, found := groups[key]
+		if !found {
+			group = &Group{Namespace: namespace, Reason: event.Reason, Type: event.Type}
+			groups[key] = group
+			messages[key] = map[string]int{}
+			summary.Groups = append(summary.Groups, group)
+		}
+		if event.Type == kube_api.EventTypeWarning {
+			group.Type = kube_api.EventTypeWarning
+		}
+		group.Count++
+		messages[key][event.Message]++
+		if _, found := order[event.Message]; !found {
+			order[event.Message] = len(order)
+		}
+	}
+
+	for key, group := range groups {
+		for message, count := range messages[key] {
+			group.Messages = append(group.Messages, Message{Message: message, Count: count})
+		}
+		sort.Slice(group.Messages, func(i, j int) bool {
+			if group.Messages[i].Count != group.Messages[j].Count {
+				return group.Messages[i].Count > group.Messages[j].Count
+			}
+			return order[group.Messages[i].Message] < order[group.Messages[j].Message]
+		})
+		group.DistinctMessages = len(group.Messages)
+		if len(group.Messages) > top {
+			group.Messages = group.Messages[:top]
+		}
+	}
+	// stable to keep groups of the same size in the order of their first event.
+	sort.SliceStable(summary.Groups, func(i, j int) bool {
+		return summary.Groups[i].Count > summary.Groups[j].Count
+	})
+	return summary
+}
+
+// Total is the number of events summarized, including dropped ones.
+func (s *Summary) Total() int {
+	return len(s.Events) + s.Dropped
+}
+
+// Subset summarizes some of the events of the summary alike, for a recipient which only gets those.
+func (s *Summary) Subset(events []*kube_api.Event) *Summary {
+	subset := Summarize(events, s.top)
+	subset.Dropped = s.Dropped
+	return subset
+}
+
+// Lines renders the summary as plain text, a line per group followed by a line per message.
+func (s *Summary) Lines() []string {
+	lines := []string{fmt.Sprintf("%d events in %d groups", s.Total(), len(s.Groups))}
+	for _, group := range s.Groups {
+		lines = append(lines, fmt.Sprintf("[%s] %s %s: %d", group.Type, group.Namespace, group.Reason, group.Count))
+		for _, message := range group.Messages {
+			lines = append(lines, fmt.Sprintf("  %dx %s", message.Count, message.Message))
+		}
+		if more := group.DistinctMessages - len(group.Messages); more > 0 {
+			lines = append(lines, fmt.Sprintf("  ... and %d more messages", more))
+		}
+	}
+	if s.Dropped > 0 {
+		lines = append(lines, fmt.Sprintf("%d events were dropped", s.Dropped))
+	}
+	return lines
+}
+
+// SendFunc sends a summary in one message and returns the events it failed to send.
+type SendFunc func(summary *Summary) []core.ExportFailure
+
+// Batcher collects the events exported to a sink and sends them in summaries, either per
+// exported batch or per time window.
+type Batcher struct {
+	window time.Duration
+	top    int
+	send   SendFunc
+	now    func() time.Time
+
+	lock    sync.Mutex
+	events  []*kube_api.Event
+	dropped int
+	// When the oldest of the events was exported to the batcher.
+	since time.Time
+	// When the oldest of the events being sent was exported to the batcher.
+	sendingSince time.Time
+	// Closes the current window.
+	timer   *time.Timer
+	stopped bool
+	// Held while sending a window, so that Stop does not overtake it.
+	sendLock sync.Mutex
+}
+
+// NewBatcher returns the batcher configured by the options of a sink, or nil if the sink sends
+// a message per event. The batcher sends its summaries with send.
+//
+// batch: true to send a message per exported batch.
+// batch_window: duration to collect events for before sending them in one message, e.g. 5m. Implies batch.
+// batch_top: messages listed per group (default 3).
+func NewBatcher(opts url.Values, send SendFunc) (*Batcher, error) {
+	enabled := false
+	if len(opts["batch"]) >= 1 {
+		var err error
+		if enabled, err = strconv.ParseBool(opts["batch"][0]); err != nil {
+			return nil, fmt.Errorf("batch must be true or false, got %q", opts["batch"][0])
+		}
+	}
+	var window time.Duration
+	if len(opts["batch_window"]) >= 1 {
+		var err error
+		window, err = time.ParseDuration(opts["batch_window"][0])
+		if err != nil || window < 0 {
+			return nil, fmt.Errorf("batch_window must be a non-negative duration, got %q", opts["batch_window"][0])
+		}
+		enabled = true
+	}
+	if !enabled {
+		return nil, nil
+	}
+	top := DefaultTop
+	if len(opts["batch_top"]) >= 1 {
+		var err error
+		top, err = strconv.Atoi(opts["batch_top"][0])
+		if err != nil || top < 1 {
+			return nil, fmt.Errorf("batch_top must be a positive number, got %q", opts["batch_top"][0])
+		}
+	}
+	return &Batcher{
+		window: window,
+		top:    top,
+		send:   send,
+		now:    time.Now,
+	}, nil
+}
+
+// Export summarizes the exported events. Without a window, the summary is sent straight away and
+// the events it failed to send are returned. With a window, the events are held until the window
+// closes and nil is returned. The window starts with its first event and the events its summary
+// fails to send with a retryable error are held for the next window.
+func (b *Batcher) Export(events []*kube_api.Event) []core.ExportFailure {
+	if b.window == 0 {
+		if len(events) == 0 {
+			return nil
+		}
+		return b.send(Summarize(events, b.top))
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.hold(events, b.now())
+	return nil
+}
+
+// HeldSince returns when the oldest event the batcher holds, including the events it is sending,
+// was exported to it, or the zero time if it holds none.
+func (b *Batcher) HeldSince() time.Time {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	since := b.since
+	if !b.sendingSince.IsZero() && (since.IsZero() || b.sendingSince.Before(since)) {
+		since = b.sendingSince
+	}
+	return since
+}
+
+// Stop sends the events of the current window. The events it fails to send stay held.
+func (b *Batcher) Stop() {
+	b.lock.Lock()
+	b.stopped = true
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.lock.Unlock()
+
+	b.closeWindow()
+}
+
+// hold collects the events exported at since and starts the window if it is not running yet.
+func (b *Batcher) hold(events []*kube_api.Event, since time.Time) {
+	for _, event := range events {
+		if len(b.events) >= MaxEvents {
+			b.dropped++
+			continue
+		}
+		b.events = append(b.events, event)
+	}
+	if len(b.events) == 0 && b.dropped == 0 {
+		return
+	}
+	if b.since.IsZero() || since.Before(b.since) {
+		b.since = since
+	}
+	if b.timer == nil && !b.stopped {
+		b.timer = time.AfterFunc(b.window, b.closeWindow)
+	}
+}
+
+// closeWindow sends the summary of the collected events.
+func (b *Batcher) closeWindow() {
+	b.sendLock.Lock()
+	defer b.sendLock.Unlock()
+
+	b.lock.Lock()
+	b.timer = nil
+	if len(b.events) == 0 && b.dropped == 0 {
+		b.lock.Unlock()
+		return
+	}
+	if b.dropped > 0 {
+		klog.Warningf("%d events were dropped from a batch of more than %d events", b.dropped, MaxEvents)
+	}
+	summary := Summarize(b.events, b.top)
+	summary.Dropped = b.dropped
+	since := b.since
+	b.sendingSince = since
+	b.events = nil
+	b.dropped = 0
+	b.since = time.Time{}
+	b.lock.Unlock()
+
+	var retry []*kube_api.Event
+	failed := 0
+	var lastErr error
+	for _, failure := range b.send(summary) {
+		if failure.Retryable {
+			retry = append(retry, failure.Event)
+			continue
+		}
+		failed++
+		lastErr = failure.Err
+	}
+	if failed > 0 {
+		klog.Errorf("Failed to send %d events in a summary: %v", failed, lastErr)
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.sendingSince = time.Time{}
+	if len(retry) > 0 {
+		klog.Warningf("Failed to send %d events in a summary, holding them for the next window", len(retry))
+		b.hold(retry, since)
+	}
+}
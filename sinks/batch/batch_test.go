@@ -0,0 +1,187 @@
+package batch
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/stretchr/testify/assert"
+	kube_api "k8s.io/api/core/v1"
+)
+
+func newEvent(namespace, reason, message, eventType string) *kube_api.Event {
+	return &kube_api.Event{
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		InvolvedObject: kube_api.ObjectReference{Kind: "Pod", Namespace: namespace, Name: "pod"},
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	events := []*kube_api.Event{
+		newEvent("default", "Scheduled", "assigned", kube_api.EventTypeNormal),
+		newEvent("kube-system", "BackOff", "back-off a", kube_api.EventTypeWarning),
+		newEvent("kube-system", "BackOff", "back-off b", kube_api.EventTypeWarning),
+		newEvent("kube-system", "BackOff", "back-off b", kube_api.EventTypeWarning),
+		newEvent("kube-system", "BackOff", "back-off c", kube_api.EventTypeNormal),
+		newEvent("kube-system", "BackOff", "back-off d", kube_api.EventTypeWarning),
+	}
+
+	summary := Summarize(events, 2)
+	assert.Equal(t, 6, summary.Total())
+	assert.Len(t, summary.Groups, 2)
+
+	backOff := summary.Groups[0]
+	assert.Equal(t, "kube-system", backOff.Namespace)
+	assert.Equal(t, "BackOff", backOff.Reason)
+	assert.Equal(t, kube_api.EventTypeWarning, backOff.Type)
+	assert.Equal(t, 5, backOff.Count)
+	assert.Equal(t, []Message{{"back-off b", 2}, {"back-off a", 1}}, backOff.Messages)
+	assert.Equal(t, 4, backOff.DistinctMessages)
+
+	assert.Equal(t, []string{
+		"6 events in 2 groups",
+		"[Warning] kube-system BackOff: 5",
+		"  2x back-off b",
+		"  1x back-off a",
+		"  ... and 2 more messages",
+		"[Normal] default Scheduled: 1",
+		"  1x assigned",
+	}, summary.Lines())
+
+	// a subset keeps the dropped events and the messages listed per group.
+	summary.Dropped = 1
+	subset := summary.Subset(events[1:4])
+	assert.Equal(t, 4, subset.Total())
+	assert.Len(t, subset.Groups, 1)
+	assert.Equal(t, []Message{{"back-off b", 2}, {"back-off a", 1}}, subset.Groups[0].Messages)
+}
+
+// recorder is a SendFunc recording the summaries and failing them with the errors in fail.
+type recorder struct {
+	summaries chan *Summary
+	fail      []error
+}
+
+func newRecorder(fail ...error) *recorder {
+	return &recorder{summaries: make(chan *Summary, 10), fail: fail}
+}
+
+func (r *recorder) send(summary *Summary) []core.ExportFailure {
+	r.summaries <- summary
+	if len(r.fail) == 0 {
+		return nil
+	}
+	err := r.fail[0]
+	r.fail = r.fail[1:]
+	if err == nil {
+		return nil
+	}
+	var failures []core.ExportFailure
+	for _, event := range summary.Events {
+		failures = append(failures, core.NewExportFailure(event, err))
+	}
+	return failures
+}
+
+func (r *recorder) next(t *testing.T) *Summary {
+	select {
+	case summary := <-r.summaries:
+		return summary
+	case <-time.After(5 * time.Second):
+		t.Fatal("no summary was sent")
+		return nil
+	}
+}
+
+func TestNewBatcher(t *testing.T) {
+	b, err := NewBatcher(url.Values{}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, b)
+
+	b, err = NewBatcher(url.Values{"batch": {"true"}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), b.window)
+	assert.Equal(t, DefaultTop, b.top)
+
+	b, err = NewBatcher(url.Values{"batch_window": {"5m"}, "batch_top": {"5"}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, b.window)
+	assert.Equal(t, 5, b.top)
+
+	for _, invalid := range []url.Values{
+		{"batch": {"yes please"}},
+		{"batch_window": {"-1m"}},
+		{"batch": {"true"}, "batch_top": {"0"}},
+	} {
+		_, err = NewBatcher(invalid, nil)
+		assert.Error(t, err, "%v", invalid)
+	}
+}
+
+func TestBatcherPerExport(t *testing.T) {
+	r := newRecorder(nil, core.NewRetryableError(errors.New("unavailable")))
+	b, _ := NewBatcher(url.Values{"batch": {"true"}}, r.send)
+	assert.Nil(t, b.Export(nil))
+
+	assert.Empty(t, b.Export([]*kube_api.Event{newEvent("default", "BackOff", "back-off", kube_api.EventTypeWarning)}))
+	assert.Equal(t, 1, r.next(t).Total())
+
+	// the failures are the events of the export.
+	failed := newEvent("default", "BackOff", "failed", kube_api.EventTypeWarning)
+	failures := b.Export([]*kube_api.Event{failed})
+	assert.Len(t, failures, 1)
+	assert.Equal(t, failed, failures[0].Event)
+	assert.True(t, failures[0].Retryable)
+	assert.True(t, b.HeldSince().IsZero())
+}
+
+func TestBatcherWindow(t *testing.T) {
+	r := newRecorder()
+	b, _ := NewBatcher(url.Values{"batch_window": {"50ms"}}, r.send)
+
+	start := time.Now()
+	assert.Nil(t, b.Export([]*kube_api.Event{newEvent("default", "BackOff", "back-off", kube_api.EventTypeWarning)}))
+	assert.Nil(t, b.Export([]*kube_api.Event{newEvent("default", "BackOff", "back-off", kube_api.EventTypeWarning)}))
+	assert.False(t, b.HeldSince().Before(start))
+
+	// the window closes without further exports.
+	assert.Equal(t, 2, r.next(t).Total())
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Eventually(t, func() bool { return b.HeldSince().IsZero() }, time.Second, time.Millisecond)
+
+	// the next window starts with the next event.
+	assert.Nil(t, b.Export([]*kube_api.Event{newEvent("default", "BackOff", "back-off", kube_api.EventTypeWarning)}))
+	assert.Equal(t, 1, r.next(t).Total())
+}
+
+func TestBatcherWindowHoldsFailedEvents(t *testing.T) {
+	r := newRecorder(core.NewRetryableError(errors.New("unavailable")), errors.New("bad request"))
+	b, _ := NewBatcher(url.Values{"batch_window": {"20ms"}}, r.send)
+
+	assert.Nil(t, b.Export([]*kube_api.Event{newEvent("default", "BackOff", "back-off", kube_api.EventTypeWarning)}))
+	since := b.HeldSince()
+	assert.False(t, since.IsZero())
+
+	// the events of a retryable failure are sent with the next window and held since their export.
+	assert.Equal(t, 1, r.next(t).Total())
+	assert.Nil(t, b.Export([]*kube_api.Event{newEvent("default", "Failed", "failed", kube_api.EventTypeWarning)}))
+	assert.Equal(t, since, b.HeldSince())
+	assert.Equal(t, 2, r.next(t).Total())
+
+	// the events of a permanent failure are given up.
+	assert.Eventually(t, func() bool { return b.HeldSince().IsZero() }, time.Second, time.Millisecond)
+}
+
+func TestBatcherStop(t *testing.T) {
+	r := newRecorder()
+	b, _ := NewBatcher(url.Values{"batch_window": {"1h"}}, r.send)
+	assert.Nil(t, b.Export([]*kube_api.Event{newEvent("default", "BackOff", "back-off", kube_api.EventTypeWarning)}))
+
+	b.Stop()
+	assert.Equal(t, 1, r.next(t).Total())
+	assert.True(t, b.HeldSince().IsZero())
+}
@@ -28,6 +28,8 @@ import (
 	"time"
 
 	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/sinks/batch"
+	"github.com/AliyunContainerService/kube-eventer/sinks/ratelimit"
 	"k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
@@ -39,6 +41,8 @@ const (
 	DEFAULT_MSG_TYPE      = "text"
 	CONTENT_TYPE_JSON     = "application/json"
 	LABEL_TEMPLATE        = "%s\n"
+	CLUSTER_TEMPLATE      = "Cluster:%s \n"
+	DIGEST_TEMPLATE       = "Rate limit reached, %d events were held back:\n%s"
 )
 
 var (
@@ -81,18 +85,23 @@ dingtalk sink usage
 
 level: Normal or Warning. The event level greater than global level will emit.
 label: some thing unique when you want to distinguish different k8s clusters.
+rate_limit: messages sent per s, m or h, e.g. 20/m. Events over the limit are sent in a digest.
+rate_burst: messages sent at once before rate_limit applies.
+batch: true to send the events of an export in one message, grouped by namespace and reason.
+batch_window: duration to collect events for before sending them in one message, e.g. 5m.
+batch_top: messages listed per group of a batch (default 3).
 */
 type DingTalkSink struct {
-	Endpoint   string
-	Namespaces []string
-	Kinds      []string
-	Token      string
-	Level      int
-	Labels     []string
-	MsgType    string
-	ClusterID  string
-	Secret     string
-	Region     string
+	Endpoint  string
+	Token     string
+	Level     int
+	Labels    []string
+	MsgType   string
+	ClusterID string
+	Secret    string
+	Region    string
+	limiter   *ratelimit.Limiter
+	batcher   *batch.Batcher
 }
 
 func (d *DingTalkSink) Name() string {
@@ -100,66 +109,103 @@ func (d *DingTalkSink) Name() string {
 }
 
 func (d *DingTalkSink) Stop() {
-	//do nothing
+	if d.batcher != nil {
+		// send the events collected for the current window.
+		d.batcher.Stop()
+	}
 }
 
-func (d *DingTalkSink) ExportEvents(batch *core.EventBatch) {
-	for _, event := range batch.Events {
-		if d.isEventLevelDangerous(event.Type) {
-			d.Ding(event)
-			// add threshold
-			time.Sleep(time.Millisecond * 50)
-		}
+// HeldSince returns when the oldest event collected for the current window was exported.
+func (d *DingTalkSink) HeldSince() time.Time {
+	if d.batcher == nil {
+		return time.Time{}
 	}
+	return d.batcher.HeldSince()
 }
 
-func (d *DingTalkSink) isEventLevelDangerous(level string) bool {
-	score := getLevel(level)
-	if score >= d.Level {
-		return true
+func (d *DingTalkSink) ExportEvents(batch *core.EventBatch) {
+	for _, failure := range d.ExportEventsWithResult(batch) {
+		klog.Errorf("failed to send event to dingtalk, because of %v", failure.Err)
 	}
-	return false
 }
 
-func (d *DingTalkSink) Ding(event *v1.Event) {
-	value := url.Values{}
-
-	if d.Namespaces != nil {
-		skip := true
-		for _, namespace := range d.Namespaces {
-			if namespace == event.Namespace {
-				skip = false
-				break
+func (d *DingTalkSink) ExportEventsWithResult(batch *core.EventBatch) []core.ExportFailure {
+	if d.batcher != nil {
+		var events []*v1.Event
+		for _, event := range batch.Events {
+			if d.isEventLevelDangerous(event.Type) {
+				events = append(events, event)
 			}
 		}
-		if skip {
-			return
-		}
+		return d.batcher.Export(events)
 	}
 
-	if d.Kinds != nil {
-		skip := true
-		for _, kind := range d.Kinds {
-			if kind == event.InvolvedObject.Kind {
-				skip = false
-				break
+	var failures []core.ExportFailure
+	for _, event := range batch.Events {
+		if d.isEventLevelDangerous(event.Type) {
+			if d.limiter != nil && !d.limiter.Admit(event) {
+				continue
+			}
+			if err := d.Ding(event); err != nil {
+				failures = append(failures, core.NewExportFailure(event, err))
+			}
+			if d.limiter == nil {
+				// add threshold
+				time.Sleep(time.Millisecond * 50)
 			}
 		}
-		if skip {
-			return
+	}
+	if d.limiter != nil {
+		failures = append(failures, d.sendDigest()...)
+	}
+	return failures
+}
+
+// sendSummary sends the events collected by the batcher in one message.
+func (d *DingTalkSink) sendSummary(summary *batch.Summary) []core.ExportFailure {
+	var failures []core.ExportFailure
+	if summary != nil && (d.limiter == nil || d.limiter.AdmitAll(summary.Events)) {
+		if err := d.send(createMsgFromSummary(d, summary)); err != nil {
+			for _, event := range summary.Events {
+				failures = append(failures, core.NewExportFailure(event, err))
+			}
 		}
 	}
+	if d.limiter != nil {
+		failures = append(failures, d.sendDigest()...)
+	}
+	return failures
+}
 
+// sendDigest sends the events held back by the rate limit once a message is allowed again.
+func (d *DingTalkSink) sendDigest() []core.ExportFailure {
+	return d.limiter.SendDigest(func(digest *ratelimit.Digest) error {
+		return d.send(createMsgFromDigest(d, digest))
+	})
+}
+
+func (d *DingTalkSink) isEventLevelDangerous(level string) bool {
+	score := util.GetLevel(level)
+	if score >= d.Level {
+		return true
+	}
+	return false
+}
+
+func (d *DingTalkSink) Ding(event *v1.Event) error {
 	msg := createMsgFromEvent(d, event)
 	if msg == nil {
-		klog.Warningf("failed to create msg from event,because of %v", event)
-		return
+		return fmt.Errorf("failed to create msg from event %v", event)
 	}
+	return d.send(msg)
+}
+
+func (d *DingTalkSink) send(msg *DingTalkMsg) error {
+	value := url.Values{}
 
 	msg_bytes, err := json.Marshal(msg)
 	if err != nil {
-		klog.Warningf("failed to marshal msg %v", msg)
-		return
+		return fmt.Errorf("failed to marshal msg %v: %v", msg, err)
 	}
 
 	value.Set("access_token", d.Token)
@@ -172,34 +218,23 @@ func (d *DingTalkSink) Ding(event *v1.Event) {
 	b := bytes.NewBuffer(msg_bytes)
 	request, err := http.NewRequest(http.MethodPost, fmt.Sprintf("https://%s", d.Endpoint), b)
 	if err != nil {
-		klog.Errorf("failed to create http request")
-		return
+		return fmt.Errorf("failed to create http request: %v", err)
 	}
 	request.URL.RawQuery = value.Encode()
 	request.Header.Add("Content-Type", "application/json;charset=utf-8")
 	resp, err := (&http.Client{}).Do(request)
 	if err != nil {
-		klog.Errorf("failed to send msg to dingtalk. error: %s", err.Error())
-		return
+		return core.NewRetryableError(fmt.Errorf("failed to send msg to dingtalk. error: %s", err.Error()))
 	}
 	defer resp.Body.Close()
 	if resp != nil && resp.StatusCode != http.StatusOK {
-		klog.Errorf("failed to send msg to dingtalk, because the response code is %d", resp.StatusCode)
-		return
-	}
-}
-
-func getLevel(level string) int {
-	score := 0
-	switch level {
-	case v1.EventTypeWarning:
-		score += 2
-	case v1.EventTypeNormal:
-		score += 1
-	default:
-		//score will remain 0
+		err := fmt.Errorf("failed to send msg to dingtalk, because the response code is %d", resp.StatusCode)
+		if util.IsRetryableStatusCode(resp.StatusCode) {
+			return core.NewRetryableError(err)
+		}
+		return err
 	}
-	return score
+	return nil
 }
 
 func createMsgFromEvent(d *DingTalkSink, event *v1.Event) *DingTalkMsg {
@@ -209,12 +244,13 @@ func createMsgFromEvent(d *DingTalkSink, event *v1.Event) *DingTalkMsg {
 	switch msg.MsgType {
 	//https://open-doc.dingtalk.com/microapp/serverapi2/ye8tup#-6
 	case MARKDOWN_MSG_TYPE:
-		markdownCreator := NewMarkdownMsgBuilder(d.ClusterID, d.Region, event)
+		clusterID := util.GetClusterName(event, d.ClusterID)
+		markdownCreator := NewMarkdownMsgBuilder(clusterID, d.Region, event)
 		markdownCreator.AddNodeName(event.Source.Host)
 		markdownCreator.AddLabels(d.Labels)
 		msg.Markdown = DingTalkMarkdown{
 			//title 加不加其实没所谓,最终不会显示
-			Title: fmt.Sprintf("Kubernetes(ID:%s) Event", d.ClusterID),
+			Title: fmt.Sprintf("Kubernetes(ID:%s) Event", clusterID),
 			Text:  markdownCreator.Build(),
 		}
 		break
@@ -227,6 +263,9 @@ func createMsgFromEvent(d *DingTalkSink, event *v1.Event) *DingTalkMsg {
 				template = fmt.Sprintf(LABEL_TEMPLATE, label) + template
 			}
 		}
+		if cluster := util.GetClusterName(event, ""); cluster != "" {
+			template = fmt.Sprintf(CLUSTER_TEMPLATE, cluster) + template
+		}
 		msg.Text = DingTalkText{
 			Content: fmt.Sprintf(template, event.Type, event.InvolvedObject.Kind, event.Namespace, event.Name, event.Reason, util.GetLastEventTimestamp(event).Format(time.DateTime), event.Message),
 		}
@@ -236,6 +275,71 @@ func createMsgFromEvent(d *DingTalkSink, event *v1.Event) *DingTalkMsg {
 	return msg
 }
 
+func createMsgFromSummary(d *DingTalkSink, summary *batch.Summary) *DingTalkMsg {
+	msg := &DingTalkMsg{}
+	msg.MsgType = d.MsgType
+	clusterID := d.ClusterID
+	if len(summary.Events) > 0 {
+		clusterID = util.GetClusterName(summary.Events[0], d.ClusterID)
+	}
+
+	switch msg.MsgType {
+	case MARKDOWN_MSG_TYPE:
+		markdownCreator := NewBatchMarkdownMsgBuilder(clusterID, d.Region, summary)
+		markdownCreator.AddLabels(d.Labels)
+		msg.Markdown = DingTalkMarkdown{
+			Title: fmt.Sprintf("Kubernetes(ID:%s) Events", clusterID),
+			Text:  markdownCreator.Build(),
+		}
+
+	default:
+		template := "%s"
+		for _, label := range d.Labels {
+			template = fmt.Sprintf(LABEL_TEMPLATE, label) + template
+		}
+		if clusterID != "" {
+			template = fmt.Sprintf(CLUSTER_TEMPLATE, clusterID) + template
+		}
+		msg.Text = DingTalkText{
+			Content: fmt.Sprintf(template, strings.Join(summary.Lines(), "\n")),
+		}
+	}
+
+	return msg
+}
+
+func createMsgFromDigest(d *DingTalkSink, digest *ratelimit.Digest) *DingTalkMsg {
+	msg := &DingTalkMsg{}
+	msg.MsgType = d.MsgType
+	lines := digest.Lines()
+
+	switch msg.MsgType {
+	case MARKDOWN_MSG_TYPE:
+		text := fmt.Sprintf("### Rate limit reached, %d events were held back\n\n", digest.Total)
+		for _, line := range lines {
+			text += fmt.Sprintf("- %s\n", line)
+		}
+		for _, label := range d.Labels {
+			text += fmt.Sprintf("\n%s\n", label)
+		}
+		msg.Markdown = DingTalkMarkdown{
+			Title: fmt.Sprintf("Kubernetes(ID:%s) Events", d.ClusterID),
+			Text:  text,
+		}
+
+	default:
+		template := DIGEST_TEMPLATE
+		for _, label := range d.Labels {
+			template = fmt.Sprintf(LABEL_TEMPLATE, label) + template
+		}
+		msg.Text = DingTalkText{
+			Content: fmt.Sprintf(template, digest.Total, strings.Join(lines, "\n")),
+		}
+	}
+
+	return msg
+}
+
 func NewDingTalkSink(uri *url.URL) (*DingTalkSink, error) {
 	d := &DingTalkSink{
 		Level: WARNING,
@@ -252,7 +356,7 @@ func NewDingTalkSink(uri *url.URL) (*DingTalkSink, error) {
 	}
 
 	if len(opts["level"]) >= 1 {
-		d.Level = getLevel(opts["level"][0])
+		d.Level = util.GetLevel(opts["level"][0])
 	}
 	// get ding talk sign
 	if len(opts["sign"]) >= 1 {
@@ -278,22 +382,19 @@ func NewDingTalkSink(uri *url.URL) (*DingTalkSink, error) {
 		d.Region = region[0]
 	}
 
-	d.Namespaces = getValues(opts["namespaces"])
-	// kinds:https://github.com/kubernetes/community/blob/master/contributors/devel/sig-architecture/api-conventions.md#lists-and-simple-kinds
-	// such as node,pod,component and so on
-	d.Kinds = getValues(opts["kinds"])
-
-	return d, nil
-}
+	limiter, err := ratelimit.NewLimiter(DINGTALK_SINK, opts)
+	if err != nil {
+		return nil, err
+	}
+	d.limiter = limiter
 
-func getValues(o []string) []string {
-	if len(o) >= 1 {
-		if len(o[0]) == 0 {
-			return nil
-		}
-		return strings.Split(o[0], ",")
+	batcher, err := batch.NewBatcher(opts, d.sendSummary)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	d.batcher = batcher
+
+	return d, nil
 }
 
 func sign(t int64, secret string) string {
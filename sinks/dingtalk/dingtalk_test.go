@@ -1,8 +1,11 @@
 package dingtalk
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/AliyunContainerService/kube-eventer/sinks/ratelimit"
+	"github.com/AliyunContainerService/kube-eventer/util"
 	"github.com/stretchr/testify/assert"
 	v1 "k8s.io/api/core/v1"
 
@@ -14,9 +17,9 @@ import (
 )
 
 func TestGetLevel(t *testing.T) {
-	warning := getLevel(v1.EventTypeWarning)
-	normal := getLevel(v1.EventTypeNormal)
-	none := getLevel("")
+	warning := util.GetLevel(v1.EventTypeWarning)
+	normal := util.GetLevel(v1.EventTypeNormal)
+	none := util.GetLevel("")
 	assert.True(t, warning > normal)
 	assert.True(t, warning == WARNING)
 	assert.True(t, normal == NORMAL)
@@ -42,6 +45,36 @@ func TestCreateMsgFromEvent(t *testing.T) {
 	assert.True(t, msg != nil)
 }
 
+func TestNewDingTalkSinkRateLimit(t *testing.T) {
+	u, _ := url.Parse("dingtalk:https://oapi.dingtalk.com/robot/send?access_token=<access_token>&rate_limit=20/m")
+	d, err := NewDingTalkSink(u)
+	assert.NoError(t, err)
+	assert.NotNil(t, d.limiter)
+
+	u, _ = url.Parse("dingtalk:https://oapi.dingtalk.com/robot/send?access_token=<access_token>&rate_limit=20")
+	_, err = NewDingTalkSink(u)
+	assert.Error(t, err)
+}
+
+func TestCreateMsgFromDigest(t *testing.T) {
+	event := createTestEvent()
+	event.InvolvedObject.Kind = TEST_RESOURCE_TYPE
+	event.InvolvedObject.Name = TEST_DEPLOY_NAME
+	event.InvolvedObject.Namespace = TEST_NAMESPACE
+	digest := &ratelimit.Digest{Events: []*v1.Event{event}, Total: 3}
+
+	u, _ := url.Parse("dingtalk:https://oapi.dingtalk.com/robot/send?access_token=<access_token>&label=abcd")
+	d, _ := NewDingTalkSink(u)
+	msg := createMsgFromDigest(d, digest)
+	assert.True(t, strings.HasPrefix(msg.Text.Content, "abcd\nRate limit reached, 3 events were held back:\n"))
+	assert.Contains(t, msg.Text.Content, TEST_NAMESPACE+"/"+TEST_DEPLOY_NAME)
+	assert.Contains(t, msg.Text.Content, "... and 2 more")
+
+	d.MsgType = MARKDOWN_MSG_TYPE
+	msg = createMsgFromDigest(d, digest)
+	assert.Contains(t, msg.Markdown.Text, "- ... and 2 more")
+}
+
 func TestCreateMsgFromEvent_Markdown(t *testing.T) {
 	labels := make([]string, 2)
 	labels[0] = "abcd"
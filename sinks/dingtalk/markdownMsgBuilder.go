@@ -2,6 +2,7 @@ package dingtalk
 
 import (
 	"fmt"
+	"github.com/AliyunContainerService/kube-eventer/sinks/batch"
 	"github.com/AliyunContainerService/kube-eventer/util"
 	"strings"
 
@@ -14,6 +15,9 @@ const (
 	MARKDOWN_LINK_TEMPLATE = "[%s](%s)"
 	MARKDOWN_TEXT_BOLD     = "**%s**"
 	MARKDOWN_NEW_LINE      = "\n\n"
+	MARKDOWN_BATCH_TITLE   = "### %d events in %d groups"
+	MARKDOWN_BATCH_GROUP   = "**%s** %s / %s: **%d**"
+	MARKDOWN_BATCH_MESSAGE = "> %d× %s"
 
 	URL_ALIYUN_K8S_CONSULE = "https://cs.console.aliyun.com/#/k8s"
 	//阿里云 kubernetes 管理控制台, Deployment,StatefulSet,DaemonSet 有同样的URL规律
@@ -42,50 +46,79 @@ func NewMarkdownMsgBuilder(clusterID, region string, event *v1.Event) *MarkdownM
 	level := fmt.Sprintf(MARKDOWN_TEXT_BOLD, event.Type)
 	kind := fmt.Sprintf(MARKDOWN_TEXT_BOLD, event.InvolvedObject.Kind)
 	namespace := fmt.Sprintf(MARKDOWN_LINK_TEMPLATE, event.Namespace, URL_ALIYUN_NAMESPACE_TEMPLATE)
-	name := ""
+	name := event.Name
+	if resourceURL := ResourceURL(clusterID, region, event); resourceURL != "" {
+		name = fmt.Sprintf(MARKDOWN_LINK_TEMPLATE, event.Name, resourceURL)
+	}
+	reason := fmt.Sprintf(MARKDOWN_TEXT_BOLD, event.Reason)
+	timestamp := fmt.Sprintf(MARKDOWN_TEXT_BOLD, util.GetLastEventTimestamp(event).String())
+	message := fmt.Sprintf(MARKDOWN_TEXT_BOLD, event.Message)
+	m.OutputText = fmt.Sprintf(MARKDOWN_TEMPLATE, level, kind, namespace, name, reason, timestamp, message)
+	return &m
+
+}
 
+// ResourceURL returns the page of the involved object of the event on the Aliyun kubernetes console,
+// or an empty string for kinds without a page.
+func ResourceURL(clusterID, region string, event *v1.Event) string {
 	switch event.InvolvedObject.Kind {
 	case "Deployment":
 		deployName := removeDotContent(event.Name)
-		podsURL := fmt.Sprintf(URL_ALIYUN_RESOURCE_DETAIL_TEMPLATE, "deployment", m.Region, m.ClusterID, event.Namespace, deployName)
-		name = fmt.Sprintf(MARKDOWN_LINK_TEMPLATE, event.Name, podsURL)
-		break
+		return fmt.Sprintf(URL_ALIYUN_RESOURCE_DETAIL_TEMPLATE, "deployment", region, clusterID, event.Namespace, deployName)
 	case "Pod":
 		podName := removeDotContent(event.Name)
-		podsURL := fmt.Sprintf(URL_ALIYUN_POD_TEMPLATE, m.ClusterID, event.Namespace, podName)
-		name = fmt.Sprintf(MARKDOWN_LINK_TEMPLATE, event.Name, podsURL)
-		break
+		return fmt.Sprintf(URL_ALIYUN_POD_TEMPLATE, clusterID, event.Namespace, podName)
 	case "StatefulSet":
 		ssName := removeDotContent(event.Name)
-		podsURL := fmt.Sprintf(URL_ALIYUN_RESOURCE_DETAIL_TEMPLATE, "statefulset", m.Region, m.ClusterID, event.Namespace, ssName)
-		name = fmt.Sprintf(MARKDOWN_LINK_TEMPLATE, event.Name, podsURL)
-		break
+		return fmt.Sprintf(URL_ALIYUN_RESOURCE_DETAIL_TEMPLATE, "statefulset", region, clusterID, event.Namespace, ssName)
 	case "DaemonSet":
 		dsName := removeDotContent(event.Name)
-		podsURL := fmt.Sprintf(URL_ALIYUN_RESOURCE_DETAIL_TEMPLATE, "daemonset", m.Region, m.ClusterID, event.Namespace, dsName)
-		name = fmt.Sprintf(MARKDOWN_LINK_TEMPLATE, event.Name, podsURL)
-		break
+		return fmt.Sprintf(URL_ALIYUN_RESOURCE_DETAIL_TEMPLATE, "daemonset", region, clusterID, event.Namespace, dsName)
 	case "CronJob":
 		jobName := removeDotContent(event.Name)
-		jobURL := fmt.Sprintf(URL_ALIYUN_CROBJOB_TEMPLATE, m.Region, m.ClusterID, event.Namespace, jobName)
-		name = fmt.Sprintf(MARKDOWN_LINK_TEMPLATE, event.Name, jobURL)
-		break
+		return fmt.Sprintf(URL_ALIYUN_CROBJOB_TEMPLATE, region, clusterID, event.Namespace, jobName)
 	case "Service":
 		serviceName := removeDotContent(event.Name)
-		svcURL := fmt.Sprintf(URL_ALIYUN_SVC_TEMPLATE, m.Region, m.ClusterID, event.Namespace, serviceName)
-		name = fmt.Sprintf(MARKDOWN_LINK_TEMPLATE, event.Name, svcURL)
-		break
+		return fmt.Sprintf(URL_ALIYUN_SVC_TEMPLATE, region, clusterID, event.Namespace, serviceName)
 		//fixme:覆盖所有 event.InvolvedObject.Kind
-	default:
-		name = event.Name
-		break
 	}
-	reason := fmt.Sprintf(MARKDOWN_TEXT_BOLD, event.Reason)
-	timestamp := fmt.Sprintf(MARKDOWN_TEXT_BOLD, util.GetLastEventTimestamp(event).String())
-	message := fmt.Sprintf(MARKDOWN_TEXT_BOLD, event.Message)
-	m.OutputText = fmt.Sprintf(MARKDOWN_TEMPLATE, level, kind, namespace, name, reason, timestamp, message)
-	return &m
+	return ""
+}
 
+// NodeURL returns the page of the ECS instance of a node named <region>.<instance id>, or an empty string for other names.
+func NodeURL(nodeName string) string {
+	ecsInfo := strings.Split(nodeName, ".")
+	if len(ecsInfo) > 1 {
+		return fmt.Sprintf(URL_ALIYUN_ECS_TEMPLATE, ecsInfo[1], ecsInfo[0])
+	}
+	return ""
+}
+
+// NewBatchMarkdownMsgBuilder renders a summary of many events, a paragraph per namespace and reason
+// with the count of the events and their most frequent messages.
+func NewBatchMarkdownMsgBuilder(clusterID, region string, summary *batch.Summary) *MarkdownMsgBuilder {
+	m := MarkdownMsgBuilder{
+		Region:    region,
+		ClusterID: clusterID,
+	}
+
+	paragraphs := []string{fmt.Sprintf(MARKDOWN_BATCH_TITLE, summary.Total(), len(summary.Groups))}
+	for _, group := range summary.Groups {
+		namespace := fmt.Sprintf(MARKDOWN_LINK_TEMPLATE, group.Namespace, URL_ALIYUN_NAMESPACE_TEMPLATE)
+		paragraph := fmt.Sprintf(MARKDOWN_BATCH_GROUP, group.Type, namespace, group.Reason, group.Count)
+		for _, message := range group.Messages {
+			paragraph += MARKDOWN_NEW_LINE + fmt.Sprintf(MARKDOWN_BATCH_MESSAGE, message.Count, message.Message)
+		}
+		if more := group.DistinctMessages - len(group.Messages); more > 0 {
+			paragraph += MARKDOWN_NEW_LINE + fmt.Sprintf("> ... and %d more messages", more)
+		}
+		paragraphs = append(paragraphs, paragraph)
+	}
+	if summary.Dropped > 0 {
+		paragraphs = append(paragraphs, fmt.Sprintf("%d events were dropped", summary.Dropped))
+	}
+	m.OutputText = strings.Join(paragraphs, MARKDOWN_NEW_LINE)
+	return &m
 }
 
 // removeDotContent 每个 Event 由 <resource>.<UnixNano> 组成,需要去掉.后面的部分,得到 <resource>
@@ -110,10 +143,8 @@ func (m *MarkdownMsgBuilder) AddNodeName(nodeName string) {
 	if len(nodeName) < 1 {
 		return
 	}
-	ecsInfo := strings.Split(nodeName, ".")
 	var nodeInfo string
-	if len(ecsInfo) > 1 {
-		ecsURL := fmt.Sprintf(URL_ALIYUN_ECS_TEMPLATE, ecsInfo[1], ecsInfo[0])
+	if ecsURL := NodeURL(nodeName); ecsURL != "" {
 		nodeInfo = fmt.Sprintf("Node: "+MARKDOWN_LINK_TEMPLATE+" "+MARKDOWN_NEW_LINE, nodeName, ecsURL)
 	} else {
 		nodeInfo = fmt.Sprintf("Node: "+MARKDOWN_TEXT_BOLD+" "+MARKDOWN_NEW_LINE, nodeName)
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/AliyunContainerService/kube-eventer/sinks/batch"
 	"github.com/stretchr/testify/assert"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -128,3 +129,21 @@ func createTestLabels() []string {
 	return []string{"a", "b"}
 
 }
+
+func TestNewBatchMarkdownMsgBuilder(t *testing.T) {
+	e := createTestEvent()
+	e.InvolvedObject.Namespace = TEST_NAMESPACE
+	other := createTestEvent()
+	other.InvolvedObject.Namespace = TEST_NAMESPACE
+	other.Message = "another message"
+	summary := batch.Summarize([]*v1.Event{e, e, other}, 1)
+
+	m := NewBatchMarkdownMsgBuilder(TEST_CLUSTERID, TEST_REGION, summary)
+	m.AddLabels([]string{"abcd"})
+	text := m.Build()
+	t.Log(text)
+	assert.True(t, strings.HasPrefix(text, "label[0]: **abcd**\n\n### 3 events in 1 groups"))
+	assert.Contains(t, text, "**Warning** ["+TEST_NAMESPACE+"]("+URL_ALIYUN_NAMESPACE_TEMPLATE+") / "+e.Reason+": **3**")
+	assert.Contains(t, text, "> 2× "+e.Message)
+	assert.Contains(t, text, "> ... and 1 more messages")
+}
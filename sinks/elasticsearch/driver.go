@@ -15,6 +15,8 @@
 package elasticsearch
 
 import (
+	"fmt"
+
 	"github.com/AliyunContainerService/kube-eventer/util"
 	"net/url"
 	"sync"
@@ -83,7 +85,7 @@ func eventToPoint(event *kube_api.Event, clusterName string) (*EsSinkPoint, erro
 		Source:                   event.Source,
 		EventTags: map[string]string{
 			"eventID":      string(event.UID),
-			"cluster_name": clusterName,
+			"cluster_name": util.GetClusterName(event, clusterName),
 		},
 	}
 	if event.InvolvedObject.Kind == "Pod" {
@@ -95,9 +97,19 @@ func eventToPoint(event *kube_api.Event, clusterName string) (*EsSinkPoint, erro
 }
 
 func (sink *elasticSearchSink) ExportEvents(eventBatch *event_core.EventBatch) {
+	for _, failure := range sink.ExportEventsWithResult(eventBatch) {
+		klog.Warningf("Failed to export data to ElasticSearch sink: %v", failure.Err)
+	}
+}
+
+// ExportEventsWithResult reports the events that could not be added to the bulk or flushed.
+// The errors of ElasticSearch are mostly of its connection or availability, so they are retried.
+func (sink *elasticSearchSink) ExportEventsWithResult(eventBatch *event_core.EventBatch) []event_core.ExportFailure {
 	var namespace string
+	var failures []event_core.ExportFailure
 	sink.Lock()
 	defer sink.Unlock()
+	saved := make([]*kube_api.Event, 0, len(eventBatch.Events))
 	for _, event := range eventBatch.Events {
 		point, err := eventToPoint(event, sink.esSvc.ClusterName)
 		if err != nil {
@@ -108,17 +120,22 @@ func (sink *elasticSearchSink) ExportEvents(eventBatch *event_core.EventBatch) {
 		}
 		err = sink.saveData(point.LastOccurrenceTimestamp, namespace, []interface{}{*point})
 		if err != nil {
-			klog.Warningf("Failed to export data to ElasticSearch sink: %v", err)
+			failures = append(failures, event_core.NewExportFailure(event, event_core.NewRetryableError(err)))
+			continue
 		}
+		saved = append(saved, event)
 	}
 
-	err := sink.flushData()
-	if err != nil {
-		klog.Warningf("Failed to flushing data to ElasticSearch sink: %v", err)
+	if err := sink.flushData(); err != nil {
+		err = event_core.NewRetryableError(fmt.Errorf("failed to flush data: %v", err))
+		for _, event := range saved {
+			failures = append(failures, event_core.NewExportFailure(event, err))
+		}
 	}
 	if sink.errorRate != nil {
 		sink.errorRate.Set(float64(sink.esSvc.ErrorStats()))
 	}
+	return failures
 }
 
 func (sink *elasticSearchSink) Name() string {
@@ -22,6 +22,7 @@ import (
 
 	esCommon "github.com/AliyunContainerService/kube-eventer/common/elasticsearch"
 	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/util"
 	"github.com/stretchr/testify/assert"
 	kube_api "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -114,3 +115,39 @@ func TestStoreMultipleDataInput(t *testing.T) {
 
 	FakeESSink = fakeESSink{}
 }
+
+func TestEventClusterNameOverridesSinkClusterName(t *testing.T) {
+	event := &kube_api.Event{Message: "event1"}
+	point, err := eventToPoint(event, esCommon.ESClusterName)
+	assert.NoError(t, err)
+	assert.Equal(t, esCommon.ESClusterName, point.EventTags["cluster_name"])
+
+	util.SetClusterName(event, "prod-1")
+	point, err = eventToPoint(event, esCommon.ESClusterName)
+	assert.NoError(t, err)
+	assert.Equal(t, "prod-1", point.EventTags["cluster_name"])
+}
+
+func TestExportEventsWithResultReportsFailures(t *testing.T) {
+	sink := &elasticSearchSink{
+		saveData: func(date time.Time, namespace string, sinkData []interface{}) error {
+			if sinkData[0].(EsSinkPoint).Message == "event1" {
+				return fmt.Errorf("connection refused")
+			}
+			return nil
+		},
+		flushData: func() error { return nil },
+		esSvc:     esCommon.ElasticSearchService{EsClient: &esCommon.Elastic5Wrapper{}},
+	}
+	batch := &core.EventBatch{Events: []*kube_api.Event{{Message: "event1"}, {Message: "event2"}}}
+	failures := sink.ExportEventsWithResult(batch)
+	assert.Len(t, failures, 1)
+	assert.Equal(t, "event1", failures[0].Event.Message)
+	assert.True(t, failures[0].Retryable)
+
+	// a failed flush fails the events added to the bulk.
+	sink.flushData = func() error { return fmt.Errorf("bulk processor closed") }
+	failures = sink.ExportEventsWithResult(batch)
+	assert.Len(t, failures, 2)
+	assert.Equal(t, "event2", failures[1].Event.Message)
+}
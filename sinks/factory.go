@@ -18,17 +18,27 @@ import (
 	"fmt"
 	"github.com/AliyunContainerService/kube-eventer/common/flags"
 	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/sinks/alertmanager"
 	"github.com/AliyunContainerService/kube-eventer/sinks/dingtalk"
 	"github.com/AliyunContainerService/kube-eventer/sinks/elasticsearch"
 	"github.com/AliyunContainerService/kube-eventer/sinks/eventbridge"
+	"github.com/AliyunContainerService/kube-eventer/sinks/feishu"
+	"github.com/AliyunContainerService/kube-eventer/sinks/file"
 	"github.com/AliyunContainerService/kube-eventer/sinks/honeycomb"
 	"github.com/AliyunContainerService/kube-eventer/sinks/influxdb"
 	"github.com/AliyunContainerService/kube-eventer/sinks/kafka"
 	"github.com/AliyunContainerService/kube-eventer/sinks/log"
+	"github.com/AliyunContainerService/kube-eventer/sinks/loki"
 	"github.com/AliyunContainerService/kube-eventer/sinks/mongo"
 	"github.com/AliyunContainerService/kube-eventer/sinks/mysql"
+	"github.com/AliyunContainerService/kube-eventer/sinks/opsgenie"
+	"github.com/AliyunContainerService/kube-eventer/sinks/otlp"
+	"github.com/AliyunContainerService/kube-eventer/sinks/pagerduty"
 	"github.com/AliyunContainerService/kube-eventer/sinks/riemann"
+	"github.com/AliyunContainerService/kube-eventer/sinks/slack"
+	"github.com/AliyunContainerService/kube-eventer/sinks/smtp"
 	"github.com/AliyunContainerService/kube-eventer/sinks/sls"
+	"github.com/AliyunContainerService/kube-eventer/sinks/teams"
 	"github.com/AliyunContainerService/kube-eventer/sinks/webhook"
 	"github.com/AliyunContainerService/kube-eventer/sinks/wechat"
 	"k8s.io/klog/v2"
@@ -40,7 +50,7 @@ type SinkFactory struct {
 func (this *SinkFactory) Build(uri flags.Uri) (core.EventSink, error) {
 	switch uri.Key {
 	case "log":
-		return logsink.CreateLogSink()
+		return logsink.CreateLogSink(&uri.Val)
 	case "influxdb":
 		return influxdb.CreateInfluxdbSink(&uri.Val)
 	case "mysql":
@@ -65,6 +75,26 @@ func (this *SinkFactory) Build(uri flags.Uri) (core.EventSink, error) {
 		return eventbridge.NewEventBridgeSink(&uri.Val)
 	case "mongo":
 		return mongo.CreateMongoSink(&uri.Val)
+	case "slack":
+		return slack.NewSlackSink(&uri.Val)
+	case "teams":
+		return teams.NewTeamsSink(&uri.Val)
+	case "feishu":
+		return feishu.NewFeishuSink(&uri.Val)
+	case "alertmanager":
+		return alertmanager.NewAlertmanagerSink(&uri.Val)
+	case "pagerduty":
+		return pagerduty.NewPagerDutySink(&uri.Val)
+	case "opsgenie":
+		return opsgenie.NewOpsgenieSink(&uri.Val)
+	case "smtp":
+		return smtp.NewSMTPSink(&uri.Val)
+	case "file":
+		return file.NewFileSink(&uri.Val)
+	case "otlp":
+		return otlp.NewOTLPSink(&uri.Val)
+	case "loki":
+		return loki.NewLokiSink(&uri.Val)
 	default:
 		return nil, fmt.Errorf("Sink not recognized: %s", uri.Key)
 	}
@@ -78,6 +108,12 @@ func (this *SinkFactory) BuildAll(uris flags.Uris) []core.EventSink {
 			klog.Errorf("Failed to create %v sink: %v", uri, err)
 			continue
 		}
+		// Every sink supports the same filter rules and deduplication.
+		sink, err = withProcessors(sink, uri.Val.Query())
+		if err != nil {
+			klog.Errorf("Failed to create processors of %v sink: %v", uri, err)
+			continue
+		}
 		result = append(result, sink)
 	}
 	return result
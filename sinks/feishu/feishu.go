@@ -0,0 +1,381 @@
+package feishu
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/sinks/dingtalk"
+	"github.com/AliyunContainerService/kube-eventer/sinks/ratelimit"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	FEISHU_SINK              = "FeishuSink"
+	WARNING              int = 2
+	NORMAL               int = 1
+	TEXT_MSG_TYPE            = "text"
+	POST_MSG_TYPE            = "post"
+	INTERACTIVE_MSG_TYPE     = "interactive"
+	DEFAULT_MSG_TYPE         = TEXT_MSG_TYPE
+	CONTENT_TYPE_JSON        = "application/json"
+	TITLE_TEMPLATE           = "Kubernetes(ID:%s) Event"
+	DIGEST_TEMPLATE          = "Rate limit reached, %d events were held back:\n%s"
+)
+
+const (
+	// Codes of the response telling that the robot sends too many messages.
+	codeTooManyRequests  = 9499
+	codeFrequencyLimited = 11232
+
+	postLanguage        = "zh_cn"
+	postTextTag         = "text"
+	postLinkTag         = "a"
+	cardTemplateWarning = "red"
+	cardTemplateNormal  = "green"
+	cardMarkdownTag     = "lark_md"
+	cardPlainTextTag    = "plain_text"
+
+	requestTimeout = 10 * time.Second
+)
+
+// FeishuMsg is the message of a custom robot. Content is set for text and post messages, Card for interactive ones.
+type FeishuMsg struct {
+	Timestamp string         `json:"timestamp,omitempty"`
+	Sign      string         `json:"sign,omitempty"`
+	MsgType   string         `json:"msg_type"`
+	Content   *FeishuContent `json:"content,omitempty"`
+	Card      *FeishuCard    `json:"card,omitempty"`
+}
+
+type FeishuContent struct {
+	Text string                `json:"text,omitempty"`
+	Post map[string]FeishuPost `json:"post,omitempty"`
+}
+
+// FeishuPost is a rich text message, a line per element list.
+type FeishuPost struct {
+	Title   string                `json:"title"`
+	Content [][]FeishuPostElement `json:"content"`
+}
+
+type FeishuPostElement struct {
+	Tag  string `json:"tag"`
+	Text string `json:"text"`
+	Href string `json:"href,omitempty"`
+}
+
+type FeishuCard struct {
+	Config   FeishuCardConfig    `json:"config"`
+	Header   FeishuCardHeader    `json:"header"`
+	Elements []FeishuCardElement `json:"elements"`
+}
+
+type FeishuCardConfig struct {
+	WideScreenMode bool `json:"wide_screen_mode"`
+}
+
+type FeishuCardHeader struct {
+	Title    FeishuCardText `json:"title"`
+	Template string         `json:"template"`
+}
+
+type FeishuCardText struct {
+	Tag     string `json:"tag"`
+	Content string `json:"content"`
+}
+
+type FeishuCardElement struct {
+	Tag  string          `json:"tag"`
+	Text *FeishuCardText `json:"text,omitempty"`
+}
+
+// FeishuResponse is the answer of the robot, a code other than 0 is an error.
+type FeishuResponse struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+/*
+feishu sink usage
+--sink=feishu:https://open.feishu.cn/open-apis/bot/v2/hook/[token]?level=Warning&label=[label]&sign=[secret]
+
+level: Normal or Warning. The event level greater than global level will emit.
+label: some thing unique when you want to distinguish different k8s clusters.
+sign: secret of the signature verification of the robot.
+msg_type: text, post or interactive.
+cluster_id, region: cluster and region of the links to the Aliyun console in post and interactive messages.
+rate_limit: messages sent per s, m or h, e.g. 100/m. Events over the limit are sent in a digest.
+rate_burst: messages sent at once before rate_limit applies.
+*/
+type FeishuSink struct {
+	Endpoint  string
+	Level     int
+	Labels    []string
+	MsgType   string
+	ClusterID string
+	Secret    string
+	Region    string
+	limiter   *ratelimit.Limiter
+	client    *http.Client
+}
+
+func (f *FeishuSink) Name() string {
+	return FEISHU_SINK
+}
+
+func (f *FeishuSink) Stop() {
+	//do nothing
+}
+
+func (f *FeishuSink) ExportEvents(batch *core.EventBatch) {
+	for _, failure := range f.ExportEventsWithResult(batch) {
+		klog.Errorf("failed to send event to feishu, because of %v", failure.Err)
+	}
+}
+
+func (f *FeishuSink) ExportEventsWithResult(batch *core.EventBatch) []core.ExportFailure {
+	var failures []core.ExportFailure
+	for _, event := range batch.Events {
+		if util.GetLevel(event.Type) < f.Level {
+			continue
+		}
+		if f.limiter != nil && !f.limiter.Admit(event) {
+			continue
+		}
+		if err := f.send(createMsgFromEvent(f, event)); err != nil {
+			failures = append(failures, core.NewExportFailure(event, err))
+		}
+		if f.limiter == nil {
+			// add threshold
+			time.Sleep(time.Millisecond * 50)
+		}
+	}
+	if f.limiter != nil {
+		failures = append(failures, f.sendDigest()...)
+	}
+	return failures
+}
+
+// sendDigest sends the events held back by the rate limit once a message is allowed again.
+func (f *FeishuSink) sendDigest() []core.ExportFailure {
+	return f.limiter.SendDigest(func(digest *ratelimit.Digest) error {
+		return f.send(createMsgFromDigest(f, digest))
+	})
+}
+
+func (f *FeishuSink) send(msg *FeishuMsg) error {
+	if f.Secret != "" {
+		t := time.Now().Unix()
+		msg.Timestamp = fmt.Sprintf("%d", t)
+		msg.Sign = sign(t, f.Secret)
+	}
+
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal msg %v: %v", msg, err)
+	}
+	resp, err := f.client.Post(f.Endpoint, CONTENT_TYPE_JSON, bytes.NewReader(msgBytes))
+	if err != nil {
+		return core.NewRetryableError(fmt.Errorf("failed to send msg to feishu. error: %s", err.Error()))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("failed to send msg to feishu, because the response code is %d", resp.StatusCode)
+		if util.IsRetryableStatusCode(resp.StatusCode) {
+			return core.NewRetryableError(err)
+		}
+		return err
+	}
+
+	// errors, e.g. a wrong signature, are answered with 200 and a code.
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return core.NewRetryableError(fmt.Errorf("failed to read response of feishu: %v", err))
+	}
+	result := FeishuResponse{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("failed to parse response of feishu %q: %v", string(body), err)
+	}
+	if result.Code != 0 {
+		err := fmt.Errorf("failed to send msg to feishu, because of code %d: %s", result.Code, result.Msg)
+		if result.Code == codeTooManyRequests || result.Code == codeFrequencyLimited {
+			return core.NewRetryableError(err)
+		}
+		return err
+	}
+	return nil
+}
+
+func createMsgFromEvent(f *FeishuSink, event *v1.Event) *FeishuMsg {
+	clusterID := util.GetClusterName(event, f.ClusterID)
+	title := fmt.Sprintf(TITLE_TEMPLATE, clusterID)
+
+	switch f.MsgType {
+	case POST_MSG_TYPE:
+		return &FeishuMsg{
+			MsgType: POST_MSG_TYPE,
+			Content: &FeishuContent{
+				Post: map[string]FeishuPost{
+					postLanguage: {Title: title, Content: createPostContent(f, clusterID, event)},
+				},
+			},
+		}
+
+	case INTERACTIVE_MSG_TYPE:
+		// lark_md renders the same markdown as dingtalk.
+		markdownCreator := dingtalk.NewMarkdownMsgBuilder(clusterID, f.Region, event)
+		markdownCreator.AddNodeName(event.Source.Host)
+		markdownCreator.AddLabels(f.Labels)
+		template := cardTemplateNormal
+		if event.Type == v1.EventTypeWarning {
+			template = cardTemplateWarning
+		}
+		return &FeishuMsg{
+			MsgType: INTERACTIVE_MSG_TYPE,
+			Card: &FeishuCard{
+				Config: FeishuCardConfig{WideScreenMode: true},
+				Header: FeishuCardHeader{
+					Title:    FeishuCardText{Tag: cardPlainTextTag, Content: title},
+					Template: template,
+				},
+				Elements: []FeishuCardElement{
+					{Tag: "div", Text: &FeishuCardText{Tag: cardMarkdownTag, Content: markdownCreator.Build()}},
+				},
+			},
+		}
+
+	default:
+		template := dingtalk.MSG_TEMPLATE
+		for _, label := range f.Labels {
+			template = fmt.Sprintf(dingtalk.LABEL_TEMPLATE, label) + template
+		}
+		if cluster := util.GetClusterName(event, ""); cluster != "" {
+			template = fmt.Sprintf(dingtalk.CLUSTER_TEMPLATE, cluster) + template
+		}
+		return &FeishuMsg{
+			MsgType: TEXT_MSG_TYPE,
+			Content: &FeishuContent{
+				Text: fmt.Sprintf(template, event.Type, event.InvolvedObject.Kind, event.Namespace, event.Name, event.Reason, util.GetLastEventTimestamp(event).Format(time.DateTime), event.Message),
+			},
+		}
+	}
+}
+
+// createPostContent renders the lines of MarkdownMsgBuilder as rich text: labels, node, then the fields of the event.
+func createPostContent(f *FeishuSink, clusterID string, event *v1.Event) [][]FeishuPostElement {
+	var lines [][]FeishuPostElement
+	for i, label := range f.Labels {
+		if label = strings.TrimSpace(label); len(label) > 0 {
+			lines = append(lines, textLine(fmt.Sprintf("label[%d]: %s", i, label)))
+		}
+	}
+	if nodeName := event.Source.Host; nodeName != "" {
+		lines = append(lines, linkLine("Node: ", nodeName, dingtalk.NodeURL(nodeName)))
+	}
+	lines = append(lines,
+		textLine("Level: "+event.Type),
+		textLine("Kind: "+event.InvolvedObject.Kind),
+		linkLine("Namespace: ", event.Namespace, dingtalk.URL_ALIYUN_NAMESPACE_TEMPLATE),
+		linkLine("Name: ", event.Name, dingtalk.ResourceURL(clusterID, f.Region, event)),
+		textLine("Reason: "+event.Reason),
+		textLine("Timestamp: "+util.GetLastEventTimestamp(event).Format(time.DateTime)),
+		textLine("Message: "+event.Message),
+	)
+	return lines
+}
+
+func textLine(text string) []FeishuPostElement {
+	return []FeishuPostElement{{Tag: postTextTag, Text: text}}
+}
+
+// linkLine is a line of a title and a value linking to href, or plain text without href.
+func linkLine(title, value, href string) []FeishuPostElement {
+	if href == "" {
+		return textLine(title + value)
+	}
+	return []FeishuPostElement{{Tag: postTextTag, Text: title}, {Tag: postLinkTag, Text: value, Href: href}}
+}
+
+func createMsgFromDigest(f *FeishuSink, digest *ratelimit.Digest) *FeishuMsg {
+	template := DIGEST_TEMPLATE
+	for _, label := range f.Labels {
+		template = fmt.Sprintf(dingtalk.LABEL_TEMPLATE, label) + template
+	}
+	return &FeishuMsg{
+		MsgType: TEXT_MSG_TYPE,
+		Content: &FeishuContent{
+			Text: fmt.Sprintf(template, digest.Total, strings.Join(digest.Lines(), "\n")),
+		},
+	}
+}
+
+func NewFeishuSink(uri *url.URL) (*FeishuSink, error) {
+	f := &FeishuSink{
+		Level:   WARNING,
+		MsgType: DEFAULT_MSG_TYPE,
+		client:  &http.Client{Timeout: requestTimeout},
+	}
+	if len(uri.Host) == 0 {
+		return nil, fmt.Errorf("you must provide the feishu robot webhook url")
+	}
+	scheme := uri.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	f.Endpoint = (&url.URL{Scheme: scheme, Host: uri.Host, Path: uri.Path}).String()
+	opts := uri.Query()
+
+	if len(opts["level"]) >= 1 {
+		f.Level = util.GetLevel(opts["level"][0])
+	}
+	if len(opts["sign"]) >= 1 {
+		f.Secret = opts["sign"][0]
+	}
+	//add extra labels
+	if len(opts["label"]) >= 1 {
+		f.Labels = opts["label"]
+	}
+	if msgType := opts["msg_type"]; len(msgType) >= 1 {
+		switch msgType[0] {
+		case TEXT_MSG_TYPE, POST_MSG_TYPE, INTERACTIVE_MSG_TYPE:
+			f.MsgType = msgType[0]
+		default:
+			return nil, fmt.Errorf("msg_type must be %s, %s or %s, got %q", TEXT_MSG_TYPE, POST_MSG_TYPE, INTERACTIVE_MSG_TYPE, msgType[0])
+		}
+	}
+	if clusterID := opts["cluster_id"]; len(clusterID) >= 1 {
+		f.ClusterID = clusterID[0]
+	}
+	if region := opts["region"]; len(region) >= 1 {
+		f.Region = region[0]
+	}
+
+	limiter, err := ratelimit.NewLimiter(FEISHU_SINK, opts)
+	if err != nil {
+		return nil, err
+	}
+	f.limiter = limiter
+
+	return f, nil
+}
+
+// sign signs the timestamp in seconds as feishu expects: the key of the HMAC is the timestamp and
+// the secret, the data is empty.
+func sign(t int64, secret string) string {
+	strToHash := fmt.Sprintf("%d\n%s", t, secret)
+	hmac256 := hmac.New(sha256.New, []byte(strToHash))
+	data := hmac256.Sum(nil)
+	return base64.StdEncoding.EncodeToString(data)
+}
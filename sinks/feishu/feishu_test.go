@@ -0,0 +1,133 @@
+package feishu
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/sinks/dingtalk"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func createTestEvent() *v1.Event {
+	now := time.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nginx",
+			Namespace: "default",
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:      "Pod",
+			Namespace: "default",
+			Name:      "nginx",
+		},
+		Reason:        "BackOff",
+		Message:       "Back-off restarting failed container",
+		LastTimestamp: metav1.NewTime(now),
+		Type:          v1.EventTypeWarning,
+		Source:        v1.EventSource{Host: "cn-hangzhou.i-xxxxxxxx"},
+	}
+	return event
+}
+
+func TestSign(t *testing.T) {
+	var timestamp int64 = 1599360473
+	mac := hmac.New(sha256.New, []byte("1599360473\nsecret"))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), sign(timestamp, "secret"))
+}
+
+func TestNewFeishuSink(t *testing.T) {
+	u, _ := url.Parse("https://open.feishu.cn/open-apis/bot/v2/hook/xxx?level=Normal&sign=secret&label=abcd&msg_type=post&cluster_id=c1&region=cn-hangzhou")
+	f, err := NewFeishuSink(u)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://open.feishu.cn/open-apis/bot/v2/hook/xxx", f.Endpoint)
+	assert.Equal(t, NORMAL, f.Level)
+	assert.Equal(t, "secret", f.Secret)
+	assert.Equal(t, POST_MSG_TYPE, f.MsgType)
+	assert.Equal(t, "c1", f.ClusterID)
+	assert.Equal(t, "cn-hangzhou", f.Region)
+
+	u, _ = url.Parse("https://open.feishu.cn/open-apis/bot/v2/hook/xxx?msg_type=markdown")
+	_, err = NewFeishuSink(u)
+	assert.Error(t, err)
+}
+
+func TestCreateMsgFromEvent(t *testing.T) {
+	u, _ := url.Parse("https://open.feishu.cn/open-apis/bot/v2/hook/xxx?label=abcd&cluster_id=c1&region=cn-hangzhou")
+	f, _ := NewFeishuSink(u)
+	event := createTestEvent()
+
+	msg := createMsgFromEvent(f, event)
+	assert.Equal(t, TEXT_MSG_TYPE, msg.MsgType)
+	assert.Contains(t, msg.Content.Text, "abcd\nLevel:Warning \nKind:Pod")
+
+	f.MsgType = POST_MSG_TYPE
+	msg = createMsgFromEvent(f, event)
+	post := msg.Content.Post[postLanguage]
+	assert.Equal(t, "Kubernetes(ID:c1) Event", post.Title)
+	assert.Equal(t, textLine("label[0]: abcd"), post.Content[0])
+	assert.Equal(t, []FeishuPostElement{
+		{Tag: postTextTag, Text: "Node: "},
+		{Tag: postLinkTag, Text: "cn-hangzhou.i-xxxxxxxx", Href: dingtalk.NodeURL("cn-hangzhou.i-xxxxxxxx")},
+	}, post.Content[1])
+	assert.Contains(t, post.Content, []FeishuPostElement{
+		{Tag: postTextTag, Text: "Name: "},
+		{Tag: postLinkTag, Text: "nginx", Href: dingtalk.ResourceURL("c1", "cn-hangzhou", event)},
+	})
+
+	f.MsgType = INTERACTIVE_MSG_TYPE
+	msg = createMsgFromEvent(f, event)
+	assert.Nil(t, msg.Content)
+	assert.Equal(t, cardTemplateWarning, msg.Card.Header.Template)
+	markdown := dingtalk.NewMarkdownMsgBuilder("c1", "cn-hangzhou", event)
+	markdown.AddNodeName(event.Source.Host)
+	markdown.AddLabels([]string{"abcd"})
+	assert.Equal(t, markdown.Build(), msg.Card.Elements[0].Text.Content)
+}
+
+func TestExportEvents(t *testing.T) {
+	var msgs []map[string]interface{}
+	response := `{"code":0,"msg":"success"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		msg := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(body, &msg))
+		msgs = append(msgs, msg)
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/open-apis/bot/v2/hook/xxx?sign=secret")
+	f, err := NewFeishuSink(u)
+	assert.NoError(t, err)
+
+	normal := createTestEvent()
+	normal.Type = v1.EventTypeNormal
+	batch := &core.EventBatch{Events: []*v1.Event{createTestEvent(), normal}}
+	assert.Empty(t, f.ExportEventsWithResult(batch))
+	assert.Len(t, msgs, 1)
+	timestamp, err := strconv.ParseInt(msgs[0]["timestamp"].(string), 10, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, sign(timestamp, "secret"), msgs[0]["sign"])
+
+	response = `{"code":11232,"msg":"frequency limited"}`
+	failures := f.ExportEventsWithResult(batch)
+	assert.Len(t, failures, 1)
+	assert.True(t, core.IsRetryable(failures[0].Err))
+
+	response = `{"code":19021,"msg":"sign match fail or timestamp is not within one hour from current time"}`
+	failures = f.ExportEventsWithResult(batch)
+	assert.Len(t, failures, 1)
+	assert.False(t, core.IsRetryable(failures[0].Err))
+}
@@ -0,0 +1,401 @@
+package file
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	logsink "github.com/AliyunContainerService/kube-eventer/sinks/log"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+const (
+	SinkName = "FileSink"
+
+	defaultMaxSize       = 100 * 1024 * 1024
+	defaultMaxFiles      = 10
+	defaultFsyncInterval = time.Second
+
+	// Suffix of the time a file was rotated at, sorting in the order of rotation.
+	rotatedTimeFormat = "20060102T150405.000"
+)
+
+/*
+file sink usage
+--sink=file:/var/log/kube-eventer/events.json?max_size=100Mi&rotate_interval=1h&max_files=24
+
+max_size: size of the file before it is rotated, e.g. 100Mi (default 100Mi). 0 disables rotation by size.
+rotate_interval: rotates the file at every interval, e.g. 1h for hourly files. Disabled by default.
+max_files: rotated files kept, the oldest are removed (default 10). 0 keeps all.
+compress: gzips the rotated files (default true).
+fsync_interval: duration between syncs of the file to disk (default 1s). 0 syncs after every batch.
+*/
+type FileSink struct {
+	path           string
+	maxSize        int64
+	rotateInterval time.Duration
+	maxFiles       int
+	compress       bool
+	fsyncInterval  time.Duration
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+	// start of the interval the file was written in.
+	opened time.Time
+	dirty  bool
+	now    func() time.Time
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func (f *FileSink) Name() string {
+	return SinkName
+}
+
+func (f *FileSink) Stop() {
+	f.stopOnce.Do(func() { close(f.stopCh) })
+	f.wg.Wait()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return
+	}
+	if err := f.file.Sync(); err != nil {
+		klog.Errorf("failed to sync %s: %v", f.path, err)
+	}
+	if err := f.file.Close(); err != nil {
+		klog.Errorf("failed to close %s: %v", f.path, err)
+	}
+	f.file = nil
+}
+
+func (f *FileSink) ExportEvents(batch *core.EventBatch) {
+	for _, failure := range f.ExportEventsWithResult(batch) {
+		klog.Errorf("failed to write event to %s, because of %v", f.path, failure.Err)
+	}
+}
+
+func (f *FileSink) ExportEventsWithResult(batch *core.EventBatch) []core.ExportFailure {
+	if len(batch.Events) == 0 {
+		return nil
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	for _, event := range batch.Events {
+		if err := encoder.Encode(logsink.NewRecord(event)); err != nil {
+			klog.Errorf("failed to marshal event %v: %v", event, err)
+		}
+	}
+
+	if err := f.write(buffer.Bytes()); err != nil {
+		failures := make([]core.ExportFailure, 0, len(batch.Events))
+		for _, event := range batch.Events {
+			failures = append(failures, core.NewExportFailure(event, err))
+		}
+		return failures
+	}
+	return nil
+}
+
+// write appends the lines of a batch at once, rotating the file before if it is full or its interval is over.
+func (f *FileSink) write(lines []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	now := f.now()
+	if f.size == 0 {
+		// an empty file is reused for the current interval.
+		f.opened = f.intervalStart(now)
+	} else if f.shouldRotate(int64(len(lines)), now) {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := f.file.Write(lines); err != nil {
+		// cut off a partially written batch, so that the file only has complete lines.
+		if err := f.file.Truncate(f.size); err != nil {
+			klog.Errorf("failed to truncate %s after a failed write: %v", f.path, err)
+		}
+		return fmt.Errorf("failed to write %s: %v", f.path, err)
+	}
+	f.size += int64(len(lines))
+	f.dirty = true
+	if f.fsyncInterval == 0 {
+		return f.sync()
+	}
+	return nil
+}
+
+func (f *FileSink) shouldRotate(size int64, now time.Time) bool {
+	if f.maxSize > 0 && f.size+size > f.maxSize {
+		return true
+	}
+	return f.rotateInterval > 0 && f.intervalStart(now).After(f.opened)
+}
+
+func (f *FileSink) intervalStart(t time.Time) time.Time {
+	if f.rotateInterval <= 0 {
+		return time.Time{}
+	}
+	return t.Truncate(f.rotateInterval)
+}
+
+func (f *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return fmt.Errorf("failed to create the directory of %s: %v", f.path, err)
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", f.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat %s: %v", f.path, err)
+	}
+	f.file = file
+	f.size = info.Size()
+	// a file left by a previous run belongs to the interval it was last written in.
+	f.opened = f.intervalStart(info.ModTime())
+	return nil
+}
+
+// rotate renames the current file with the time of rotation, compresses it and removes the files over max_files.
+func (f *FileSink) rotate() error {
+	if err := f.file.Sync(); err != nil {
+		klog.Errorf("failed to sync %s: %v", f.path, err)
+	}
+	if err := f.file.Close(); err != nil {
+		klog.Errorf("failed to close %s: %v", f.path, err)
+	}
+	f.file = nil
+	f.dirty = false
+
+	rotated := f.rotatedPath()
+	if err := os.Rename(f.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate %s: %v", f.path, err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.opened = f.intervalStart(f.now())
+
+	if f.compress {
+		if err := compress(rotated); err != nil {
+			klog.Errorf("failed to compress %s: %v", rotated, err)
+		}
+	}
+	f.removeOldFiles()
+	return nil
+}
+
+// rotatedPath returns a free path like events-20240101T100000.000.json for the rotated file.
+func (f *FileSink) rotatedPath() string {
+	ext := filepath.Ext(f.path)
+	base := strings.TrimSuffix(f.path, ext) + "-" + f.now().UTC().Format(rotatedTimeFormat)
+	path := base + ext
+	for i := 1; exists(path) || exists(path+".gz"); i++ {
+		path = fmt.Sprintf("%s.%d%s", base, i, ext)
+	}
+	return path
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// rotatedFiles lists the rotated files of the sink, the oldest first.
+func (f *FileSink) rotatedFiles() ([]string, error) {
+	ext := filepath.Ext(f.path)
+	prefix := filepath.Base(strings.TrimSuffix(f.path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if !strings.HasSuffix(name, ext) && !strings.HasSuffix(name, ext+".gz") {
+			continue
+		}
+		if len(name) < len(prefix)+len(rotatedTimeFormat) {
+			continue
+		}
+		if _, err := time.Parse(rotatedTimeFormat, name[len(prefix):len(prefix)+len(rotatedTimeFormat)]); err != nil {
+			continue
+		}
+		files = append(files, filepath.Join(filepath.Dir(f.path), name))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (f *FileSink) removeOldFiles() {
+	if f.maxFiles <= 0 {
+		return
+	}
+	files, err := f.rotatedFiles()
+	if err != nil {
+		klog.Errorf("failed to list the rotated files of %s: %v", f.path, err)
+		return
+	}
+	for len(files) > f.maxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			klog.Errorf("failed to remove %s: %v", files[0], err)
+		}
+		files = files[1:]
+	}
+}
+
+func (f *FileSink) sync() error {
+	if f.file == nil || !f.dirty {
+		return nil
+	}
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %v", f.path, err)
+	}
+	f.dirty = false
+	return nil
+}
+
+// syncLoop syncs the file to disk every fsync interval.
+func (f *FileSink) syncLoop() {
+	defer f.wg.Done()
+	ticker := time.NewTicker(f.fsyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.lock.Lock()
+			if err := f.sync(); err != nil {
+				klog.Errorf("%v", err)
+			}
+			f.lock.Unlock()
+		case <-f.stopCh:
+			return
+		}
+	}
+}
+
+func NewFileSink(uri *url.URL) (*FileSink, error) {
+	path := uri.Path
+	if path == "" {
+		path = uri.Opaque
+	}
+	if path == "" {
+		return nil, fmt.Errorf("you must provide the path of the file")
+	}
+	f := &FileSink{
+		path:          filepath.Clean(path),
+		maxSize:       defaultMaxSize,
+		maxFiles:      defaultMaxFiles,
+		compress:      true,
+		fsyncInterval: defaultFsyncInterval,
+		now:           time.Now,
+		stopCh:        make(chan struct{}),
+	}
+	opts := uri.Query()
+
+	if len(opts["max_size"]) >= 1 {
+		size, err := resource.ParseQuantity(opts["max_size"][0])
+		if err != nil || size.Sign() < 0 {
+			return nil, fmt.Errorf("max_size must be a non-negative size such as 100Mi, got %q", opts["max_size"][0])
+		}
+		f.maxSize = size.Value()
+	}
+	if len(opts["rotate_interval"]) >= 1 {
+		interval, err := time.ParseDuration(opts["rotate_interval"][0])
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("rotate_interval must be a non-negative duration, got %q", opts["rotate_interval"][0])
+		}
+		f.rotateInterval = interval
+	}
+	if len(opts["max_files"]) >= 1 {
+		maxFiles, err := strconv.Atoi(opts["max_files"][0])
+		if err != nil || maxFiles < 0 {
+			return nil, fmt.Errorf("max_files must be a non-negative number, got %q", opts["max_files"][0])
+		}
+		f.maxFiles = maxFiles
+	}
+	if len(opts["compress"]) >= 1 {
+		compress, err := strconv.ParseBool(opts["compress"][0])
+		if err != nil {
+			return nil, fmt.Errorf("compress must be true or false, got %q", opts["compress"][0])
+		}
+		f.compress = compress
+	}
+	if len(opts["fsync_interval"]) >= 1 {
+		interval, err := time.ParseDuration(opts["fsync_interval"][0])
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("fsync_interval must be a non-negative duration, got %q", opts["fsync_interval"][0])
+		}
+		f.fsyncInterval = interval
+	}
+
+	// the file is opened straight away to fail early on a path which can't be written.
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	if f.fsyncInterval > 0 {
+		f.wg.Add(1)
+		go f.syncLoop()
+	}
+	return f, nil
+}
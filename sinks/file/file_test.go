@@ -0,0 +1,192 @@
+package file
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	logsink "github.com/AliyunContainerService/kube-eventer/sinks/log"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+)
+
+func newBatch(n int) *core.EventBatch {
+	batch := &core.EventBatch{}
+	for i := 0; i < n; i++ {
+		batch.Events = append(batch.Events, util.NewDummyEvent(v1.EventTypeWarning, "Pod", "nginx", "BackOff"))
+	}
+	return batch
+}
+
+func newSink(t *testing.T, dir, query string) *FileSink {
+	uri, err := url.Parse(filepath.Join(dir, "events.json") + "?" + query)
+	assert.NoError(t, err)
+	f, err := NewFileSink(uri)
+	assert.NoError(t, err)
+	return f
+}
+
+// readLines reads the records of a file, gzipped or not.
+func readLines(t *testing.T, path string) []logsink.Record {
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	var records []logsink.Record
+	var scanner *bufio.Scanner
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(reader)
+		assert.NoError(t, err)
+		scanner = bufio.NewScanner(gz)
+	} else {
+		scanner = bufio.NewScanner(reader)
+	}
+	for scanner.Scan() {
+		var record logsink.Record
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestNewFileSink(t *testing.T) {
+	dir := t.TempDir()
+	f := newSink(t, dir, "max_size=1Ki&rotate_interval=1h&max_files=3&compress=false&fsync_interval=0")
+	defer f.Stop()
+	assert.Equal(t, filepath.Join(dir, "events.json"), f.path)
+	assert.Equal(t, int64(1024), f.maxSize)
+	assert.Equal(t, time.Hour, f.rotateInterval)
+	assert.Equal(t, 3, f.maxFiles)
+	assert.False(t, f.compress)
+	assert.Equal(t, time.Duration(0), f.fsyncInterval)
+
+	for _, invalid := range []string{"max_size=big", "rotate_interval=hourly", "max_files=-1", "compress=yes", "fsync_interval=-1s"} {
+		uri, _ := url.Parse(filepath.Join(dir, "events.json") + "?" + invalid)
+		_, err := NewFileSink(uri)
+		assert.Error(t, err, invalid)
+	}
+	_, err := NewFileSink(&url.URL{})
+	assert.Error(t, err)
+}
+
+func TestExportEvents(t *testing.T) {
+	dir := t.TempDir()
+	f := newSink(t, dir, "fsync_interval=0")
+
+	assert.Empty(t, f.ExportEventsWithResult(newBatch(2)))
+	assert.Empty(t, f.ExportEventsWithResult(newBatch(1)))
+	f.Stop()
+
+	records := readLines(t, filepath.Join(dir, "events.json"))
+	assert.Len(t, records, 3)
+	assert.Equal(t, "BackOff", records[0].Reason)
+	assert.Equal(t, "nginx", records[0].Name)
+
+	// a new sink appends to the file.
+	f = newSink(t, dir, "")
+	assert.Empty(t, f.ExportEventsWithResult(newBatch(1)))
+	f.Stop()
+	assert.Len(t, readLines(t, filepath.Join(dir, "events.json")), 4)
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	f := newSink(t, dir, "max_size=600&max_files=2&fsync_interval=0")
+	defer f.Stop()
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	f.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	// every batch is about 500 bytes, so every batch after the first rotates the file.
+	for i := 0; i < 4; i++ {
+		assert.Empty(t, f.ExportEventsWithResult(newBatch(2)))
+	}
+
+	files, err := f.rotatedFiles()
+	assert.NoError(t, err)
+	// the oldest of the three rotated files was removed.
+	assert.Len(t, files, 2)
+	for _, file := range files {
+		assert.True(t, strings.HasPrefix(filepath.Base(file), "events-20240101T1000"), file)
+		assert.True(t, strings.HasSuffix(file, ".json.gz"), file)
+		assert.Len(t, readLines(t, file), 2)
+	}
+	assert.Len(t, readLines(t, filepath.Join(dir, "events.json")), 2)
+}
+
+func TestRotateByInterval(t *testing.T) {
+	dir := t.TempDir()
+	f := newSink(t, dir, "max_size=0&rotate_interval=1h&compress=false&fsync_interval=10ms")
+	now := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+
+	assert.Empty(t, f.ExportEventsWithResult(newBatch(1)))
+	now = now.Add(20 * time.Minute)
+	assert.Empty(t, f.ExportEventsWithResult(newBatch(1)))
+	now = now.Add(20 * time.Minute)
+	assert.Empty(t, f.ExportEventsWithResult(newBatch(1)))
+	f.Stop()
+
+	files, err := f.rotatedFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "events-20240101T111000.000.json")}, files)
+	assert.Len(t, readLines(t, files[0]), 2)
+	assert.Len(t, readLines(t, filepath.Join(dir, "events.json")), 1)
+}
+
+func TestExportEventsWithoutFile(t *testing.T) {
+	dir := t.TempDir()
+	f := newSink(t, dir, "fsync_interval=0")
+	defer f.Stop()
+
+	// a directory in place of the file can't be opened.
+	f.lock.Lock()
+	f.file.Close()
+	f.file = nil
+	f.lock.Unlock()
+	assert.NoError(t, os.Remove(f.path))
+	assert.NoError(t, os.Mkdir(f.path, 0755))
+
+	failures := f.ExportEventsWithResult(newBatch(2))
+	assert.Len(t, failures, 2)
+
+	entries, _ := ioutil.ReadDir(dir)
+	assert.Len(t, entries, 1)
+}
+
+func TestExportEventsKeepsSizeOnFailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	f := newSink(t, dir, "fsync_interval=0")
+	defer f.Stop()
+	assert.Empty(t, f.ExportEventsWithResult(newBatch(1)))
+
+	// a file opened read only refuses the write.
+	f.lock.Lock()
+	size := f.size
+	f.file.Close()
+	file, err := os.Open(f.path)
+	assert.NoError(t, err)
+	f.file = file
+	f.lock.Unlock()
+
+	assert.Len(t, f.ExportEventsWithResult(newBatch(2)), 2)
+	assert.Equal(t, size, f.size)
+	assert.Len(t, readLines(t, f.path), 1)
+}
+
+func TestStopTwice(t *testing.T) {
+	f := newSink(t, t.TempDir(), "")
+	f.Stop()
+	f.Stop()
+}
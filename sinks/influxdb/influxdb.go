@@ -132,7 +132,7 @@ func (sink *influxdbSink) ExportEvents(eventBatch *core.EventBatch) {
 			klog.Warningf("Failed to convert event to point: %v", err)
 		}
 
-		point.Tags["cluster_name"] = sink.c.ClusterName
+		point.Tags["cluster_name"] = util.GetClusterName(event, sink.c.ClusterName)
 
 		dataPoints = append(dataPoints, *point)
 		if len(dataPoints) >= maxSendBatchSize {
@@ -15,10 +15,13 @@
 package kafka
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"github.com/AliyunContainerService/kube-eventer/util"
 	"net/url"
 	"sync"
+	"text/template"
 	"time"
 
 	"k8s.io/klog/v2"
@@ -38,6 +41,10 @@ type KafkaSinkPoint struct {
 type kafkaSink struct {
 	kafka_common.KafkaClient
 	sync.RWMutex
+	// key template of the messages, such as {{.InvolvedObject.Namespace}}/{{.InvolvedObject.Kind}}/{{.InvolvedObject.Name}}
+	// to keep the events of an object in order on a partition.
+	key         *template.Template
+	clusterName string
 }
 
 func getEventValue(event *kube_api.Event) (string, error) {
@@ -70,29 +77,103 @@ func eventToPoint(event *kube_api.Event) (*KafkaSinkPoint, error) {
 }
 
 func (sink *kafkaSink) ExportEvents(eventBatch *event_core.EventBatch) {
+	for _, failure := range sink.ExportEventsWithResult(eventBatch) {
+		klog.Errorf("Failed to produce event message: %s", failure.Err)
+	}
+}
+
+func (sink *kafkaSink) ExportEventsWithResult(eventBatch *event_core.EventBatch) []event_core.ExportFailure {
 	sink.Lock()
 	defer sink.Unlock()
 
+	var failures []event_core.ExportFailure
+	var events []*kube_api.Event
+	var messages []*kafka_common.Message
 	for _, event := range eventBatch.Events {
 		point, err := eventToPoint(event)
 		if err != nil {
-			klog.Warningf("Failed to convert event to point: %v", err)
+			failures = append(failures, event_core.NewExportFailure(event, fmt.Errorf("failed to convert event to point: %v", err)))
+			continue
 		}
-
-		err = sink.ProduceKafkaMessage(*point)
+		key, err := sink.getKey(event)
 		if err != nil {
-			klog.Errorf("Failed to produce event message: %s", err)
+			failures = append(failures, event_core.NewExportFailure(event, fmt.Errorf("failed to render message key: %v", err)))
+			continue
 		}
+		events = append(events, event)
+		messages = append(messages, &kafka_common.Message{
+			Key:     key,
+			Headers: sink.getHeaders(event),
+			Value:   *point,
+		})
 	}
+	if len(messages) == 0 {
+		return failures
+	}
+
+	for i, err := range sink.ProduceKafkaMessages(messages) {
+		if err == nil {
+			continue
+		}
+		if kafka_common.IsRetryableError(err) {
+			err = event_core.NewRetryableError(err)
+		}
+		failures = append(failures, event_core.NewExportFailure(events[i], err))
+	}
+	return failures
+}
+
+func (sink *kafkaSink) getKey(event *kube_api.Event) (string, error) {
+	if sink.key == nil {
+		return "", nil
+	}
+	var key bytes.Buffer
+	if err := sink.key.Execute(&key, event); err != nil {
+		return "", err
+	}
+	return key.String(), nil
+}
+
+func (sink *kafkaSink) getHeaders(event *kube_api.Event) map[string]string {
+	headers := map[string]string{
+		"type":   event.Type,
+		"reason": event.Reason,
+	}
+	if cluster := util.GetClusterName(event, sink.clusterName); cluster != "" {
+		headers["cluster"] = cluster
+	}
+	return headers
+}
+
+// getKeyTemplate returns the template of the message keys, nil without a message_key. The key
+// option is the private key of the client certificate.
+func getKeyTemplate(opts url.Values) (*template.Template, error) {
+	if len(opts["message_key"]) == 0 || opts["message_key"][0] == "" {
+		return nil, nil
+	}
+	key, err := template.New("message_key").Parse(opts["message_key"][0])
+	if err != nil {
+		return nil, fmt.Errorf("message_key must be a template such as {{.InvolvedObject.Namespace}}/{{.InvolvedObject.Name}}: %v", err)
+	}
+	return key, nil
 }
 
 func NewKafkaSink(uri *url.URL) (event_core.EventSink, error) {
-	client, err := kafka_common.NewKafkaClient(uri, kafka_common.EventsTopic)
+	opts := uri.Query()
+	sink := &kafkaSink{}
+	key, err := getKeyTemplate(opts)
 	if err != nil {
 		return nil, err
 	}
+	sink.key = key
+	if len(opts["cluster_name"]) >= 1 {
+		sink.clusterName = opts["cluster_name"][0]
+	}
 
-	return &kafkaSink{
-		KafkaClient: client,
-	}, nil
+	client, err := kafka_common.NewKafkaClient(uri, kafka_common.EventsTopic)
+	if err != nil {
+		return nil, err
+	}
+	sink.KafkaClient = client
+	return sink, nil
 }
@@ -15,17 +15,24 @@
 package kafka
 
 import (
+	"net/url"
 	"testing"
+	"text/template"
 	"time"
 
+	kafka_common "github.com/AliyunContainerService/kube-eventer/common/kafka"
 	event_core "github.com/AliyunContainerService/kube-eventer/core"
+	kafka "github.com/Shopify/sarama"
 	"github.com/stretchr/testify/assert"
 	kube_api "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type fakeKafkaClient struct {
-	points []KafkaSinkPoint
+	points   []KafkaSinkPoint
+	messages []*kafka_common.Message
+	// errs are returned for the messages if set.
+	errs []error
 }
 
 type fakeKafkaSink struct {
@@ -34,15 +41,21 @@ type fakeKafkaSink struct {
 }
 
 func NewFakeKafkaClient() *fakeKafkaClient {
-	return &fakeKafkaClient{[]KafkaSinkPoint{}}
+	return &fakeKafkaClient{points: []KafkaSinkPoint{}}
 }
 
-func (client *fakeKafkaClient) ProduceKafkaMessage(msgData interface{}) error {
-	if point, ok := msgData.(KafkaSinkPoint); ok {
-		client.points = append(client.points, point)
+func (client *fakeKafkaClient) ProduceKafkaMessages(messages []*kafka_common.Message) []error {
+	for _, message := range messages {
+		if point, ok := message.Value.(KafkaSinkPoint); ok {
+			client.points = append(client.points, point)
+		}
+		client.messages = append(client.messages, message)
 	}
 
-	return nil
+	if client.errs != nil {
+		return client.errs
+	}
+	return make([]error, len(messages))
 }
 
 func (client *fakeKafkaClient) Name() string {
@@ -99,3 +112,66 @@ func TestStoreMultipleEventsInput(t *testing.T) {
 	assert.Equal(t, 2, len(fakeSink.fakeClient.points))
 
 }
+
+func TestMessageKeyAndHeaders(t *testing.T) {
+	fakeSink := NewFakeSink()
+	sink := fakeSink.EventSink.(*kafkaSink)
+	sink.key = template.Must(template.New("key").Parse("{{.InvolvedObject.Namespace}}/{{.InvolvedObject.Kind}}/{{.InvolvedObject.Name}}"))
+	sink.clusterName = "prod"
+
+	event := &kube_api.Event{
+		Type:   kube_api.EventTypeWarning,
+		Reason: "BackOff",
+		InvolvedObject: kube_api.ObjectReference{
+			Kind:      "Pod",
+			Namespace: "default",
+			Name:      "nginx",
+		},
+	}
+	fakeSink.ExportEvents(&event_core.EventBatch{Events: []*kube_api.Event{event}})
+
+	assert.Equal(t, 1, len(fakeSink.fakeClient.messages))
+	message := fakeSink.fakeClient.messages[0]
+	assert.Equal(t, "default/Pod/nginx", message.Key)
+	assert.Equal(t, map[string]string{
+		"cluster": "prod",
+		"type":    "Warning",
+		"reason":  "BackOff",
+	}, message.Headers)
+}
+
+func TestExportEventsWithResult(t *testing.T) {
+	fakeSink := NewFakeSink()
+	sink := fakeSink.EventSink.(*kafkaSink)
+	fakeSink.fakeClient.errs = []error{nil, kafka.ErrNotLeaderForPartition, kafka.ErrMessageSizeTooLarge}
+	events := []*kube_api.Event{{Message: "sent"}, {Message: "retried"}, {Message: "too large"}}
+
+	failures := sink.ExportEventsWithResult(&event_core.EventBatch{Events: events})
+	assert.Len(t, failures, 2)
+	assert.Equal(t, events[1], failures[0].Event)
+	assert.True(t, failures[0].Retryable)
+	assert.Equal(t, events[2], failures[1].Event)
+	assert.False(t, failures[1].Retryable)
+}
+
+func TestNewKafkaSinkInvalidKey(t *testing.T) {
+	uri, _ := url.Parse("kafka:?brokers=localhost:9092&message_key={{.InvolvedObject.Name")
+	_, err := NewKafkaSink(uri)
+	assert.Error(t, err)
+}
+
+func TestKeyTemplateWithClientCertificate(t *testing.T) {
+	// key is the private key of the client certificate, not the template of the message keys.
+	opts, _ := url.ParseQuery("cert=/etc/kafka/client.pem&key=/etc/kafka/client-key.pem")
+	key, err := getKeyTemplate(opts)
+	assert.NoError(t, err)
+	assert.Nil(t, key)
+
+	opts.Set("message_key", "{{.InvolvedObject.Namespace}}/{{.InvolvedObject.Name}}")
+	key, err = getKeyTemplate(opts)
+	assert.NoError(t, err)
+	sink := &kafkaSink{key: key}
+	rendered, err := sink.getKey(&kube_api.Event{InvolvedObject: kube_api.ObjectReference{Namespace: "default", Name: "nginx"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "default/nginx", rendered)
+}
@@ -16,14 +16,54 @@ package logsink
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
-	"github.com/AliyunContainerService/kube-eventer/util"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	kube_api "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
 
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// Record is the normalized schema of an event written in the json and logfmt formats.
+type Record struct {
+	Cluster        string `json:"cluster"`
+	Namespace      string `json:"namespace"`
+	Kind           string `json:"kind"`
+	Name           string `json:"name"`
+	Reason         string `json:"reason"`
+	Type           string `json:"type"`
+	Count          int32  `json:"count"`
+	FirstTimestamp string `json:"first_timestamp"`
+	LastTimestamp  string `json:"last_timestamp"`
+	Message        string `json:"message"`
+}
+
+/*
+log sink usage
+--sink=log:?format=json
+
+format: text (default) writes the events through klog, json and logfmt write a line per event to
+stdout without the klog header, for log collectors to parse.
+*/
 type LogSink struct {
+	format string
+	lock   sync.Mutex
+	out    io.Writer
 }
 
 func (this *LogSink) Name() string {
@@ -43,10 +83,140 @@ func batchToString(batch *core.EventBatch) string {
 	return buffer.String()
 }
 
+// NewRecord normalizes an event into the schema of the json and logfmt formats.
+func NewRecord(event *kube_api.Event) *Record {
+	namespace := event.InvolvedObject.Namespace
+	if namespace == "" {
+		namespace = event.Namespace
+	}
+	lastTimestamp := util.GetLastEventTimestamp(event)
+	firstTimestamp := event.FirstTimestamp.Time
+	if firstTimestamp.IsZero() {
+		firstTimestamp = event.EventTime.Time
+	}
+	if firstTimestamp.IsZero() {
+		firstTimestamp = lastTimestamp
+	}
+	count := event.Count
+	if event.Series != nil && event.Series.Count > count {
+		count = event.Series.Count
+	}
+	return &Record{
+		Cluster:        util.GetClusterName(event, ""),
+		Namespace:      namespace,
+		Kind:           event.InvolvedObject.Kind,
+		Name:           event.InvolvedObject.Name,
+		Reason:         event.Reason,
+		Type:           event.Type,
+		Count:          count,
+		FirstTimestamp: firstTimestamp.UTC().Format(time.RFC3339),
+		LastTimestamp:  lastTimestamp.UTC().Format(time.RFC3339),
+		Message:        event.Message,
+	}
+}
+
+// batchToJSON writes a json object per event and line.
+func batchToJSON(batch *core.EventBatch) (string, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	for _, event := range batch.Events {
+		if err := encoder.Encode(NewRecord(event)); err != nil {
+			return "", err
+		}
+	}
+	return buffer.String(), nil
+}
+
+// batchToLogfmt writes the key=value pairs of an event per line.
+func batchToLogfmt(batch *core.EventBatch) string {
+	var buffer bytes.Buffer
+	for _, event := range batch.Events {
+		buffer.WriteString(NewRecord(event).Logfmt())
+		buffer.WriteByte('\n')
+	}
+	return buffer.String()
+}
+
+// Logfmt returns the key=value pairs of the record on a line, without a line break.
+func (r *Record) Logfmt() string {
+	pairs := []struct {
+		key   string
+		value string
+	}{
+		{"cluster", r.Cluster},
+		{"namespace", r.Namespace},
+		{"kind", r.Kind},
+		{"name", r.Name},
+		{"reason", r.Reason},
+		{"type", r.Type},
+		{"count", strconv.Itoa(int(r.Count))},
+		{"first_timestamp", r.FirstTimestamp},
+		{"last_timestamp", r.LastTimestamp},
+		{"message", r.Message},
+	}
+	var buffer bytes.Buffer
+	for i, pair := range pairs {
+		if i > 0 {
+			buffer.WriteByte(' ')
+		}
+		buffer.WriteString(pair.key)
+		buffer.WriteByte('=')
+		buffer.WriteString(logfmtValue(pair.value))
+	}
+	return buffer.String()
+}
+
+// logfmtValue quotes values which are empty or have spaces, quotes, equal signs or control characters.
+func logfmtValue(value string) string {
+	if value == "" {
+		return `""`
+	}
+	if strings.IndexFunc(value, func(r rune) bool {
+		return r == '"' || r == '=' || r == '\\' || unicode.IsSpace(r) || unicode.IsControl(r)
+	}) >= 0 {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
 func (this *LogSink) ExportEvents(batch *core.EventBatch) {
-	klog.Info(batchToString(batch))
+	var lines string
+	switch this.format {
+	case FormatJSON:
+		var err error
+		if lines, err = batchToJSON(batch); err != nil {
+			klog.Errorf("failed to marshal events to json: %v", err)
+			return
+		}
+	case FormatLogfmt:
+		lines = batchToLogfmt(batch)
+	default:
+		klog.Info(batchToString(batch))
+		return
+	}
+
+	// the lines of a batch are written at once so that they are not interleaved with other batches.
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, err := io.WriteString(this.out, lines); err != nil {
+		klog.Errorf("failed to write events to stdout: %v", err)
+	}
 }
 
-func CreateLogSink() (*LogSink, error) {
-	return &LogSink{}, nil
+func CreateLogSink(uri *url.URL) (*LogSink, error) {
+	sink := &LogSink{
+		format: FormatText,
+		out:    os.Stdout,
+	}
+	opts := uri.Query()
+	if len(opts["format"]) >= 1 {
+		switch opts["format"][0] {
+		case FormatText, FormatJSON, FormatLogfmt:
+			sink.format = opts["format"][0]
+		default:
+			return nil, fmt.Errorf("format must be text, json or logfmt, got %q", opts["format"][0])
+		}
+	}
+	return sink, nil
 }
@@ -15,7 +15,10 @@
 package logsink
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -25,6 +28,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/util"
 )
 
 func TestSimpleWrite(t *testing.T) {
@@ -41,9 +45,83 @@ func TestSimpleWrite(t *testing.T) {
 	}
 
 	log := batchToString(&batch)
-	fmt.Printf(log)
+	fmt.Print(log)
 
 	assert.True(t, strings.Contains(log, "bzium"))
 	assert.True(t, strings.Contains(log, "251"))
 	assert.True(t, strings.Contains(log, fmt.Sprintf("%s", now)))
 }
+
+func newEvent() *kube_api.Event {
+	event := &kube_api.Event{
+		Type:           kube_api.EventTypeWarning,
+		Reason:         "BackOff",
+		Message:        `Back-off restarting failed container "nginx"`,
+		Count:          3,
+		FirstTimestamp: metav1.NewTime(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)),
+		LastTimestamp:  metav1.NewTime(time.Date(2024, 1, 1, 10, 5, 0, 0, time.UTC)),
+		InvolvedObject: kube_api.ObjectReference{
+			Kind:      "Pod",
+			Namespace: "default",
+			Name:      "nginx",
+		},
+	}
+	util.SetClusterName(event, "prod")
+	return event
+}
+
+func TestCreateLogSink(t *testing.T) {
+	uri, _ := url.Parse("?format=logfmt")
+	sink, err := CreateLogSink(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, FormatLogfmt, sink.format)
+
+	sink, err = CreateLogSink(&url.URL{})
+	assert.NoError(t, err)
+	assert.Equal(t, FormatText, sink.format)
+
+	uri, _ = url.Parse("?format=xml")
+	_, err = CreateLogSink(uri)
+	assert.Error(t, err)
+}
+
+func TestJSONWrite(t *testing.T) {
+	uri, _ := url.Parse("?format=json")
+	sink, _ := CreateLogSink(uri)
+	var out bytes.Buffer
+	sink.out = &out
+
+	sink.ExportEvents(&core.EventBatch{Events: []*kube_api.Event{newEvent(), newEvent()}})
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Len(t, lines, 2)
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, map[string]interface{}{
+		"cluster":         "prod",
+		"namespace":       "default",
+		"kind":            "Pod",
+		"name":            "nginx",
+		"reason":          "BackOff",
+		"type":            "Warning",
+		"count":           float64(3),
+		"first_timestamp": "2024-01-01T10:00:00Z",
+		"last_timestamp":  "2024-01-01T10:05:00Z",
+		"message":         `Back-off restarting failed container "nginx"`,
+	}, record)
+}
+
+func TestLogfmtWrite(t *testing.T) {
+	uri, _ := url.Parse("?format=logfmt")
+	sink, _ := CreateLogSink(uri)
+	var out bytes.Buffer
+	sink.out = &out
+
+	event := newEvent()
+	delete(event.Annotations, util.ClusterNameAnnotation)
+	sink.ExportEvents(&core.EventBatch{Events: []*kube_api.Event{event}})
+
+	assert.Equal(t, `cluster="" namespace=default kind=Pod name=nginx reason=BackOff type=Warning count=3 `+
+		`first_timestamp=2024-01-01T10:00:00Z last_timestamp=2024-01-01T10:05:00Z `+
+		`message="Back-off restarting failed container \"nginx\""`+"\n", out.String())
+}
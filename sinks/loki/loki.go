@@ -0,0 +1,367 @@
+package loki
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	logsink "github.com/AliyunContainerService/kube-eventer/sinks/log"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	SinkName = "LokiSink"
+
+	pushPath = "/loki/api/v1/push"
+
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+
+	defaultMaxBatchSize = 1000
+	defaultMaxStreams   = 1000
+	// Loki flushes the chunks of idle streams after 30m by default.
+	defaultStreamWindow = time.Hour
+	// Loki rejects label values longer than 2048 characters by default.
+	maxLabelValueLength = 1024
+)
+
+var (
+	defaultStreamLabels = []string{"cluster", "namespace", "kind", "type"}
+
+	// Labels of the events. name and message only with high_cardinality_labels=true, as they would
+	// create a stream per object or message.
+	eventLabels = map[string]func(record *logsink.Record) string{
+		"cluster":   func(r *logsink.Record) string { return r.Cluster },
+		"namespace": func(r *logsink.Record) string { return r.Namespace },
+		"kind":      func(r *logsink.Record) string { return r.Kind },
+		"reason":    func(r *logsink.Record) string { return r.Reason },
+		"type":      func(r *logsink.Record) string { return r.Type },
+		"name":      func(r *logsink.Record) string { return r.Name },
+		"message":   func(r *logsink.Record) string { return r.Message },
+	}
+	highCardinalityLabels = map[string]bool{"name": true, "message": true}
+)
+
+// PushRequest is the JSON body of the push API.
+type PushRequest struct {
+	Streams []*Stream `json:"streams"`
+}
+
+type Stream struct {
+	Stream map[string]string `json:"stream"`
+	// Pairs of a timestamp in nanoseconds and a line.
+	Values [][2]string `json:"values"`
+}
+
+/*
+loki sink usage
+--sink=loki:http://loki:3100?stream_labels=cluster,namespace,kind,reason&tenant_id=team-a
+
+stream_labels: labels of the streams out of cluster, namespace, kind, reason, type, name and message (default cluster,namespace,kind,type).
+high_cardinality_labels: true to allow name and message as stream labels.
+label: <key>,<value>, a static label of all streams (default job,kube-eventer).
+format: json (default) or logfmt, the format of the lines.
+tenant_id: tenant of a multi-tenant Loki, sent as X-Scope-OrgID.
+cluster_name: cluster label of the events without a cluster name.
+max_streams: streams created per stream_window before the events of new streams only get the static labels (default 1000).
+stream_window: window the streams are counted in, e.g. 30m (default 1h).
+max_batch_size: events per push (default 1000).
+*/
+type LokiSink struct {
+	endpoint     string
+	username     string
+	password     string
+	tenantID     string
+	clusterName  string
+	format       string
+	streamLabels []string
+	staticLabels map[string]string
+	maxStreams   int
+	streamWindow time.Duration
+	maxBatchSize int
+	client       *http.Client
+	now          func() time.Time
+
+	lock sync.Mutex
+	// streams created since windowStart.
+	streams     map[string]bool
+	windowStart time.Time
+	// whether the overflow of streams was logged, to log it once per window.
+	overflowed bool
+}
+
+func (l *LokiSink) Name() string {
+	return SinkName
+}
+
+func (l *LokiSink) Stop() {
+	// do nothing
+}
+
+func (l *LokiSink) ExportEvents(batch *core.EventBatch) {
+	for _, failure := range l.ExportEventsWithResult(batch) {
+		klog.Errorf("failed to push event to loki, because of %v", failure.Err)
+	}
+}
+
+func (l *LokiSink) ExportEventsWithResult(batch *core.EventBatch) []core.ExportFailure {
+	var failures []core.ExportFailure
+	for start := 0; start < len(batch.Events); start += l.maxBatchSize {
+		end := start + l.maxBatchSize
+		if end > len(batch.Events) {
+			end = len(batch.Events)
+		}
+		events := batch.Events[start:end]
+		request, err := l.createRequest(events)
+		if err == nil {
+			err = l.push(request)
+		}
+		if err != nil {
+			for _, event := range events {
+				failures = append(failures, core.NewExportFailure(event, err))
+			}
+		}
+	}
+	return failures
+}
+
+// createRequest groups the events by stream, each stream with its lines in the order of time.
+func (l *LokiSink) createRequest(events []*v1.Event) (*PushRequest, error) {
+	request := &PushRequest{}
+	streams := map[string]*Stream{}
+	for _, event := range events {
+		record := logsink.NewRecord(event)
+		if record.Cluster == "" {
+			record.Cluster = util.GetClusterName(event, l.clusterName)
+		}
+		line, err := l.line(record)
+		if err != nil {
+			return nil, err
+		}
+		labels := l.labels(record)
+		key := streamKey(labels)
+		stream, found := streams[key]
+		if !found {
+			stream = &Stream{Stream: labels}
+			streams[key] = stream
+			request.Streams = append(request.Streams, stream)
+		}
+		timestamp := strconv.FormatInt(util.GetLastEventTimestamp(event).UnixNano(), 10)
+		stream.Values = append(stream.Values, [2]string{timestamp, line})
+	}
+	for _, stream := range request.Streams {
+		sort.SliceStable(stream.Values, func(i, j int) bool {
+			// the timestamps are compared as numbers.
+			a, b := stream.Values[i][0], stream.Values[j][0]
+			if len(a) != len(b) {
+				return len(a) < len(b)
+			}
+			return a < b
+		})
+	}
+	return request, nil
+}
+
+func (l *LokiSink) line(record *logsink.Record) (string, error) {
+	if l.format == FormatLogfmt {
+		return record.Logfmt(), nil
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal event %v: %v", record, err)
+	}
+	return string(line), nil
+}
+
+// labels returns the labels of the stream of a record. Once max_streams streams were created in a
+// stream_window, the records of new streams only get the static labels to bound the cardinality.
+// The streams are forgotten at the end of the window, as Loki flushes the streams no longer written.
+func (l *LokiSink) labels(record *logsink.Record) map[string]string {
+	labels := make(map[string]string, len(l.staticLabels)+len(l.streamLabels))
+	for key, value := range l.staticLabels {
+		labels[key] = value
+	}
+	for _, name := range l.streamLabels {
+		value := eventLabels[name](record)
+		if value == "" {
+			continue
+		}
+		if runes := []rune(value); len(runes) > maxLabelValueLength {
+			value = string(runes[:maxLabelValueLength])
+		}
+		labels[name] = value
+	}
+
+	key := streamKey(labels)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if now := l.now(); now.Sub(l.windowStart) >= l.streamWindow {
+		l.streams = map[string]bool{}
+		l.windowStart = now
+		l.overflowed = false
+	}
+	if l.streams[key] {
+		return labels
+	}
+	if len(l.streams) < l.maxStreams {
+		l.streams[key] = true
+		return labels
+	}
+	if !l.overflowed {
+		klog.Warningf("loki sink created %d streams in %v, the events of new streams only get the static labels", l.maxStreams, l.streamWindow)
+		l.overflowed = true
+	}
+	static := make(map[string]string, len(l.staticLabels))
+	for key, value := range l.staticLabels {
+		static[key] = value
+	}
+	return static
+}
+
+func streamKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var key strings.Builder
+	for _, k := range keys {
+		key.WriteString(k)
+		key.WriteByte('=')
+		key.WriteString(labels[k])
+		key.WriteByte(0)
+	}
+	return key.String()
+}
+
+func (l *LokiSink) push(request *PushRequest) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal push request: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, l.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request to loki: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.tenantID != "" {
+		req.Header.Set("X-Scope-OrgID", l.tenantID)
+	}
+	if l.username != "" || l.password != "" {
+		req.SetBasicAuth(l.username, l.password)
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return core.NewRetryableError(fmt.Errorf("failed to push events to loki: %v", err))
+	}
+	defer resp.Body.Close()
+	// loki answers 204.
+	return util.CheckResponse(resp, "push events to loki")
+}
+
+func NewLokiSink(uri *url.URL) (*LokiSink, error) {
+	if uri.Host == "" {
+		return nil, fmt.Errorf("you must provide the loki url")
+	}
+	// the path is kept as a prefix for Lokis behind a gateway.
+	path := strings.TrimSuffix(uri.Path, "/")
+	if !strings.HasSuffix(path, pushPath) {
+		path += pushPath
+	}
+	l := &LokiSink{
+		endpoint:     (&url.URL{Scheme: uri.Scheme, Host: uri.Host, Path: path}).String(),
+		format:       FormatJSON,
+		streamLabels: defaultStreamLabels,
+		staticLabels: map[string]string{"job": "kube-eventer"},
+		maxStreams:   defaultMaxStreams,
+		streamWindow: defaultStreamWindow,
+		maxBatchSize: defaultMaxBatchSize,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		now:          time.Now,
+		streams:      map[string]bool{},
+	}
+	if uri.User != nil {
+		l.username = uri.User.Username()
+		l.password, _ = uri.User.Password()
+	}
+	opts := uri.Query()
+
+	highCardinality := false
+	if len(opts["high_cardinality_labels"]) >= 1 {
+		var err error
+		if highCardinality, err = strconv.ParseBool(opts["high_cardinality_labels"][0]); err != nil {
+			return nil, fmt.Errorf("high_cardinality_labels must be true or false, got %q", opts["high_cardinality_labels"][0])
+		}
+	}
+	if len(opts["stream_labels"]) >= 1 {
+		l.streamLabels = nil
+		for _, name := range strings.Split(opts["stream_labels"][0], ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if _, found := eventLabels[name]; !found {
+				return nil, fmt.Errorf("stream_labels must be out of cluster, namespace, kind, reason, type, name and message, got %q", name)
+			}
+			if highCardinalityLabels[name] && !highCardinality {
+				return nil, fmt.Errorf("%s creates a stream per value, set high_cardinality_labels=true to use it as a stream label", name)
+			}
+			l.streamLabels = append(l.streamLabels, name)
+		}
+	}
+	if len(opts["label"]) >= 1 {
+		l.staticLabels = util.ParseLabels(opts["label"])
+	}
+	// the static labels are the labels of the streams over max_streams, and Loki rejects streams without labels.
+	if len(l.staticLabels) == 0 {
+		return nil, fmt.Errorf("label must set at least one static label as <key>,<value>, got %q", opts["label"])
+	}
+	if len(opts["format"]) >= 1 {
+		switch opts["format"][0] {
+		case FormatJSON, FormatLogfmt:
+			l.format = opts["format"][0]
+		default:
+			return nil, fmt.Errorf("format must be json or logfmt, got %q", opts["format"][0])
+		}
+	}
+	if len(opts["tenant_id"]) >= 1 {
+		l.tenantID = opts["tenant_id"][0]
+	}
+	if len(opts["cluster_name"]) >= 1 {
+		l.clusterName = opts["cluster_name"][0]
+	}
+	if len(opts["max_streams"]) >= 1 {
+		maxStreams, err := strconv.Atoi(opts["max_streams"][0])
+		if err != nil || maxStreams < 1 {
+			return nil, fmt.Errorf("max_streams must be a positive number, got %q", opts["max_streams"][0])
+		}
+		l.maxStreams = maxStreams
+	}
+	if len(opts["stream_window"]) >= 1 {
+		window, err := time.ParseDuration(opts["stream_window"][0])
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("stream_window must be a positive duration, got %q", opts["stream_window"][0])
+		}
+		l.streamWindow = window
+	}
+	if len(opts["max_batch_size"]) >= 1 {
+		size, err := strconv.Atoi(opts["max_batch_size"][0])
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("max_batch_size must be a positive number, got %q", opts["max_batch_size"][0])
+		}
+		l.maxBatchSize = size
+	}
+
+	return l, nil
+}
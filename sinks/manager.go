@@ -26,6 +26,8 @@ import (
 const (
 	DefaultSinkExportEventsTimeout = 20 * time.Second
 	DefaultSinkStopTimeout         = 60 * time.Second
+	// Events of a sink waiting for their retry, the events over it are given up.
+	DefaultRetryQueueSize = 10000
 )
 
 var (
@@ -53,7 +55,9 @@ type sinkHolder struct {
 
 // Sink Manager - a special sink that distributes data to other sinks. It pushes data
 // only to these sinks that completed their previous exports. Data that could not be
-// pushed in the defined time is dropped and not retried.
+// pushed in the defined time is dropped and not retried. Events that a
+// core.ReportingEventSink fails to export are retried following the retry policy,
+// in between the next batches so that waiting for a retry does not hold them up.
 type sinkManager struct {
 	sinkHolders         []sinkHolder
 	exportEventsTimeout time.Duration
@@ -61,7 +65,7 @@ type sinkManager struct {
 	stopTimeout time.Duration
 }
 
-func NewEventSinkManager(sinks []core.EventSink, exportEventsTimeout, stopTimeout time.Duration) (core.EventSink, error) {
+func NewEventSinkManager(sinks []core.EventSink, exportEventsTimeout, stopTimeout time.Duration, retryPolicy RetryPolicy) (core.EventSink, error) {
 	sinkHolders := []sinkHolder{}
 	for _, sink := range sinks {
 		sh := sinkHolder{
@@ -71,13 +75,17 @@ func NewEventSinkManager(sinks []core.EventSink, exportEventsTimeout, stopTimeou
 		}
 		sinkHolders = append(sinkHolders, sh)
 		go func(sh sinkHolder) {
+			retries := newRetryQueue(sh.sink, retryPolicy, DefaultRetryQueueSize)
 			for {
 				select {
 				case data := <-sh.eventBatchChannel:
-					export(sh.sink, data)
+					retries.export(data)
+				case <-retries.due():
+					retries.retryDue()
 				case isStop := <-sh.stopChannel:
 					klog.V(2).Infof("Stop received: %s", sh.sink.Name())
 					if isStop {
+						retries.drop()
 						sh.sink.Stop()
 						return
 					}
@@ -136,11 +144,12 @@ func (this *sinkManager) Stop() {
 }
 
 func export(s core.EventSink, data *core.EventBatch) {
-	startTime := time.Now()
-	defer func() {
-		exporterDuration.
-			WithLabelValues(s.Name()).
-			Observe(float64(time.Since(startTime)) / float64(time.Millisecond))
-	}()
+	defer observeExportDuration(s, time.Now())
 	s.ExportEvents(data)
 }
+
+func observeExportDuration(s core.EventSink, startTime time.Time) {
+	exporterDuration.
+		WithLabelValues(s.Name()).
+		Observe(float64(time.Since(startTime)) / float64(time.Millisecond))
+}
@@ -15,6 +15,10 @@
 package sinks
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -22,6 +26,7 @@ import (
 	kube_api "k8s.io/api/core/v1"
 
 	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/sinks/spool"
 	"github.com/AliyunContainerService/kube-eventer/util"
 )
 
@@ -45,7 +50,7 @@ func TestAllExportsInTime(t *testing.T) {
 
 	sink1 := util.NewDummySink("s1", time.Second)
 	sink2 := util.NewDummySink("s2", time.Second)
-	manager, _ := NewEventSinkManager([]core.EventSink{sink1, sink2}, timeout, timeout)
+	manager, _ := NewEventSinkManager([]core.EventSink{sink1, sink2}, timeout, timeout, NoRetryPolicy)
 
 	elapsed := doThreeBatches(manager)
 	if elapsed > 2*timeout+2*time.Second {
@@ -61,7 +66,7 @@ func TestOneExportInTime(t *testing.T) {
 
 	sink1 := util.NewDummySink("s1", time.Second)
 	sink2 := util.NewDummySink("s2", 30*time.Second)
-	manager, _ := NewEventSinkManager([]core.EventSink{sink1, sink2}, timeout, timeout)
+	manager, _ := NewEventSinkManager([]core.EventSink{sink1, sink2}, timeout, timeout, NoRetryPolicy)
 
 	elapsed := doThreeBatches(manager)
 	if elapsed > 2*timeout+2*time.Second {
@@ -80,7 +85,7 @@ func TestNoExportInTime(t *testing.T) {
 
 	sink1 := util.NewDummySink("s1", 30*time.Second)
 	sink2 := util.NewDummySink("s2", 30*time.Second)
-	manager, _ := NewEventSinkManager([]core.EventSink{sink1, sink2}, timeout, timeout)
+	manager, _ := NewEventSinkManager([]core.EventSink{sink1, sink2}, timeout, timeout, NoRetryPolicy)
 
 	elapsed := doThreeBatches(manager)
 	if elapsed > 2*timeout+2*time.Second {
@@ -94,12 +99,30 @@ func TestNoExportInTime(t *testing.T) {
 	assert.Equal(t, 1, sink2.GetExportCount())
 }
 
+func TestRetryDoesNotHoldUpNextBatch(t *testing.T) {
+	sink := &flakySink{err: core.NewRetryableError(errors.New("unavailable")), failCount: 1}
+	policy := RetryPolicy{MaxRetries: 1, InitialBackoff: time.Hour}
+	manager, _ := NewEventSinkManager([]core.EventSink{sink}, time.Second, time.Second, policy)
+	defer manager.Stop()
+
+	// the second batch is taken while the first waits for its retry.
+	start := time.Now()
+	manager.ExportEvents(newTestBatch())
+	manager.ExportEvents(newTestBatch())
+	manager.ExportEvents(newTestBatch())
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Eventually(t, func() bool {
+		exports, exported := sink.getExports()
+		return exports == 3 && exported == 4
+	}, time.Second, 10*time.Millisecond)
+}
+
 func TestStop(t *testing.T) {
 	timeout := 4 * time.Second
 
 	sink1 := util.NewDummySink("s1", 30*time.Second)
 	sink2 := util.NewDummySink("s2", 30*time.Second)
-	manager, _ := NewEventSinkManager([]core.EventSink{sink1, sink2}, timeout, timeout)
+	manager, _ := NewEventSinkManager([]core.EventSink{sink1, sink2}, timeout, timeout, NoRetryPolicy)
 
 	now := time.Now()
 	manager.Stop()
@@ -112,3 +135,103 @@ func TestStop(t *testing.T) {
 	assert.Equal(t, true, sink1.IsStopped())
 	assert.Equal(t, true, sink2.IsStopped())
 }
+
+func TestSpooledExportCatchesUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sp, err := spool.Open(dir, 0, 0)
+	assert.NoError(t, err)
+
+	sink1 := util.NewDummySink("s1", 10*time.Millisecond)
+	sink2 := util.NewDummySink("s2", time.Second)
+	manager, err := NewSpooledEventSinkManager([]core.EventSink{sink1, sink2}, sp, time.Second, NoRetryPolicy)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		manager.ExportEvents(&core.EventBatch{
+			Timestamp: time.Now(),
+			Events:    []*kube_api.Event{{Message: "m"}},
+		})
+	}
+	// Writing to the spool does not wait for the sinks.
+	if elapsed := time.Since(now); elapsed > time.Second {
+		t.Fatalf("3xExportEvents took too long: %s", elapsed)
+	}
+
+	// The slow sink falls behind but does not lose any batch.
+	time.Sleep(3500 * time.Millisecond)
+	assert.Equal(t, 3, sink1.GetExportCount())
+	assert.Equal(t, 3, sink2.GetExportCount())
+}
+
+// holdingSink holds the events exported to it until they are released.
+type holdingSink struct {
+	lock    sync.Mutex
+	since   time.Time
+	exports int
+}
+
+func (h *holdingSink) Name() string {
+	return "holding"
+}
+
+func (h *holdingSink) ExportEvents(batch *core.EventBatch) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.exports++
+	if len(batch.Events) > 0 && h.since.IsZero() {
+		h.since = time.Now()
+	}
+}
+
+func (h *holdingSink) Stop() {}
+
+func (h *holdingSink) HeldSince() time.Time {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.since
+}
+
+func (h *holdingSink) release() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.since = time.Time{}
+}
+
+func (h *holdingSink) getExports() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.exports
+}
+
+func TestSpooledExportKeepsHeldEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sp, err := spool.Open(dir, 0, 0)
+	assert.NoError(t, err)
+
+	sink := &holdingSink{}
+	manager, err := NewSpooledEventSinkManager([]core.EventSink{sink}, sp, time.Second, NoRetryPolicy)
+	assert.NoError(t, err)
+	defer manager.Stop()
+	committed := func() bool {
+		reader, err := sp.NewReader(sink.Name())
+		assert.NoError(t, err)
+		data, err := reader.Next()
+		assert.NoError(t, err)
+		return data == nil
+	}
+
+	manager.ExportEvents(&core.EventBatch{Timestamp: time.Now(), Events: []*kube_api.Event{{Message: "m"}}})
+	assert.Eventually(t, func() bool { return sink.getExports() == 1 }, time.Second, 10*time.Millisecond)
+	// the cursor stays before the events the sink holds.
+	assert.False(t, committed())
+
+	// the first export after the sink sent them commits them.
+	sink.release()
+	manager.ExportEvents(&core.EventBatch{Timestamp: time.Now()})
+	assert.Eventually(t, committed, time.Second, 10*time.Millisecond)
+}
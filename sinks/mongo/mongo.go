@@ -16,6 +16,7 @@ package mongo
 
 import (
 	"context"
+	"fmt"
 	"github.com/AliyunContainerService/kube-eventer/core"
 	"github.com/AliyunContainerService/kube-eventer/util"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -34,6 +35,7 @@ type mongoSink struct {
 }
 
 type mongoSinkPoint struct {
+	Cluster                  string    `bson:"cluster,omitempty"`
 	Count                    int32     `bson:"count,omitempty"`
 	Namespace                string    `bson:"namespace,omitempty"`
 	Kind                     string    `bson:"kind,omitempty"`
@@ -77,6 +79,7 @@ func eventToPoint(event *kube_api.Event) (*mongoSinkPoint, error) {
 	}
 
 	point := mongoSinkPoint{
+		Cluster:                  util.GetClusterName(event, ""),
 		Count:                    event.Count,
 		Name:                     event.InvolvedObject.Name,
 		Namespace:                event.InvolvedObject.Namespace,
@@ -92,22 +95,29 @@ func eventToPoint(event *kube_api.Event) (*mongoSinkPoint, error) {
 }
 
 func (m *mongoSink) ExportEvents(eventBatch *core.EventBatch) {
+	for _, failure := range m.ExportEventsWithResult(eventBatch) {
+		klog.Warningf("Failed to export data to Mongo sink: %v", failure.Err)
+	}
+}
+
+func (m *mongoSink) ExportEventsWithResult(eventBatch *core.EventBatch) []core.ExportFailure {
 	m.Lock()
 	defer m.Unlock()
 
+	var failures []core.ExportFailure
 	for _, event := range eventBatch.Events {
 		point, err := eventToPoint(event)
 		if err != nil {
-			klog.Warningf("Failed to convert event to point: %v", err)
+			failures = append(failures, core.NewExportFailure(event, fmt.Errorf("failed to convert event to point: %v", err)))
 			continue
 		}
 
 		err = m.saveData(point)
 		if err != nil {
-			klog.Warningf("Failed to export data to Mongo sink: %v", err)
+			failures = append(failures, core.NewExportFailure(event, core.NewRetryableError(err)))
 		}
 	}
-
+	return failures
 }
 
 func (m *mongoSink) Stop() {
@@ -117,7 +127,8 @@ func (m *mongoSink) Stop() {
 func CreateMongoSink(uri *url.URL) (core.EventSink, error) {
 	var sink mongoSink
 
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri.RawQuery))
 	if err != nil {
@@ -125,7 +136,9 @@ func CreateMongoSink(uri *url.URL) (core.EventSink, error) {
 	}
 	sink.client = client
 	sink.closeDB = func() {
-		if err = client.Disconnect(ctx); err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := client.Disconnect(ctx); err != nil {
 			panic(err)
 		}
 	}
@@ -16,6 +16,7 @@ package mysql
 
 import (
 	"encoding/json"
+	"fmt"
 	mysql_common "github.com/AliyunContainerService/kube-eventer/common/mysql"
 	"github.com/AliyunContainerService/kube-eventer/core"
 	"github.com/AliyunContainerService/kube-eventer/util"
@@ -71,9 +72,10 @@ func eventToPoint(event *kube_api.Event) (*mysql_common.MysqlKubeEventPoint, err
 	if err != nil {
 		return nil, err
 	}
-	klog.V(9).Infof(value)
+	klog.V(9).Info(value)
 
 	point := mysql_common.MysqlKubeEventPoint{
+		Cluster:                  util.GetClusterName(event, ""),
 		Name:                     event.InvolvedObject.Name,
 		Namespace:                event.InvolvedObject.Namespace,
 		EventID:                  string(event.UID),
@@ -90,17 +92,24 @@ func eventToPoint(event *kube_api.Event) (*mysql_common.MysqlKubeEventPoint, err
 }
 
 func (sink *mysqlSink) ExportEvents(eventBatch *core.EventBatch) {
+	for _, failure := range sink.ExportEventsWithResult(eventBatch) {
+		klog.Warningf("Failed to export data to Mysql sink: %v", failure.Err)
+	}
+}
+
+func (sink *mysqlSink) ExportEventsWithResult(eventBatch *core.EventBatch) []core.ExportFailure {
 
 	sink.Lock()
 	defer sink.Unlock()
 
+	var failures []core.ExportFailure
 	dataPoints := make([]mysql_common.MysqlKubeEventPoint, 0, 10)
 	for _, event := range eventBatch.Events {
 
 		point, err := eventToPoint(event)
 		if err != nil {
-			klog.Warningf("Failed to convert event to point: %v", err)
 			klog.Warningf("Skip this event")
+			failures = append(failures, core.NewExportFailure(event, fmt.Errorf("failed to convert event to point: %v", err)))
 			continue
 		}
 
@@ -108,13 +117,14 @@ func (sink *mysqlSink) ExportEvents(eventBatch *core.EventBatch) {
 		if len(dataPoints) >= maxSendBatchSize {
 			err = sink.saveData([]interface{}{*point})
 			if err != nil {
-				klog.Warningf("Failed to export data to Mysql sink: %v", err)
+				failures = append(failures, core.NewExportFailure(event, core.NewRetryableError(err)))
 			}
 			dataPoints = make([]mysql_common.MysqlKubeEventPoint, 0, 1)
 		}
 
 	}
-	klog.V(1).Infof("sinking %v events to mysql success.", len(eventBatch.Events))
+	klog.V(1).Infof("sinking %v events to mysql, %v failed.", len(eventBatch.Events), len(failures))
+	return failures
 }
 
 func (sink *mysqlSink) Name() string {
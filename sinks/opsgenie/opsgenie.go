@@ -0,0 +1,270 @@
+package opsgenie
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	metrics "github.com/AliyunContainerService/kube-eventer/metrics/prometheus"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	SinkName = "OpsgenieSink"
+
+	defaultEndpoint = "https://api.opsgenie.com/v2/alerts"
+	alertsPath      = "/v2/alerts"
+	defaultPriority = "P3"
+
+	// Opsgenie cuts longer messages and rejects longer aliases.
+	maxMessageLength     = 130
+	maxAliasLength       = 512
+	maxDescriptionLength = 15000
+)
+
+// Alert is the request to create an alert.
+type Alert struct {
+	Message     string            `json:"message"`
+	Alias       string            `json:"alias"`
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+	Entity      string            `json:"entity,omitempty"`
+	Source      string            `json:"source,omitempty"`
+	Priority    string            `json:"priority"`
+}
+
+// PriorityRule sets the priority of the events with the reason and kind, an empty reason or kind matches all.
+type PriorityRule struct {
+	Priority string
+	Reason   string
+	Kind     string
+}
+
+func (r *PriorityRule) Match(event *v1.Event) bool {
+	return (r.Reason == "" || r.Reason == event.Reason) && (r.Kind == "" || r.Kind == event.InvolvedObject.Kind)
+}
+
+/*
+opsgenie sink usage
+--sink=opsgenie:?api_key=<api key>&level=Warning&priority=P1:kind=Node,reason=NodeNotReady&label=team,infra
+
+api_key: key of an API integration.
+level: Normal or Warning. The event level greater than global level will emit.
+label: <key>,<value>, added to the alerts as a <key>:<value> tag.
+priority: <P1-P5>:<reason=<reason>,kind=<kind>>, priority of the events with the reason and kind. The first rule matching wins.
+default_priority: priority of the events no rule matches (default P3).
+*/
+type OpsgenieSink struct {
+	endpoint        string
+	apiKey          string
+	level           int
+	tags            []string
+	priorities      []PriorityRule
+	defaultPriority string
+	client          *http.Client
+}
+
+func (o *OpsgenieSink) Name() string {
+	return SinkName
+}
+
+func (o *OpsgenieSink) Stop() {
+	// do nothing
+}
+
+func (o *OpsgenieSink) ExportEvents(batch *core.EventBatch) {
+	for _, failure := range o.ExportEventsWithResult(batch) {
+		klog.Errorf("failed to send event to opsgenie, because of %v", failure.Err)
+	}
+}
+
+func (o *OpsgenieSink) ExportEventsWithResult(batch *core.EventBatch) []core.ExportFailure {
+	var failures []core.ExportFailure
+	for _, event := range batch.Events {
+		if util.GetLevel(event.Type) < o.level {
+			continue
+		}
+		if err := o.send(o.createAlertFromEvent(event)); err != nil {
+			failures = append(failures, core.NewExportFailure(event, err))
+		}
+	}
+	return failures
+}
+
+func (o *OpsgenieSink) createAlertFromEvent(event *v1.Event) *Alert {
+	namespace := event.InvolvedObject.Namespace
+	if namespace == "" {
+		namespace = event.Namespace
+	}
+	details := map[string]string{
+		"kind":   event.InvolvedObject.Kind,
+		"name":   event.InvolvedObject.Name,
+		"reason": event.Reason,
+		"type":   event.Type,
+	}
+	if namespace != "" {
+		details["namespace"] = namespace
+	}
+	if event.Count > 1 {
+		details["count"] = strconv.Itoa(int(event.Count))
+	}
+	if cluster := util.GetClusterName(event, ""); cluster != "" {
+		details["cluster"] = cluster
+	}
+	if event.Source.Host != "" {
+		details["node"] = event.Source.Host
+	}
+	if abnormal, ok := metrics.TriageEvent(event); ok {
+		details["abnormal_reason"] = string(abnormal)
+	}
+	details["timestamp"] = util.GetLastEventTimestamp(event).UTC().Format(time.RFC3339)
+
+	return &Alert{
+		Message:     truncate(fmt.Sprintf("%s %s/%s: %s", event.InvolvedObject.Kind, namespace, event.InvolvedObject.Name, event.Reason), maxMessageLength),
+		Alias:       alias(event, namespace),
+		Description: truncate(event.Message, maxDescriptionLength),
+		Tags:        o.tags,
+		Details:     details,
+		Entity:      fmt.Sprintf("%s/%s", event.InvolvedObject.Kind, event.InvolvedObject.Name),
+		Source:      "kube-eventer",
+		Priority:    o.priority(event),
+	}
+}
+
+func (o *OpsgenieSink) priority(event *v1.Event) string {
+	for _, rule := range o.priorities {
+		if rule.Match(event) {
+			return rule.Priority
+		}
+	}
+	return o.defaultPriority
+}
+
+// alias identifies the alert of an object and reason, Opsgenie counts repeats of an open alert instead of creating new ones.
+func alias(event *v1.Event, namespace string) string {
+	alias := strings.Join([]string{util.GetClusterName(event, ""), namespace, event.InvolvedObject.Kind, event.InvolvedObject.Name, event.Reason}, "/")
+	if len(alias) > maxAliasLength {
+		sum := sha256.Sum256([]byte(alias))
+		return hex.EncodeToString(sum[:])
+	}
+	return alias
+}
+
+func truncate(text string, length int) string {
+	if runes := []rune(text); len(runes) > length {
+		return string(runes[:length-3]) + "..."
+	}
+	return text
+}
+
+func (o *OpsgenieSink) send(alert *Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert %v: %v", alert, err)
+	}
+	req, err := http.NewRequest(http.MethodPost, o.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request to opsgenie: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "GenieKey "+o.apiKey)
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return core.NewRetryableError(fmt.Errorf("failed to send alert to opsgenie: %v", err))
+	}
+	defer resp.Body.Close()
+	// alerts are created asynchronously, the request is answered with 202.
+	return util.CheckResponse(resp, "send alert to opsgenie")
+}
+
+// parsePriorityRule parses <P1-P5>:reason=<reason>,kind=<kind>.
+func parsePriorityRule(value string) (PriorityRule, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || !validPriority(parts[0]) {
+		return PriorityRule{}, fmt.Errorf("priority must look like <P1-P5>:reason=<reason>,kind=<kind>, got %q", value)
+	}
+	rule := PriorityRule{Priority: parts[0]}
+	for _, condition := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(condition, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return PriorityRule{}, fmt.Errorf("priority must look like <P1-P5>:reason=<reason>,kind=<kind>, got %q", value)
+		}
+		switch kv[0] {
+		case "reason":
+			rule.Reason = kv[1]
+		case "kind":
+			rule.Kind = kv[1]
+		default:
+			return PriorityRule{}, fmt.Errorf("priority rules match reason or kind, got %q", value)
+		}
+	}
+	return rule, nil
+}
+
+func validPriority(priority string) bool {
+	switch priority {
+	case "P1", "P2", "P3", "P4", "P5":
+		return true
+	}
+	return false
+}
+
+func NewOpsgenieSink(uri *url.URL) (*OpsgenieSink, error) {
+	o := &OpsgenieSink{
+		endpoint:        defaultEndpoint,
+		level:           util.GetLevel(v1.EventTypeWarning),
+		defaultPriority: defaultPriority,
+		client:          &http.Client{Timeout: 10 * time.Second},
+	}
+	// the endpoint is only set for a proxy or an EU account.
+	if uri.Host != "" {
+		path := strings.TrimSuffix(uri.Path, "/")
+		if path == "" {
+			path = alertsPath
+		}
+		o.endpoint = (&url.URL{Scheme: uri.Scheme, Host: uri.Host, Path: path}).String()
+	}
+	opts := uri.Query()
+
+	if len(opts["api_key"]) < 1 || opts["api_key"][0] == "" {
+		return nil, fmt.Errorf("you must provide the api_key of the opsgenie integration")
+	}
+	o.apiKey = opts["api_key"][0]
+	if len(opts["level"]) >= 1 {
+		o.level = util.GetLevel(opts["level"][0])
+	}
+	if len(opts["label"]) >= 1 {
+		for key, value := range util.ParseLabels(opts["label"]) {
+			o.tags = append(o.tags, key+":"+value)
+		}
+		sort.Strings(o.tags)
+	}
+	for _, priority := range opts["priority"] {
+		rule, err := parsePriorityRule(priority)
+		if err != nil {
+			return nil, err
+		}
+		o.priorities = append(o.priorities, rule)
+	}
+	if len(opts["default_priority"]) >= 1 {
+		if !validPriority(opts["default_priority"][0]) {
+			return nil, fmt.Errorf("default_priority must be one of P1 to P5, got %q", opts["default_priority"][0])
+		}
+		o.defaultPriority = opts["default_priority"][0]
+	}
+
+	return o, nil
+}
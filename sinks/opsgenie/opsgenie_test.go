@@ -0,0 +1,100 @@
+package opsgenie
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestNewOpsgenieSink(t *testing.T) {
+	uri, _ := url.Parse("?api_key=abc&level=Normal&label=team,infra&label=env,prod&priority=P1:kind=Node,reason=NodeNotReady&priority=P2:reason=BackOff&default_priority=P4")
+	o, err := NewOpsgenieSink(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultEndpoint, o.endpoint)
+	assert.Equal(t, "abc", o.apiKey)
+	assert.Equal(t, 1, o.level)
+	assert.Equal(t, []string{"env:prod", "team:infra"}, o.tags)
+	assert.Equal(t, []PriorityRule{
+		{Priority: "P1", Reason: "NodeNotReady", Kind: "Node"},
+		{Priority: "P2", Reason: "BackOff"},
+	}, o.priorities)
+	assert.Equal(t, "P4", o.defaultPriority)
+
+	uri, _ = url.Parse("https://api.eu.opsgenie.com?api_key=abc")
+	o, err = NewOpsgenieSink(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://api.eu.opsgenie.com/v2/alerts", o.endpoint)
+
+	for _, invalid := range []string{
+		"level=Warning",
+		"api_key=abc&priority=P6:reason=BackOff",
+		"api_key=abc&priority=P1",
+		"api_key=abc&priority=P1:namespace=default",
+		"api_key=abc&default_priority=high",
+	} {
+		uri, _ = url.Parse("?" + invalid)
+		_, err = NewOpsgenieSink(uri)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCreateAlertFromEvent(t *testing.T) {
+	uri, _ := url.Parse("?api_key=abc&label=team,infra&priority=P1:kind=Node&priority=P2:reason=BackOff")
+	o, _ := NewOpsgenieSink(uri)
+
+	alert := o.createAlertFromEvent(util.NewDummyEvent(v1.EventTypeWarning, "Pod", "nginx", "BackOff"))
+	assert.Equal(t, "Pod default/nginx: BackOff", alert.Message)
+	assert.Equal(t, "prod/default/Pod/nginx/BackOff", alert.Alias)
+	assert.Equal(t, "Back-off restarting failed container", alert.Description)
+	assert.Equal(t, []string{"team:infra"}, alert.Tags)
+	assert.Equal(t, "Pod/nginx", alert.Entity)
+	assert.Equal(t, "P2", alert.Priority)
+	assert.Equal(t, "3", alert.Details["count"])
+	assert.Equal(t, "node-1", alert.Details["node"])
+
+	assert.Equal(t, "P1", o.createAlertFromEvent(util.NewDummyEvent(v1.EventTypeWarning, "Node", "nginx", "BackOff")).Priority)
+	assert.Equal(t, defaultPriority, o.createAlertFromEvent(util.NewDummyEvent(v1.EventTypeWarning, "Pod", "nginx", "Unhealthy")).Priority)
+
+	event := util.NewDummyEvent(v1.EventTypeWarning, "Pod", "nginx", "BackOff")
+	event.InvolvedObject.Name = strings.Repeat("n", 600)
+	alert = o.createAlertFromEvent(event)
+	assert.Len(t, alert.Alias, 64)
+	assert.Equal(t, maxMessageLength, len([]rune(alert.Message)))
+}
+
+func TestExportEvents(t *testing.T) {
+	var alerts []Alert
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, alertsPath, r.URL.Path)
+		authorization = r.Header.Get("Authorization")
+		body, _ := ioutil.ReadAll(r.Body)
+		var alert Alert
+		assert.NoError(t, json.Unmarshal(body, &alert))
+		alerts = append(alerts, alert)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	uri, _ := url.Parse(server.URL + "?api_key=abc")
+	o, err := NewOpsgenieSink(uri)
+	assert.NoError(t, err)
+
+	batch := &core.EventBatch{Events: []*v1.Event{
+		util.NewDummyEvent(v1.EventTypeWarning, "Pod", "nginx", "BackOff"),
+		util.NewDummyEvent(v1.EventTypeNormal, "Pod", "nginx", "Pulled"),
+	}}
+	assert.Empty(t, o.ExportEventsWithResult(batch))
+	assert.Equal(t, "GenieKey abc", authorization)
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, "BackOff", alerts[0].Details["reason"])
+}
@@ -0,0 +1,253 @@
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	"google.golang.org/protobuf/encoding/protowire"
+	"k8s.io/klog/v2"
+)
+
+// The OTLP/gRPC transport: the protobuf encoding of an ExportLogsServiceRequest sent to the
+// Export method of the LogsService, framed as a gRPC message over HTTP/2.
+
+const grpcExportPath = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+
+// gRPC status codes the OTLP spec lets a client retry: CANCELLED, DEADLINE_EXCEEDED,
+// RESOURCE_EXHAUSTED, ABORTED, OUT_OF_RANGE, UNAVAILABLE and DATA_LOSS.
+var retryableGRPCCodes = map[int]bool{1: true, 4: true, 8: true, 10: true, 11: true, 14: true, 15: true}
+
+// newGRPCClient returns a client that speaks HTTP/2 with prior knowledge to http endpoints and
+// negotiates it with https endpoints, as gRPC needs HTTP/2.
+func newGRPCClient() *http.Client {
+	protocols := &http.Protocols{}
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Protocols = protocols
+	return &http.Client{Transport: transport, Timeout: defaultTimeout}
+}
+
+func (o *OTLPSink) sendGRPC(request *ExportLogsServiceRequest) error {
+	message := request.marshalProto()
+	compressed := byte(0)
+	if o.gzip {
+		var err error
+		if message, err = compress(message); err != nil {
+			return err
+		}
+		compressed = 1
+	}
+	// a length-prefixed message: the compressed flag and the big endian length of the message.
+	frame := make([]byte, 5, 5+len(message))
+	frame[0] = compressed
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	frame = append(frame, message...)
+
+	req, err := http.NewRequest(http.MethodPost, o.endpoint, bytes.NewReader(frame))
+	if err != nil {
+		return fmt.Errorf("failed to create request to otlp endpoint: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if o.gzip {
+		req.Header.Set("grpc-encoding", "gzip")
+	}
+	for key, value := range o.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return core.NewRetryableError(fmt.Errorf("failed to send logs to otlp endpoint: %v", err))
+	}
+	defer resp.Body.Close()
+	if err := util.CheckResponse(resp, "send logs to otlp endpoint"); err != nil {
+		return err
+	}
+	// the trailers are only there once the body is read.
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return core.NewRetryableError(fmt.Errorf("failed to read the response of otlp endpoint: %v", err))
+	}
+	if err := grpcStatus(resp); err != nil {
+		return err
+	}
+
+	// records rejected by a partial success are not retried, as the spec asks.
+	if len(respBody) < 5 || respBody[0] != 0 {
+		return nil
+	}
+	rejected, errorMessage, err := unmarshalPartialSuccess(respBody[5:])
+	if err != nil {
+		klog.Warningf("failed to decode the response of otlp endpoint: %v", err)
+	} else if rejected > 0 {
+		klog.Warningf("otlp endpoint rejected %d log records: %s", rejected, errorMessage)
+	}
+	return nil
+}
+
+// grpcStatus returns the error of the grpc-status of a response, which is in the trailers, or in
+// the headers of a response without a body.
+func grpcStatus(resp *http.Response) error {
+	status, message := resp.Trailer.Get("grpc-status"), resp.Trailer.Get("grpc-message")
+	if status == "" {
+		status, message = resp.Header.Get("grpc-status"), resp.Header.Get("grpc-message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return fmt.Errorf("failed to send logs to otlp endpoint, because the response has no grpc status")
+	}
+	if code == 0 {
+		return nil
+	}
+	if unescaped, err := url.PathUnescape(message); err == nil {
+		message = unescaped
+	}
+	err = fmt.Errorf("failed to send logs to otlp endpoint, because the grpc status is %d, message is: %s", code, message)
+	if retryableGRPCCodes[code] {
+		return core.NewRetryableError(err)
+	}
+	return err
+}
+
+func compress(body []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(body); err != nil {
+		return nil, fmt.Errorf("failed to compress otlp logs: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress otlp logs: %v", err)
+	}
+	return compressed.Bytes(), nil
+}
+
+// The protobuf encoding of the messages, with the field numbers of opentelemetry-proto.
+
+func (r *ExportLogsServiceRequest) marshalProto() []byte {
+	var b []byte
+	for _, resourceLogs := range r.ResourceLogs {
+		b = appendMessage(b, 1, resourceLogs.marshalProto())
+	}
+	return b
+}
+
+func (r *ResourceLogs) marshalProto() []byte {
+	var resource []byte
+	for _, attribute := range r.Resource.Attributes {
+		resource = appendMessage(resource, 1, attribute.marshalProto())
+	}
+	b := appendMessage(nil, 1, resource)
+	for _, scopeLogs := range r.ScopeLogs {
+		b = appendMessage(b, 2, scopeLogs.marshalProto())
+	}
+	return b
+}
+
+func (s *ScopeLogs) marshalProto() []byte {
+	b := appendMessage(nil, 1, appendString(nil, 1, s.Scope.Name))
+	for _, record := range s.LogRecords {
+		b = appendMessage(b, 2, record.marshalProto())
+	}
+	return b
+}
+
+func (l *LogRecord) marshalProto() []byte {
+	b := appendFixed64(nil, 1, l.TimeUnixNano)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(l.SeverityNumber))
+	b = appendString(b, 3, l.SeverityText)
+	b = appendMessage(b, 5, l.Body.marshalProto())
+	for _, attribute := range l.Attributes {
+		b = appendMessage(b, 6, attribute.marshalProto())
+	}
+	return appendFixed64(b, 11, l.ObservedTimeUnixNano)
+}
+
+func (kv *KeyValue) marshalProto() []byte {
+	b := appendString(nil, 1, kv.Key)
+	return appendMessage(b, 2, kv.Value.marshalProto())
+}
+
+func (v *AnyValue) marshalProto() []byte {
+	if v.IntValue != nil {
+		value, _ := strconv.ParseInt(*v.IntValue, 10, 64)
+		b := protowire.AppendTag(nil, 3, protowire.VarintType)
+		return protowire.AppendVarint(b, uint64(value))
+	}
+	if v.StringValue != nil {
+		return appendString(nil, 1, *v.StringValue)
+	}
+	return nil
+}
+
+func appendMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+func appendString(b []byte, num protowire.Number, value string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+// appendFixed64 appends a time of the JSON encoding, which is a string of nanoseconds.
+func appendFixed64(b []byte, num protowire.Number, value string) []byte {
+	nanos, _ := strconv.ParseUint(value, 10, 64)
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, nanos)
+}
+
+// unmarshalPartialSuccess decodes the partial success of an ExportLogsServiceResponse.
+func unmarshalPartialSuccess(b []byte) (rejected int64, errorMessage string, err error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, "", protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num != 1 || typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return 0, "", protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		partialSuccess, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return 0, "", protowire.ParseError(n)
+		}
+		b = b[n:]
+		for len(partialSuccess) > 0 {
+			num, typ, n := protowire.ConsumeTag(partialSuccess)
+			if n < 0 {
+				return 0, "", protowire.ParseError(n)
+			}
+			partialSuccess = partialSuccess[n:]
+			switch {
+			case num == 1 && typ == protowire.VarintType:
+				var value uint64
+				value, n = protowire.ConsumeVarint(partialSuccess)
+				rejected = int64(value)
+			case num == 2 && typ == protowire.BytesType:
+				errorMessage, n = protowire.ConsumeString(partialSuccess)
+			default:
+				n = protowire.ConsumeFieldValue(num, typ, partialSuccess)
+			}
+			if n < 0 {
+				return 0, "", protowire.ParseError(n)
+			}
+			partialSuccess = partialSuccess[n:]
+		}
+	}
+	return rejected, errorMessage, nil
+}
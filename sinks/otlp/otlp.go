@@ -0,0 +1,367 @@
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	SinkName = "OTLPSink"
+
+	logsPath            = "/v1/logs"
+	scopeName           = "kube-eventer"
+	defaultMaxBatchSize = 512
+	defaultTimeout      = 10 * time.Second
+
+	protocolHTTPJSON = "http/json"
+	protocolGRPC     = "grpc"
+
+	// severity numbers of the log data model.
+	severityNumberInfo = 9
+	severityNumberWarn = 13
+)
+
+// The OTLP/HTTP JSON encoding of an ExportLogsServiceRequest.
+
+type ExportLogsServiceRequest struct {
+	ResourceLogs []*ResourceLogs `json:"resourceLogs"`
+}
+
+type ResourceLogs struct {
+	Resource  Resource     `json:"resource"`
+	ScopeLogs []*ScopeLogs `json:"scopeLogs"`
+}
+
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+type ScopeLogs struct {
+	Scope      Scope        `json:"scope"`
+	LogRecords []*LogRecord `json:"logRecords"`
+}
+
+type Scope struct {
+	Name string `json:"name"`
+}
+
+type LogRecord struct {
+	// 64 bit integers are strings in the JSON encoding.
+	TimeUnixNano         string     `json:"timeUnixNano"`
+	ObservedTimeUnixNano string     `json:"observedTimeUnixNano"`
+	SeverityNumber       int        `json:"severityNumber"`
+	SeverityText         string     `json:"severityText"`
+	Body                 AnyValue   `json:"body"`
+	Attributes           []KeyValue `json:"attributes"`
+}
+
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+type AnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type ExportLogsServiceResponse struct {
+	PartialSuccess *struct {
+		RejectedLogRecords string `json:"rejectedLogRecords"`
+		ErrorMessage       string `json:"errorMessage"`
+	} `json:"partialSuccess"`
+}
+
+func stringValue(value string) AnyValue {
+	return AnyValue{StringValue: &value}
+}
+
+func intValue(value int64) AnyValue {
+	s := strconv.FormatInt(value, 10)
+	return AnyValue{IntValue: &s}
+}
+
+// Resource attributes of the semantic conventions for the kinds of involved objects. The node is
+// the node the event was reported on, or the involved object itself.
+var kindAttributes = map[string]string{
+	"Pod":         "k8s.pod.name",
+	"Deployment":  "k8s.deployment.name",
+	"ReplicaSet":  "k8s.replicaset.name",
+	"StatefulSet": "k8s.statefulset.name",
+	"DaemonSet":   "k8s.daemonset.name",
+	"Job":         "k8s.job.name",
+	"CronJob":     "k8s.cronjob.name",
+}
+
+/*
+otlp sink usage
+--sink=otlp:http://otel-collector:4318?compression=gzip&header=Authorization=Bearer%20<token>
+
+The events are sent as OTLP/HTTP JSON to the /v1/logs path of the endpoint, unless it has a path,
+or with OTLP/gRPC to the Export method of the LogsService.
+cluster_name: k8s.cluster.name of the events without a cluster name.
+compression: gzip or none (default none).
+header: <key>=<value>, header added to the requests, repeat it for more.
+max_batch_size: log records per request (default 512).
+protocol: http/json or grpc (default http/json).
+*/
+type OTLPSink struct {
+	endpoint     string
+	clusterName  string
+	grpc         bool
+	gzip         bool
+	headers      map[string]string
+	maxBatchSize int
+	client       *http.Client
+	now          func() time.Time
+}
+
+func (o *OTLPSink) Name() string {
+	return SinkName
+}
+
+func (o *OTLPSink) Stop() {
+	// do nothing
+}
+
+func (o *OTLPSink) ExportEvents(batch *core.EventBatch) {
+	for _, failure := range o.ExportEventsWithResult(batch) {
+		klog.Errorf("failed to send event to otlp, because of %v", failure.Err)
+	}
+}
+
+func (o *OTLPSink) ExportEventsWithResult(batch *core.EventBatch) []core.ExportFailure {
+	var failures []core.ExportFailure
+	for start := 0; start < len(batch.Events); start += o.maxBatchSize {
+		end := start + o.maxBatchSize
+		if end > len(batch.Events) {
+			end = len(batch.Events)
+		}
+		events := batch.Events[start:end]
+		if err := o.send(o.createRequest(events)); err != nil {
+			for _, event := range events {
+				failures = append(failures, core.NewExportFailure(event, err))
+			}
+		}
+	}
+	return failures
+}
+
+// createRequest groups the log records of the events by the resource of their involved object.
+func (o *OTLPSink) createRequest(events []*v1.Event) *ExportLogsServiceRequest {
+	request := &ExportLogsServiceRequest{}
+	resources := map[string]*ScopeLogs{}
+	observed := strconv.FormatInt(o.now().UnixNano(), 10)
+	for _, event := range events {
+		attributes := o.resourceAttributes(event)
+		key := resourceKey(attributes)
+		scopeLogs, found := resources[key]
+		if !found {
+			scopeLogs = &ScopeLogs{Scope: Scope{Name: scopeName}}
+			resources[key] = scopeLogs
+			request.ResourceLogs = append(request.ResourceLogs, &ResourceLogs{
+				Resource:  Resource{Attributes: attributes},
+				ScopeLogs: []*ScopeLogs{scopeLogs},
+			})
+		}
+		record := createLogRecord(event)
+		record.ObservedTimeUnixNano = observed
+		scopeLogs.LogRecords = append(scopeLogs.LogRecords, record)
+	}
+	return request
+}
+
+func (o *OTLPSink) resourceAttributes(event *v1.Event) []KeyValue {
+	var attributes []KeyValue
+	add := func(key, value string) {
+		if value != "" {
+			attributes = append(attributes, KeyValue{Key: key, Value: stringValue(value)})
+		}
+	}
+	add("k8s.cluster.name", util.GetClusterName(event, o.clusterName))
+	namespace := event.InvolvedObject.Namespace
+	if namespace == "" && event.InvolvedObject.Kind != "Node" {
+		namespace = event.Namespace
+	}
+	add("k8s.namespace.name", namespace)
+	if key, found := kindAttributes[event.InvolvedObject.Kind]; found {
+		add(key, event.InvolvedObject.Name)
+		if event.InvolvedObject.Kind == "Pod" {
+			add("k8s.pod.uid", string(event.InvolvedObject.UID))
+		}
+	}
+	node := event.Source.Host
+	if event.InvolvedObject.Kind == "Node" {
+		node = event.InvolvedObject.Name
+	}
+	add("k8s.node.name", node)
+	return attributes
+}
+
+func resourceKey(attributes []KeyValue) string {
+	var key strings.Builder
+	for _, attribute := range attributes {
+		key.WriteString(attribute.Key)
+		key.WriteByte('=')
+		key.WriteString(*attribute.Value.StringValue)
+		key.WriteByte(0)
+	}
+	return key.String()
+}
+
+// createLogRecord maps an event to a log record with the attributes of the k8s events receiver of the collector.
+func createLogRecord(event *v1.Event) *LogRecord {
+	severityNumber := severityNumberInfo
+	if event.Type == v1.EventTypeWarning {
+		severityNumber = severityNumberWarn
+	}
+	record := &LogRecord{
+		TimeUnixNano:   strconv.FormatInt(util.GetLastEventTimestamp(event).UnixNano(), 10),
+		SeverityNumber: severityNumber,
+		SeverityText:   event.Type,
+		Body:           stringValue(event.Message),
+	}
+	add := func(key, value string) {
+		if value != "" {
+			record.Attributes = append(record.Attributes, KeyValue{Key: key, Value: stringValue(value)})
+		}
+	}
+	add("k8s.event.reason", event.Reason)
+	add("k8s.event.action", event.Action)
+	add("k8s.event.name", event.Name)
+	add("k8s.event.uid", string(event.UID))
+	if !event.FirstTimestamp.IsZero() {
+		add("k8s.event.start_time", event.FirstTimestamp.UTC().Format(time.RFC3339))
+	}
+	if event.Count > 0 {
+		record.Attributes = append(record.Attributes, KeyValue{Key: "k8s.event.count", Value: intValue(int64(event.Count))})
+	}
+	add("k8s.object.kind", event.InvolvedObject.Kind)
+	add("k8s.object.name", event.InvolvedObject.Name)
+	add("k8s.object.uid", string(event.InvolvedObject.UID))
+	add("k8s.object.api_version", event.InvolvedObject.APIVersion)
+	add("k8s.object.fieldpath", event.InvolvedObject.FieldPath)
+	add("k8s.object.resource_version", event.InvolvedObject.ResourceVersion)
+	add("k8s.event.source.component", event.Source.Component)
+	return record
+}
+
+func (o *OTLPSink) send(request *ExportLogsServiceRequest) error {
+	if o.grpc {
+		return o.sendGRPC(request)
+	}
+	return o.sendJSON(request)
+}
+
+func (o *OTLPSink) sendJSON(request *ExportLogsServiceRequest) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal otlp logs: %v", err)
+	}
+	if o.gzip {
+		if body, err = compress(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, o.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request to otlp endpoint: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for key, value := range o.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return core.NewRetryableError(fmt.Errorf("failed to send logs to otlp endpoint: %v", err))
+	}
+	defer resp.Body.Close()
+	if err := util.CheckResponse(resp, "send logs to otlp endpoint"); err != nil {
+		return err
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	// records rejected by a partial success are not retried, as the spec asks.
+	var response ExportLogsServiceResponse
+	if json.Unmarshal(respBody, &response) == nil && response.PartialSuccess != nil && response.PartialSuccess.RejectedLogRecords != "" && response.PartialSuccess.RejectedLogRecords != "0" {
+		klog.Warningf("otlp endpoint rejected %s log records: %s", response.PartialSuccess.RejectedLogRecords, response.PartialSuccess.ErrorMessage)
+	}
+	return nil
+}
+
+func NewOTLPSink(uri *url.URL) (*OTLPSink, error) {
+	if uri.Host == "" {
+		return nil, fmt.Errorf("you must provide the otlp endpoint, such as http://otel-collector:4318")
+	}
+	o := &OTLPSink{
+		headers:      map[string]string{},
+		maxBatchSize: defaultMaxBatchSize,
+		now:          time.Now,
+	}
+	opts := uri.Query()
+
+	if len(opts["protocol"]) >= 1 {
+		switch opts["protocol"][0] {
+		case protocolGRPC:
+			o.grpc = true
+		case protocolHTTPJSON, "":
+		default:
+			return nil, fmt.Errorf("protocol must be %s or %s, got %q", protocolHTTPJSON, protocolGRPC, opts["protocol"][0])
+		}
+	}
+	if o.grpc {
+		o.endpoint = (&url.URL{Scheme: uri.Scheme, Host: uri.Host, Path: grpcExportPath}).String()
+		o.client = newGRPCClient()
+	} else {
+		path := uri.Path
+		if path == "" || path == "/" {
+			path = logsPath
+		}
+		o.endpoint = (&url.URL{Scheme: uri.Scheme, Host: uri.Host, Path: path}).String()
+		o.client = &http.Client{Timeout: defaultTimeout}
+	}
+
+	if len(opts["cluster_name"]) >= 1 {
+		o.clusterName = opts["cluster_name"][0]
+	}
+	if len(opts["compression"]) >= 1 {
+		switch opts["compression"][0] {
+		case "gzip":
+			o.gzip = true
+		case "none", "":
+		default:
+			return nil, fmt.Errorf("compression must be gzip or none, got %q", opts["compression"][0])
+		}
+	}
+	for _, header := range opts["header"] {
+		parts := strings.SplitN(header, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("header must look like <key>=<value>, got %q", header)
+		}
+		o.headers[parts[0]] = parts[1]
+	}
+	if len(opts["max_batch_size"]) >= 1 {
+		size, err := strconv.Atoi(opts["max_batch_size"][0])
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("max_batch_size must be a positive number, got %q", opts["max_batch_size"][0])
+		}
+		o.maxBatchSize = size
+	}
+
+	return o, nil
+}
@@ -0,0 +1,320 @@
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+	v1 "k8s.io/api/core/v1"
+)
+
+var lastSeen = time.Date(2024, 1, 1, 10, 5, 0, 0, time.UTC)
+
+func newEvent(eventType, kind, name string) *v1.Event {
+	event := util.NewDummyEvent(eventType, kind, name, "BackOff")
+	event.InvolvedObject.UID = "uid-1"
+	return event
+}
+
+func attributes(keyValues []KeyValue) map[string]string {
+	values := map[string]string{}
+	for _, kv := range keyValues {
+		if kv.Value.StringValue != nil {
+			values[kv.Key] = *kv.Value.StringValue
+		} else {
+			values[kv.Key] = *kv.Value.IntValue
+		}
+	}
+	return values
+}
+
+// collector is a stand-in for the OTLP/HTTP receiver of a collector.
+type collector struct {
+	*httptest.Server
+	lock     sync.Mutex
+	status   int
+	requests []ExportLogsServiceRequest
+	headers  []http.Header
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{status: http.StatusOK}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, logsPath, r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			assert.NoError(t, err)
+			body = gz
+		}
+		var request ExportLogsServiceRequest
+		assert.NoError(t, json.NewDecoder(body).Decode(&request))
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		c.requests = append(c.requests, request)
+		c.headers = append(c.headers, r.Header)
+		w.WriteHeader(c.status)
+		w.Write([]byte(`{}`))
+	}))
+	return c
+}
+
+func TestNewOTLPSink(t *testing.T) {
+	uri, _ := url.Parse("http://otel-collector:4318?cluster_name=prod&compression=gzip&header=Authorization=Bearer%20abc&max_batch_size=100")
+	o, err := NewOTLPSink(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://otel-collector:4318/v1/logs", o.endpoint)
+	assert.Equal(t, "prod", o.clusterName)
+	assert.True(t, o.gzip)
+	assert.Equal(t, map[string]string{"Authorization": "Bearer abc"}, o.headers)
+	assert.Equal(t, 100, o.maxBatchSize)
+
+	uri, _ = url.Parse("https://logs.example.com/otlp/v1/logs")
+	o, err = NewOTLPSink(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://logs.example.com/otlp/v1/logs", o.endpoint)
+
+	for _, invalid := range []string{"compression=zstd", "header=Authorization", "max_batch_size=0", "protocol=http/protobuf"} {
+		uri, _ = url.Parse("http://otel-collector:4318?" + invalid)
+		_, err = NewOTLPSink(uri)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCreateRequest(t *testing.T) {
+	uri, _ := url.Parse("http://otel-collector:4318?cluster_name=prod")
+	o, _ := NewOTLPSink(uri)
+	o.now = func() time.Time { return lastSeen.Add(time.Second) }
+
+	node := newEvent(v1.EventTypeNormal, "Node", "node-2")
+	node.InvolvedObject.Namespace = ""
+	node.Namespace = "default"
+	request := o.createRequest([]*v1.Event{
+		newEvent(v1.EventTypeWarning, "Pod", "nginx"),
+		newEvent(v1.EventTypeWarning, "Pod", "nginx"),
+		node,
+	})
+
+	assert.Len(t, request.ResourceLogs, 2)
+	pod := request.ResourceLogs[0]
+	assert.Equal(t, map[string]string{
+		"k8s.cluster.name":   "prod",
+		"k8s.namespace.name": "default",
+		"k8s.pod.name":       "nginx",
+		"k8s.pod.uid":        "uid-1",
+		"k8s.node.name":      "node-1",
+	}, attributes(pod.Resource.Attributes))
+	assert.Equal(t, scopeName, pod.ScopeLogs[0].Scope.Name)
+	assert.Len(t, pod.ScopeLogs[0].LogRecords, 2)
+
+	record := pod.ScopeLogs[0].LogRecords[0]
+	assert.Equal(t, "1704103500000000000", record.TimeUnixNano)
+	assert.Equal(t, "1704103501000000000", record.ObservedTimeUnixNano)
+	assert.Equal(t, severityNumberWarn, record.SeverityNumber)
+	assert.Equal(t, "Warning", record.SeverityText)
+	assert.Equal(t, "Back-off restarting failed container", *record.Body.StringValue)
+	recordAttributes := attributes(record.Attributes)
+	assert.Equal(t, "BackOff", recordAttributes["k8s.event.reason"])
+	assert.Equal(t, "3", recordAttributes["k8s.event.count"])
+	assert.Equal(t, "2024-01-01T10:00:00Z", recordAttributes["k8s.event.start_time"])
+	assert.Equal(t, "Pod", recordAttributes["k8s.object.kind"])
+	assert.Equal(t, "nginx.17a", recordAttributes["k8s.event.name"])
+
+	assert.Equal(t, map[string]string{
+		"k8s.cluster.name": "prod",
+		"k8s.node.name":    "node-2",
+	}, attributes(request.ResourceLogs[1].Resource.Attributes))
+	assert.Equal(t, severityNumberInfo, request.ResourceLogs[1].ScopeLogs[0].LogRecords[0].SeverityNumber)
+}
+
+func TestExportEvents(t *testing.T) {
+	c := newCollector(t)
+	defer c.Close()
+
+	uri, _ := url.Parse(c.URL + "?compression=gzip&header=X-Scope-OrgID=team-a&max_batch_size=2")
+	o, err := NewOTLPSink(uri)
+	assert.NoError(t, err)
+
+	batch := &core.EventBatch{Events: []*v1.Event{
+		newEvent(v1.EventTypeWarning, "Pod", "a"),
+		newEvent(v1.EventTypeWarning, "Pod", "b"),
+		newEvent(v1.EventTypeWarning, "Pod", "c"),
+	}}
+	assert.Empty(t, o.ExportEventsWithResult(batch))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	assert.Len(t, c.requests, 2)
+	assert.Len(t, c.requests[0].ResourceLogs, 2)
+	assert.Len(t, c.requests[1].ResourceLogs, 1)
+	assert.Equal(t, "team-a", c.headers[0].Get("X-Scope-OrgID"))
+}
+
+// fields decodes a protobuf message into the values of its fields, bytes of length-delimited
+// fields and numbers of the others.
+func fields(t *testing.T, b []byte) map[protowire.Number][]interface{} {
+	values := map[protowire.Number][]interface{}{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		assert.True(t, n > 0)
+		b = b[n:]
+		var value interface{}
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed64Type:
+			value, n = protowire.ConsumeFixed64(b)
+		default:
+			value, n = protowire.ConsumeVarint(b)
+		}
+		assert.True(t, n > 0)
+		b = b[n:]
+		values[num] = append(values[num], value)
+	}
+	return values
+}
+
+func TestMarshalProto(t *testing.T) {
+	uri, _ := url.Parse("http://otel-collector:4317?protocol=grpc")
+	o, _ := NewOTLPSink(uri)
+	o.now = func() time.Time { return lastSeen.Add(time.Second) }
+	request := o.createRequest([]*v1.Event{newEvent(v1.EventTypeWarning, "Pod", "nginx")})
+
+	resourceLogs := fields(t, request.marshalProto())[1]
+	assert.Len(t, resourceLogs, 1)
+	resourceFields := fields(t, resourceLogs[0].([]byte))
+	attribute := fields(t, fields(t, resourceFields[1][0].([]byte))[1][0].([]byte))
+	assert.Equal(t, "k8s.cluster.name", string(attribute[1][0].([]byte)))
+	assert.Equal(t, "prod", string(fields(t, attribute[2][0].([]byte))[1][0].([]byte)))
+
+	scopeLogs := fields(t, resourceFields[2][0].([]byte))
+	assert.Equal(t, scopeName, string(fields(t, scopeLogs[1][0].([]byte))[1][0].([]byte)))
+	record := fields(t, scopeLogs[2][0].([]byte))
+	assert.Equal(t, uint64(1704103500000000000), record[1][0])
+	assert.Equal(t, uint64(severityNumberWarn), record[2][0])
+	assert.Equal(t, "Warning", string(record[3][0].([]byte)))
+	assert.Equal(t, "Back-off restarting failed container", string(fields(t, record[5][0].([]byte))[1][0].([]byte)))
+	assert.Equal(t, uint64(1704103501000000000), record[11][0])
+	for _, kv := range record[6] {
+		attribute := fields(t, kv.([]byte))
+		if string(attribute[1][0].([]byte)) == "k8s.event.count" {
+			assert.Equal(t, uint64(3), fields(t, attribute[2][0].([]byte))[3][0])
+		}
+	}
+}
+
+// grpcCollector is a stand-in for the OTLP/gRPC receiver of a collector.
+type grpcCollector struct {
+	*httptest.Server
+	lock     sync.Mutex
+	status   string
+	response []byte
+	records  []int
+}
+
+func newGRPCCollector(t *testing.T) *grpcCollector {
+	c := &grpcCollector{status: "0"}
+	c.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, 2, r.ProtoMajor)
+		assert.Equal(t, grpcExportPath, r.URL.Path)
+		assert.Equal(t, "application/grpc", r.Header.Get("Content-Type"))
+		frame, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		message := frame[5:]
+		assert.Equal(t, int(binary.BigEndian.Uint32(frame[1:5])), len(message))
+		if frame[0] == 1 {
+			assert.Equal(t, "gzip", r.Header.Get("grpc-encoding"))
+			gz, err := gzip.NewReader(bytes.NewReader(message))
+			assert.NoError(t, err)
+			message, err = io.ReadAll(gz)
+			assert.NoError(t, err)
+		}
+		records := 0
+		for _, resourceLogs := range fields(t, message)[1] {
+			for _, scopeLogs := range fields(t, resourceLogs.([]byte))[2] {
+				records += len(fields(t, scopeLogs.([]byte))[2])
+			}
+		}
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		c.records = append(c.records, records)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		frame = make([]byte, 5, 5+len(c.response))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(c.response)))
+		w.Write(append(frame, c.response...))
+		w.Header().Set("Grpc-Status", c.status)
+		w.Header().Set("Grpc-Message", "collector%20is%20down")
+	}))
+	c.Config.Protocols = &http.Protocols{}
+	c.Config.Protocols.SetUnencryptedHTTP2(true)
+	c.Start()
+	return c
+}
+
+func TestExportEventsGRPC(t *testing.T) {
+	c := newGRPCCollector(t)
+	defer c.Close()
+
+	uri, _ := url.Parse(c.URL + "?protocol=grpc&compression=gzip&max_batch_size=2")
+	o, err := NewOTLPSink(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, c.URL+grpcExportPath, o.endpoint)
+
+	// a partial success rejecting one record.
+	c.response = appendMessage(nil, 1, append(protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 1),
+		appendString(nil, 2, "too old")...))
+	batch := &core.EventBatch{Events: []*v1.Event{
+		newEvent(v1.EventTypeWarning, "Pod", "a"),
+		newEvent(v1.EventTypeWarning, "Pod", "b"),
+		newEvent(v1.EventTypeWarning, "Pod", "c"),
+	}}
+	assert.Empty(t, o.ExportEventsWithResult(batch))
+	c.lock.Lock()
+	assert.Equal(t, []int{2, 1}, c.records)
+	c.status = "14"
+	c.lock.Unlock()
+
+	failures := o.ExportEventsWithResult(batch)
+	assert.Len(t, failures, 3)
+	assert.True(t, core.IsRetryable(failures[0].Err))
+	assert.Contains(t, failures[0].Err.Error(), "collector is down")
+
+	c.lock.Lock()
+	c.status = "3"
+	c.lock.Unlock()
+	failures = o.ExportEventsWithResult(batch)
+	assert.Len(t, failures, 3)
+	assert.False(t, core.IsRetryable(failures[0].Err))
+}
+
+func TestUnmarshalPartialSuccess(t *testing.T) {
+	response := appendMessage(nil, 1, append(protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 2),
+		appendString(nil, 2, "too old")...))
+	rejected, message, err := unmarshalPartialSuccess(response)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), rejected)
+	assert.Equal(t, "too old", message)
+
+	rejected, _, err = unmarshalPartialSuccess(nil)
+	assert.NoError(t, err)
+	assert.Zero(t, rejected)
+
+	_, _, err = unmarshalPartialSuccess([]byte{0x0a, 0x05})
+	assert.Error(t, err)
+}
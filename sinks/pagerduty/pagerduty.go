@@ -0,0 +1,355 @@
+package pagerduty
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	metrics "github.com/AliyunContainerService/kube-eventer/metrics/prometheus"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	SinkName = "PagerDutySink"
+
+	defaultEndpoint = "https://events.pagerduty.com/v2/enqueue"
+	enqueuePath     = "/v2/enqueue"
+
+	actionTrigger = "trigger"
+	actionResolve = "resolve"
+
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+
+	// PagerDuty rejects longer summaries and dedup keys.
+	maxSummaryLength  = 1024
+	maxDedupKeyLength = 255
+
+	// pendingAnnotation lists the actions left to send for an event which failed with a retryable
+	// error, so that its retry does not send the actions again which succeeded.
+	pendingAnnotation = "kube-eventer/pagerduty-pending"
+)
+
+// Abnormal events of nodes page as critical by default, other abnormal events as error.
+var defaultSeverities = map[metrics.AbnormalEventReason]string{
+	metrics.NodeNotReady:      SeverityCritical,
+	metrics.NodeRebooted:      SeverityCritical,
+	metrics.NodeOOM:           SeverityCritical,
+	metrics.NodeDockerHung:    SeverityCritical,
+	metrics.NodePSHung:        SeverityCritical,
+	metrics.NodePLEGUnhealthy: SeverityCritical,
+}
+
+// Event is an event of the Events API v2.
+type Event struct {
+	RoutingKey  string   `json:"routing_key"`
+	EventAction string   `json:"event_action"`
+	DedupKey    string   `json:"dedup_key"`
+	Client      string   `json:"client,omitempty"`
+	Payload     *Payload `json:"payload,omitempty"`
+}
+
+type Payload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     string            `json:"timestamp,omitempty"`
+	Component     string            `json:"component,omitempty"`
+	Group         string            `json:"group,omitempty"`
+	Class         string            `json:"class,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+/*
+pagerduty sink usage
+--sink=pagerduty:?routing_key=<integration key>&level=Warning&resolve=NodeNotReady:NodeReady
+
+routing_key: integration key of an Events API v2 integration.
+level: Normal or Warning. The event level greater than global level will emit.
+label: some thing unique when you want to distinguish different k8s clusters.
+severity: <reason>:<critical|error|warning|info>, severity of the events with an abnormal reason or reason.
+resolve: <reason>:<recovery reason>, resolves the incident of the reason when the recovery reason occurs for the same object.
+*/
+type PagerDutySink struct {
+	endpoint   string
+	routingKey string
+	level      int
+	labels     []string
+	severities map[string]string
+	// recovery reason to the reasons it resolves.
+	resolves map[string][]string
+	client   *http.Client
+}
+
+func (p *PagerDutySink) Name() string {
+	return SinkName
+}
+
+func (p *PagerDutySink) Stop() {
+	// do nothing
+}
+
+// BypassesLevel returns whether the event is a recovery event. Recovery events are mostly Normal,
+// so they resolve regardless of the level of the sink, which the filter rules of the sink respect.
+func (p *PagerDutySink) BypassesLevel(event *v1.Event) bool {
+	return len(p.resolves[event.Reason]) > 0
+}
+
+func (p *PagerDutySink) ExportEvents(batch *core.EventBatch) {
+	for _, failure := range p.ExportEventsWithResult(batch) {
+		klog.Errorf("failed to send event to pagerduty, because of %v", failure.Err)
+	}
+}
+
+// ExportEventsWithResult reports a failure per event at most. An event failing with a retryable
+// error is reported with the actions left to send, so that only these are retried.
+func (p *PagerDutySink) ExportEventsWithResult(batch *core.EventBatch) []core.ExportFailure {
+	var failures []core.ExportFailure
+	for _, event := range batch.Events {
+		var pending []string
+		var retryableErr, permanentErr error
+		for _, action := range p.actions(event) {
+			var err error
+			if action == actionTrigger {
+				err = p.send(p.createTriggerEvent(event))
+			} else {
+				err = p.send(p.createResolveEvent(event, strings.TrimPrefix(action, actionResolve+":")))
+			}
+			switch {
+			case err == nil:
+			case core.IsRetryable(err):
+				pending = append(pending, action)
+				retryableErr = err
+			default:
+				permanentErr = err
+			}
+		}
+		switch {
+		case len(pending) > 0:
+			if permanentErr != nil {
+				klog.Errorf("failed to send event to pagerduty, because of %v", permanentErr)
+			}
+			failures = append(failures, core.NewExportFailure(withPending(event, pending), retryableErr))
+		case permanentErr != nil:
+			failures = append(failures, core.NewExportFailure(event, permanentErr))
+		}
+	}
+	return failures
+}
+
+// actions returns the actions to send for the event: resolve:<reason> for the incidents it
+// resolves and trigger if it is of the level. A retried event has the actions left to send.
+func (p *PagerDutySink) actions(event *v1.Event) []string {
+	if pending, found := event.Annotations[pendingAnnotation]; found {
+		return strings.Split(pending, ",")
+	}
+	var actions []string
+	for _, reason := range p.resolves[event.Reason] {
+		actions = append(actions, actionResolve+":"+reason)
+	}
+	if util.GetLevel(event.Type) >= p.level {
+		actions = append(actions, actionTrigger)
+	}
+	return actions
+}
+
+// withPending returns a copy of the event with the actions left to send.
+func withPending(event *v1.Event, actions []string) *v1.Event {
+	event = event.DeepCopy()
+	if event.Annotations == nil {
+		event.Annotations = map[string]string{}
+	}
+	event.Annotations[pendingAnnotation] = strings.Join(actions, ",")
+	return event
+}
+
+func (p *PagerDutySink) createTriggerEvent(event *v1.Event) *Event {
+	namespace := getNamespace(event)
+	details := map[string]string{
+		"message": event.Message,
+		"kind":    event.InvolvedObject.Kind,
+		"name":    event.InvolvedObject.Name,
+		"reason":  event.Reason,
+	}
+	if namespace != "" {
+		details["namespace"] = namespace
+	}
+	if event.Count > 1 {
+		details["count"] = strconv.Itoa(int(event.Count))
+	}
+	if cluster := util.GetClusterName(event, ""); cluster != "" {
+		details["cluster"] = cluster
+	}
+	if event.Source.Host != "" {
+		details["node"] = event.Source.Host
+	}
+	abnormal, ok := metrics.TriageEvent(event)
+	if ok {
+		details["abnormal_reason"] = string(abnormal)
+	}
+	if len(p.labels) > 0 {
+		details["label"] = strings.Join(p.labels, ",")
+	}
+
+	summary := fmt.Sprintf("%s %s/%s %s: %s", event.InvolvedObject.Kind, namespace, event.InvolvedObject.Name, event.Reason, event.Message)
+	if runes := []rune(summary); len(runes) > maxSummaryLength {
+		summary = string(runes[:maxSummaryLength-3]) + "..."
+	}
+
+	return &Event{
+		RoutingKey:  p.routingKey,
+		EventAction: actionTrigger,
+		DedupKey:    dedupKey(event, event.Reason),
+		Client:      "kube-eventer",
+		Payload: &Payload{
+			Summary:       summary,
+			Source:        source(event),
+			Severity:      p.severity(event, abnormal, ok),
+			Timestamp:     util.GetLastEventTimestamp(event).UTC().Format(time.RFC3339),
+			Component:     fmt.Sprintf("%s/%s", event.InvolvedObject.Kind, event.InvolvedObject.Name),
+			Group:         namespace,
+			Class:         event.Reason,
+			CustomDetails: details,
+		},
+	}
+}
+
+func (p *PagerDutySink) createResolveEvent(event *v1.Event, reason string) *Event {
+	return &Event{
+		RoutingKey:  p.routingKey,
+		EventAction: actionResolve,
+		DedupKey:    dedupKey(event, reason),
+	}
+}
+
+// severity maps the abnormal reason or the reason of an event to a severity, falling back to its type.
+func (p *PagerDutySink) severity(event *v1.Event, abnormal metrics.AbnormalEventReason, isAbnormal bool) string {
+	if isAbnormal {
+		if severity, found := p.severities[string(abnormal)]; found {
+			return severity
+		}
+	}
+	if severity, found := p.severities[event.Reason]; found {
+		return severity
+	}
+	if isAbnormal {
+		if severity, found := defaultSeverities[abnormal]; found {
+			return severity
+		}
+		return SeverityError
+	}
+	if event.Type == v1.EventTypeWarning {
+		return SeverityWarning
+	}
+	return SeverityInfo
+}
+
+// dedupKey identifies the incident of an object and reason, so that repeated events do not open new incidents.
+func dedupKey(event *v1.Event, reason string) string {
+	key := strings.Join([]string{util.GetClusterName(event, ""), event.InvolvedObject.Kind, getNamespace(event), event.InvolvedObject.Name, reason}, "/")
+	if len(key) > maxDedupKeyLength {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+	return key
+}
+
+func source(event *v1.Event) string {
+	if event.Source.Host != "" {
+		return event.Source.Host
+	}
+	if cluster := util.GetClusterName(event, ""); cluster != "" {
+		return cluster
+	}
+	if event.Source.Component != "" {
+		return event.Source.Component
+	}
+	return "kubernetes"
+}
+
+func getNamespace(event *v1.Event) string {
+	if event.InvolvedObject.Namespace != "" {
+		return event.InvolvedObject.Namespace
+	}
+	return event.Namespace
+}
+
+func (p *PagerDutySink) send(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pagerduty event %v: %v", e, err)
+	}
+	resp, err := p.client.Post(p.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return core.NewRetryableError(fmt.Errorf("failed to send event to pagerduty: %v", err))
+	}
+	defer resp.Body.Close()
+	// the events api answers 202 once the event is queued.
+	return util.CheckResponse(resp, "send event to pagerduty")
+}
+
+func NewPagerDutySink(uri *url.URL) (*PagerDutySink, error) {
+	p := &PagerDutySink{
+		endpoint:   defaultEndpoint,
+		level:      util.GetLevel(v1.EventTypeWarning),
+		severities: map[string]string{},
+		resolves:   map[string][]string{},
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+	// the endpoint is only set for a proxy or an EU account.
+	if uri.Host != "" {
+		path := strings.TrimSuffix(uri.Path, "/")
+		if path == "" {
+			path = enqueuePath
+		}
+		p.endpoint = (&url.URL{Scheme: uri.Scheme, Host: uri.Host, Path: path}).String()
+	}
+	opts := uri.Query()
+
+	if len(opts["routing_key"]) < 1 || opts["routing_key"][0] == "" {
+		return nil, fmt.Errorf("you must provide the routing_key of the pagerduty integration")
+	}
+	p.routingKey = opts["routing_key"][0]
+	if len(opts["level"]) >= 1 {
+		p.level = util.GetLevel(opts["level"][0])
+	}
+	if len(opts["label"]) >= 1 {
+		p.labels = opts["label"]
+	}
+	for _, severity := range opts["severity"] {
+		parts := strings.SplitN(severity, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || !validSeverity(parts[1]) {
+			return nil, fmt.Errorf("severity must look like <reason>:<critical|error|warning|info>, got %q", severity)
+		}
+		p.severities[parts[0]] = parts[1]
+	}
+	for _, resolve := range opts["resolve"] {
+		parts := strings.SplitN(resolve, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("resolve must look like <reason>:<recovery reason>, got %q", resolve)
+		}
+		p.resolves[parts[1]] = append(p.resolves[parts[1]], parts[0])
+	}
+
+	return p, nil
+}
+
+func validSeverity(severity string) bool {
+	switch severity {
+	case SeverityCritical, SeverityError, SeverityWarning, SeverityInfo:
+		return true
+	}
+	return false
+}
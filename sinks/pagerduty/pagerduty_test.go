@@ -0,0 +1,142 @@
+package pagerduty
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+)
+
+func newNodeEvent(eventType, reason, message string) *v1.Event {
+	event := util.NewDummyEvent(eventType, "Node", "node-1", reason)
+	event.Message = message
+	return event
+}
+
+func TestNewPagerDutySink(t *testing.T) {
+	uri, _ := url.Parse("?routing_key=abc&level=Normal&label=prod&severity=BackOff:error&resolve=NodeNotReady:NodeReady&resolve=NodeRebooted:NodeReady")
+	p, err := NewPagerDutySink(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultEndpoint, p.endpoint)
+	assert.Equal(t, "abc", p.routingKey)
+	assert.Equal(t, 1, p.level)
+	assert.Equal(t, []string{"prod"}, p.labels)
+	assert.Equal(t, map[string]string{"BackOff": SeverityError}, p.severities)
+	assert.Equal(t, map[string][]string{"NodeReady": {"NodeNotReady", "NodeRebooted"}}, p.resolves)
+
+	uri, _ = url.Parse("https://events.eu.pagerduty.com?routing_key=abc")
+	p, err = NewPagerDutySink(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://events.eu.pagerduty.com/v2/enqueue", p.endpoint)
+
+	for _, invalid := range []string{"level=Warning", "routing_key=abc&severity=BackOff:high", "routing_key=abc&resolve=NodeNotReady"} {
+		uri, _ = url.Parse("?" + invalid)
+		_, err = NewPagerDutySink(uri)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCreateTriggerEvent(t *testing.T) {
+	uri, _ := url.Parse("?routing_key=abc&label=team-a")
+	p, _ := NewPagerDutySink(uri)
+
+	e := p.createTriggerEvent(newNodeEvent(v1.EventTypeWarning, "NodeNotReady", "Node node-1 status is now: NodeNotReady"))
+	assert.Equal(t, "abc", e.RoutingKey)
+	assert.Equal(t, actionTrigger, e.EventAction)
+	assert.Equal(t, "prod/Node/default/node-1/NodeNotReady", e.DedupKey)
+	assert.Equal(t, "Node default/node-1 NodeNotReady: Node node-1 status is now: NodeNotReady", e.Payload.Summary)
+	assert.Equal(t, "node-1", e.Payload.Source)
+	assert.Equal(t, SeverityCritical, e.Payload.Severity)
+	assert.Equal(t, "2024-01-01T10:05:00Z", e.Payload.Timestamp)
+	assert.Equal(t, "Node/node-1", e.Payload.Component)
+	assert.Equal(t, "NodeNotReady", e.Payload.Class)
+	assert.Equal(t, "NodeNotReady", e.Payload.CustomDetails["abnormal_reason"])
+	assert.Equal(t, "3", e.Payload.CustomDetails["count"])
+	assert.Equal(t, "team-a", e.Payload.CustomDetails["label"])
+
+	e = p.createTriggerEvent(newNodeEvent(v1.EventTypeWarning, "NodeNotReady", strings.Repeat("x", 2000)))
+	assert.Equal(t, maxSummaryLength, len([]rune(e.Payload.Summary)))
+
+	long := newNodeEvent(v1.EventTypeWarning, "NodeNotReady", "")
+	long.InvolvedObject.Name = strings.Repeat("n", 300)
+	assert.Len(t, dedupKey(long, long.Reason), 64)
+}
+
+func TestSeverity(t *testing.T) {
+	uri, _ := url.Parse("?routing_key=abc&severity=BackOff:error&severity=NodeNotReady:warning")
+	p, _ := NewPagerDutySink(uri)
+
+	assert.Equal(t, SeverityWarning, p.createTriggerEvent(newNodeEvent(v1.EventTypeWarning, "NodeNotReady", "")).Payload.Severity)
+	assert.Equal(t, SeverityError, p.createTriggerEvent(newNodeEvent(v1.EventTypeWarning, "BackOff", "")).Payload.Severity)
+	assert.Equal(t, SeverityError, p.createTriggerEvent(newNodeEvent(v1.EventTypeWarning, "Evicted", "")).Payload.Severity)
+	assert.Equal(t, SeverityWarning, p.createTriggerEvent(newNodeEvent(v1.EventTypeWarning, "Unhealthy", "")).Payload.Severity)
+	assert.Equal(t, SeverityInfo, p.createTriggerEvent(newNodeEvent(v1.EventTypeNormal, "Pulled", "")).Payload.Severity)
+}
+
+func TestExportEventsResolves(t *testing.T) {
+	var received []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, enqueuePath, r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		var e Event
+		assert.NoError(t, json.Unmarshal(body, &e))
+		received = append(received, e)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	uri, _ := url.Parse(server.URL + "?routing_key=abc&resolve=NodeNotReady:NodeReady")
+	p, err := NewPagerDutySink(uri)
+	assert.NoError(t, err)
+
+	batch := &core.EventBatch{Events: []*v1.Event{
+		newNodeEvent(v1.EventTypeWarning, "NodeNotReady", "Node node-1 status is now: NodeNotReady"),
+		newNodeEvent(v1.EventTypeNormal, "Starting", "Starting kubelet."),
+		newNodeEvent(v1.EventTypeNormal, "NodeReady", "Node node-1 status is now: NodeReady"),
+	}}
+	assert.Empty(t, p.ExportEventsWithResult(batch))
+	assert.Len(t, received, 2)
+	assert.Equal(t, actionTrigger, received[0].EventAction)
+	assert.Equal(t, actionResolve, received[1].EventAction)
+	assert.Equal(t, received[0].DedupKey, received[1].DedupKey)
+	assert.Nil(t, received[1].Payload)
+}
+
+func TestExportEventsRetriesFailedActions(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var e Event
+		assert.NoError(t, json.Unmarshal(body, &e))
+		received = append(received, e.EventAction)
+		// the first resolve fails.
+		if e.EventAction == actionResolve && len(received) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	uri, _ := url.Parse(server.URL + "?routing_key=abc&resolve=NodeNotReady:NodeRebooted")
+	p, _ := NewPagerDutySink(uri)
+	event := newNodeEvent(v1.EventTypeWarning, "NodeRebooted", "Node node-1 has been rebooted")
+
+	failures := p.ExportEventsWithResult(&core.EventBatch{Events: []*v1.Event{event}})
+	assert.Len(t, failures, 1)
+	assert.True(t, failures[0].Retryable)
+	assert.Equal(t, []string{actionResolve, actionTrigger}, received)
+	assert.NotContains(t, event.Annotations, pendingAnnotation)
+
+	// the retry only resolves.
+	assert.Empty(t, p.ExportEventsWithResult(&core.EventBatch{Events: []*v1.Event{failures[0].Event}}))
+	assert.Equal(t, []string{actionResolve, actionTrigger, actionResolve}, received)
+}
@@ -0,0 +1,113 @@
+package sinks
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/common/filters"
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/processors"
+	kube_api "k8s.io/api/core/v1"
+)
+
+// levelBypassingSink is a sink exporting some events below its level, e.g. the recovery events of
+// PagerDuty. The level of the filter rules lets these events pass.
+type levelBypassingSink interface {
+	BypassesLevel(event *kube_api.Event) bool
+}
+
+// levelFilter matches the events of the level and the events bypassing it.
+type levelFilter struct {
+	level    filters.Filter
+	bypasses func(event *kube_api.Event) bool
+}
+
+func (lf *levelFilter) Filter(event *kube_api.Event) bool {
+	return lf.bypasses(event) || lf.level.Filter(event)
+}
+
+// processedSink runs the processors of the sink, e.g. its filter rules, before exporting.
+type processedSink struct {
+	core.EventSink
+	processors []core.EventProcessor
+}
+
+func (ps *processedSink) process(batch *core.EventBatch) *core.EventBatch {
+	for _, p := range ps.processors {
+		batch = p.Process(batch)
+	}
+	return batch
+}
+
+func (ps *processedSink) ExportEvents(batch *core.EventBatch) {
+	ps.EventSink.ExportEvents(ps.process(batch))
+}
+
+// processedReportingSink keeps reporting the failures of a core.ReportingEventSink.
+type processedReportingSink struct {
+	*processedSink
+	reporting core.ReportingEventSink
+}
+
+func (ps *processedReportingSink) ExportEventsWithResult(batch *core.EventBatch) []core.ExportFailure {
+	return ps.reporting.ExportEventsWithResult(ps.process(batch))
+}
+
+// unwrapProcessors runs the processors of a processed core.ReportingEventSink on the batch and
+// returns the sink they wrap. Retries export to that sink, so that the retried events are not
+// filtered or deduplicated again.
+func unwrapProcessors(s core.EventSink, data *core.EventBatch) (core.EventSink, *core.EventBatch) {
+	if ps, ok := s.(*processedReportingSink); ok {
+		return ps.reporting, ps.process(data)
+	}
+	return s, data
+}
+
+// withProcessors wraps the sink with the processors configured by its options, if any:
+// the filter rules and dedup_window. The sinks leave these options to it, so the namespaces,
+// kinds and other filter rules of every sink are applied here. Events a levelBypassingSink
+// exports below its level are not dropped by the level rule.
+func withProcessors(sink core.EventSink, opts map[string][]string) (core.EventSink, error) {
+	sinkProcessors := []core.EventProcessor{}
+
+	ruleOpts := opts
+	if bypassing, ok := sink.(levelBypassingSink); ok && len(filters.GetValues(opts["level"])) > 0 {
+		// the level is applied apart from the other rules to let the events bypassing it pass.
+		level, err := filters.NewRuleFilter(map[string][]string{"level": opts["level"]})
+		if err != nil {
+			return nil, err
+		}
+		sinkProcessors = append(sinkProcessors, processors.NewFilterProcessor(sink.Name(),
+			&levelFilter{level: level, bypasses: bypassing.BypassesLevel}))
+		ruleOpts = make(map[string][]string, len(opts))
+		for key, values := range opts {
+			if key != "level" {
+				ruleOpts[key] = values
+			}
+		}
+	}
+	filter, err := filters.NewRuleFilter(ruleOpts)
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		sinkProcessors = append(sinkProcessors, processors.NewFilterProcessor(sink.Name(), filter))
+	}
+
+	if dedupWindow := opts[processors.DedupWindowOption]; len(dedupWindow) >= 1 && dedupWindow[0] != "" {
+		window, err := time.ParseDuration(dedupWindow[0])
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("invalid dedup_window %q", dedupWindow[0])
+		}
+		sinkProcessors = append(sinkProcessors, processors.NewDedupProcessor(sink.Name(), window))
+	}
+
+	if len(sinkProcessors) == 0 {
+		return sink, nil
+	}
+	ps := &processedSink{EventSink: sink, processors: sinkProcessors}
+	if reporting, ok := sink.(core.ReportingEventSink); ok {
+		return &processedReportingSink{processedSink: ps, reporting: reporting}, nil
+	}
+	return ps, nil
+}
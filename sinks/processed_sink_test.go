@@ -0,0 +1,92 @@
+package sinks
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/sinks/pagerduty"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	"github.com/stretchr/testify/assert"
+	kube_api "k8s.io/api/core/v1"
+)
+
+func TestWithProcessorsKeepsReportingSink(t *testing.T) {
+	sink := &flakySink{err: core.NewRetryableError(errors.New("unavailable")), failCount: 1}
+	filtered, err := withProcessors(sink, map[string][]string{"kinds": {"Pod"}})
+	assert.NoError(t, err)
+	reporting, ok := filtered.(core.ReportingEventSink)
+	assert.True(t, ok)
+	assert.Equal(t, "flaky", reporting.Name())
+
+	batch := &core.EventBatch{
+		Timestamp: time.Now(),
+		Events: []*kube_api.Event{
+			{Message: "a", InvolvedObject: kube_api.ObjectReference{Kind: "Pod"}},
+			{Message: "b", InvolvedObject: kube_api.ObjectReference{Kind: "Node"}},
+		},
+	}
+	failures := reporting.ExportEventsWithResult(batch)
+	assert.Equal(t, 1, len(failures))
+	assert.Equal(t, "a", failures[0].Event.Message)
+}
+
+func TestWithProcessorsWithoutOptions(t *testing.T) {
+	sink := util.NewDummySink("sink", time.Millisecond)
+	filtered, err := withProcessors(sink, map[string][]string{"level": {}})
+	assert.NoError(t, err)
+	assert.Equal(t, sink, filtered)
+
+	_, err = withProcessors(sink, map[string][]string{"level": {"Critical"}})
+	assert.Error(t, err)
+}
+
+func TestWithProcessorsLetsRecoveryEventsBypassTheLevel(t *testing.T) {
+	var actions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var e pagerduty.Event
+		assert.NoError(t, json.Unmarshal(body, &e))
+		actions = append(actions, e.EventAction)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	uri, _ := url.Parse(server.URL + "?routing_key=abc&level=Warning&kinds=Node&resolve=NodeNotReady:NodeReady")
+	sink, err := pagerduty.NewPagerDutySink(uri)
+	assert.NoError(t, err)
+	processed, err := withProcessors(sink, uri.Query())
+	assert.NoError(t, err)
+
+	newEvent := func(eventType, kind, reason string) *kube_api.Event {
+		return &kube_api.Event{Type: eventType, Reason: reason,
+			InvolvedObject: kube_api.ObjectReference{Kind: kind, Name: "node-1"}}
+	}
+	batch := &core.EventBatch{Events: []*kube_api.Event{
+		newEvent(kube_api.EventTypeWarning, "Node", "NodeNotReady"),
+		newEvent(kube_api.EventTypeNormal, "Node", "Starting"),
+		newEvent(kube_api.EventTypeNormal, "Node", "NodeReady"),
+		// the other rules still apply to recovery events.
+		newEvent(kube_api.EventTypeNormal, "Pod", "NodeReady"),
+	}}
+	assert.Empty(t, processed.(core.ReportingEventSink).ExportEventsWithResult(batch))
+	assert.Equal(t, []string{"trigger", "resolve"}, actions)
+}
+
+func TestRetryDoesNotDeduplicateAgain(t *testing.T) {
+	sink := &flakySink{err: core.NewRetryableError(errors.New("unavailable")), failCount: 1}
+	processed, err := withProcessors(sink, map[string][]string{"dedup_window": {"10m"}})
+	assert.NoError(t, err)
+
+	failed := exportWithRetry(processed, newTestBatch(), testRetryPolicy)
+	assert.Empty(t, failed)
+	exports, exported := sink.getExports()
+	assert.Equal(t, 2, exports)
+	assert.Equal(t, 2, exported)
+}
@@ -0,0 +1,219 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	kube_api "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Events listed in a digest, the others are only counted.
+	MaxDigestEvents = 20
+	// Messages longer than this are cut in the digest.
+	maxDigestMessageLength = 200
+)
+
+var (
+	// Events sent in a digest instead of a message of their own.
+	rateLimitedEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "eventer",
+			Subsystem: "sink",
+			Name:      "rate_limited_events_total",
+			Help:      "Events held back by the rate limit of a sink and sent in a digest.",
+		},
+		[]string{"sink"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(rateLimitedEvents)
+}
+
+// Digest lists the events held back while the budget of a sink was exhausted.
+type Digest struct {
+	// The first MaxDigestEvents events held back.
+	Events []*kube_api.Event
+	// All events held back, including those not listed.
+	Total int
+}
+
+// Lines returns a line per listed event and a last line counting the events not listed.
+func (d *Digest) Lines() []string {
+	lines := make([]string, 0, len(d.Events)+1)
+	for _, event := range d.Events {
+		message := event.Message
+		if utf8.RuneCountInString(message) > maxDigestMessageLength {
+			message = string([]rune(message)[:maxDigestMessageLength]) + "..."
+		}
+		lines = append(lines, fmt.Sprintf("[%s] %s %s/%s %s: %s", event.Type, event.InvolvedObject.Kind,
+			event.InvolvedObject.Namespace, event.InvolvedObject.Name, event.Reason, message))
+	}
+	if more := d.Total - len(d.Events); more > 0 {
+		lines = append(lines, fmt.Sprintf("... and %d more", more))
+	}
+	return lines
+}
+
+// Limiter is a token bucket spending a token per message sent by a sink. Once the bucket is
+// empty, the sink is in digest mode: events are held back until a token is available again
+// and then sent together in a single digest message.
+type Limiter struct {
+	sink    string
+	limiter *rate.Limiter
+	lock    sync.Mutex
+	pending Digest
+}
+
+// NewLimiter returns the limiter configured by the rate_limit and rate_burst options of a
+// sink, or nil if rate_limit is not set.
+//
+// rate_limit is a number of messages per second, minute or hour, e.g. 20/m.
+// rate_burst is the number of messages sent at once before the limit applies. It defaults
+// to the number of messages of rate_limit.
+func NewLimiter(sink string, opts url.Values) (*Limiter, error) {
+	if len(opts["rate_limit"]) < 1 || opts["rate_limit"][0] == "" {
+		return nil, nil
+	}
+	count, per, err := parseRate(opts["rate_limit"][0])
+	if err != nil {
+		return nil, err
+	}
+	burst := count
+	if len(opts["rate_burst"]) >= 1 {
+		burst, err = strconv.Atoi(opts["rate_burst"][0])
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("rate_burst must be a positive number, got %q", opts["rate_burst"][0])
+		}
+	}
+	return newLimiter(sink, rate.Limit(float64(count)/per.Seconds()), burst), nil
+}
+
+func newLimiter(sink string, limit rate.Limit, burst int) *Limiter {
+	return &Limiter{
+		sink:    sink,
+		limiter: rate.NewLimiter(limit, burst),
+	}
+}
+
+// parseRate parses <count>/<s|m|h>.
+func parseRate(value string) (int, time.Duration, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("rate_limit must look like <count>/<s|m|h>, got %q", value)
+	}
+	count, err := strconv.Atoi(parts[0])
+	if err != nil || count < 1 {
+		return 0, 0, fmt.Errorf("rate_limit must have a positive count, got %q", value)
+	}
+	switch parts[1] {
+	case "s":
+		return count, time.Second, nil
+	case "m":
+		return count, time.Minute, nil
+	case "h":
+		return count, time.Hour, nil
+	}
+	return 0, 0, fmt.Errorf("rate_limit must be per s, m or h, got %q", value)
+}
+
+// Admit tells whether the event may be sent on its own. Otherwise it is held back for the digest.
+func (l *Limiter) Admit(event *kube_api.Event) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// Once in digest mode, later events go to the digest as well so that they are not sent before older ones.
+	if l.pending.Total == 0 && l.limiter.Allow() {
+		return true
+	}
+	l.holdBack(event)
+	rateLimitedEvents.WithLabelValues(l.sink).Inc()
+	return false
+}
+
+// AdmitAll tells whether a single message for all the events may be sent. Otherwise they are held back for the digest.
+func (l *Limiter) AdmitAll(events []*kube_api.Event) bool {
+	if len(events) == 0 {
+		return true
+	}
+	if l.Admit(events[0]) {
+		return true
+	}
+	// the sink is in digest mode now, the other events are held back as well.
+	for _, event := range events[1:] {
+		l.Admit(event)
+	}
+	return false
+}
+
+// Digest returns the events held back if a token is available to send them, and leaves
+// digest mode. It returns nil if there are none or the budget is still exhausted.
+func (l *Limiter) Digest() *Digest {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.pending.Total == 0 || !l.limiter.Allow() {
+		return nil
+	}
+	digest := l.pending
+	l.pending = Digest{}
+	return &digest
+}
+
+// Restore holds back the events of a digest which failed to send again, to be sent with the next digest.
+func (l *Limiter) Restore(digest *Digest) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	pending := l.pending
+	l.pending = Digest{}
+	for _, event := range digest.Events {
+		l.holdBack(event)
+	}
+	l.pending.Total += digest.Total - len(digest.Events)
+	for _, event := range pending.Events {
+		l.holdBack(event)
+	}
+	l.pending.Total += pending.Total - len(pending.Events)
+}
+
+// SendDigest sends the events held back with send once a message is allowed again. A digest
+// which fails with a retryable error is held back again, to be sent with the next digest rather
+// than event by event. The events of a digest which fails otherwise are returned.
+func (l *Limiter) SendDigest(send func(digest *Digest) error) []core.ExportFailure {
+	digest := l.Digest()
+	if digest == nil {
+		return nil
+	}
+	err := send(digest)
+	if err == nil {
+		return nil
+	}
+	if core.IsRetryable(err) {
+		klog.Warningf("failed to send digest of %d events of %s, will retry: %v", digest.Total, l.sink, err)
+		l.Restore(digest)
+		return nil
+	}
+	failures := make([]core.ExportFailure, 0, len(digest.Events))
+	for _, event := range digest.Events {
+		failures = append(failures, core.NewExportFailure(event, err))
+	}
+	return failures
+}
+
+func (l *Limiter) holdBack(event *kube_api.Event) {
+	if len(l.pending.Events) < MaxDigestEvents {
+		l.pending.Events = append(l.pending.Events, event)
+	}
+	l.pending.Total++
+}
@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+	kube_api "k8s.io/api/core/v1"
+)
+
+func newEvent(name string) *kube_api.Event {
+	return &kube_api.Event{
+		Type:    kube_api.EventTypeWarning,
+		Reason:  "BackOff",
+		Message: "Back-off restarting failed container",
+		InvolvedObject: kube_api.ObjectReference{
+			Kind:      "Pod",
+			Namespace: "default",
+			Name:      name,
+		},
+	}
+}
+
+func TestNewLimiter(t *testing.T) {
+	limiter, err := NewLimiter("test", url.Values{})
+	assert.NoError(t, err)
+	assert.Nil(t, limiter)
+
+	limiter, err = NewLimiter("test", url.Values{"rate_limit": {"20/m"}})
+	assert.NoError(t, err)
+	assert.InDelta(t, 20.0/60, float64(limiter.limiter.Limit()), 1e-9)
+	assert.Equal(t, 20, limiter.limiter.Burst())
+
+	limiter, err = NewLimiter("test", url.Values{"rate_limit": {"1/s"}, "rate_burst": {"5"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, limiter.limiter.Burst())
+
+	for _, invalid := range []url.Values{
+		{"rate_limit": {"20"}},
+		{"rate_limit": {"0/m"}},
+		{"rate_limit": {"20/d"}},
+		{"rate_limit": {"20/m"}, "rate_burst": {"0"}},
+	} {
+		_, err = NewLimiter("test", invalid)
+		assert.Error(t, err, "%v", invalid)
+	}
+}
+
+func TestDigestMode(t *testing.T) {
+	limiter := newLimiter("test", rate.Every(time.Hour), 2)
+
+	assert.True(t, limiter.Admit(newEvent("a")))
+	assert.True(t, limiter.Admit(newEvent("b")))
+	assert.False(t, limiter.Admit(newEvent("c")))
+	assert.False(t, limiter.Admit(newEvent("d")))
+	// no token left for the digest.
+	assert.Nil(t, limiter.Digest())
+
+	limiter.limiter.SetLimit(rate.Inf)
+	// in digest mode later events are held back as well.
+	assert.False(t, limiter.Admit(newEvent("e")))
+	digest := limiter.Digest()
+	assert.Equal(t, 3, digest.Total)
+	assert.Equal(t, "[Warning] Pod default/c BackOff: Back-off restarting failed container", digest.Lines()[0])
+
+	// the digest was sent, events are sent on their own again.
+	assert.Nil(t, limiter.Digest())
+	assert.True(t, limiter.Admit(newEvent("f")))
+}
+
+func TestDigestListsFirstEvents(t *testing.T) {
+	limiter := newLimiter("test", 0, 0)
+	for i := 0; i < MaxDigestEvents+5; i++ {
+		assert.False(t, limiter.Admit(newEvent(fmt.Sprintf("pod-%d", i))))
+	}
+	limiter.limiter.SetLimit(rate.Inf)
+	digest := limiter.Digest()
+	assert.Equal(t, MaxDigestEvents+5, digest.Total)
+	lines := digest.Lines()
+	assert.Len(t, lines, MaxDigestEvents+1)
+	assert.Equal(t, "... and 5 more", lines[MaxDigestEvents])
+}
+
+func TestRestore(t *testing.T) {
+	limiter := newLimiter("test", 0, 0)
+	limiter.Admit(newEvent("a"))
+	limiter.limiter.SetLimit(rate.Inf)
+	digest := limiter.Digest()
+
+	limiter.limiter.SetLimit(0)
+	limiter.Admit(newEvent("b"))
+	limiter.Restore(digest)
+
+	limiter.limiter.SetLimit(rate.Inf)
+	digest = limiter.Digest()
+	assert.Equal(t, 2, digest.Total)
+	assert.True(t, strings.Contains(digest.Lines()[0], "default/a"))
+	assert.True(t, strings.Contains(digest.Lines()[1], "default/b"))
+}
+
+func TestSendDigest(t *testing.T) {
+	limiter := newLimiter("test", 0, 0)
+	limiter.Admit(newEvent("a"))
+	limiter.Admit(newEvent("b"))
+	limiter.limiter.SetLimit(rate.Inf)
+
+	// a retryable failure holds the events back for the next digest.
+	failures := limiter.SendDigest(func(digest *Digest) error {
+		return core.NewRetryableError(errors.New("unavailable"))
+	})
+	assert.Empty(t, failures)
+
+	var sent *Digest
+	failures = limiter.SendDigest(func(digest *Digest) error {
+		sent = digest
+		return errors.New("bad request")
+	})
+	assert.Equal(t, 2, sent.Total)
+	assert.Len(t, failures, 2)
+	assert.False(t, core.IsRetryable(failures[0].Err))
+
+	assert.Empty(t, limiter.SendDigest(func(digest *Digest) error {
+		t.Fatal("no events are held back")
+		return nil
+	}))
+}
+
+func TestAdmitAll(t *testing.T) {
+	limiter := newLimiter("test", rate.Every(time.Hour), 1)
+	assert.True(t, limiter.AdmitAll([]*kube_api.Event{newEvent("a"), newEvent("b")}))
+	assert.False(t, limiter.AdmitAll([]*kube_api.Event{newEvent("c"), newEvent("d")}))
+
+	limiter.limiter.SetLimit(rate.Inf)
+	assert.Equal(t, 2, limiter.Digest().Total)
+}
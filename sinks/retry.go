@@ -0,0 +1,240 @@
+package sinks
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/prometheus/client_golang/prometheus"
+	kube_api "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// Events exported again after a retryable failure.
+	exporterRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "eventer",
+			Subsystem: "exporter",
+			Name:      "retries_total",
+			Help:      "Events exported again after a retryable failure.",
+		},
+		[]string{"exporter"},
+	)
+	// Events that failed with an error which is not retryable.
+	exporterPermanentFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "eventer",
+			Subsystem: "exporter",
+			Name:      "permanent_failures_total",
+			Help:      "Events that failed to export with an error which is not retryable.",
+		},
+		[]string{"exporter"},
+	)
+	// Events still failing after all retries.
+	exporterGiveUps = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "eventer",
+			Subsystem: "exporter",
+			Name:      "give_ups_total",
+			Help:      "Events that still failed to export after all retries.",
+		},
+		[]string{"exporter"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(exporterRetries)
+	prometheus.MustRegister(exporterPermanentFailures)
+	prometheus.MustRegister(exporterGiveUps)
+}
+
+// RetryPolicy configures how events are retried when a core.ReportingEventSink reports
+// retryable failures. The n-th retry waits InitialBackoff*Multiplier^(n-1), capped at MaxBackoff
+// and randomized by +/- Jitter of itself.
+type RetryPolicy struct {
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Fraction of the backoff between 0 and 1.
+	Jitter float64
+}
+
+var (
+	DefaultRetryPolicy = RetryPolicy{
+		MaxRetries:     3,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+	NoRetryPolicy = RetryPolicy{}
+)
+
+// Backoff returns the time to wait before the given retry, starting with 1.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(retry-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// exportWithRetry exports data and retries the events the sink reports as retryable failures.
+// It returns the events that still fail with a retryable error once the retries are used up.
+func exportWithRetry(s core.EventSink, data *core.EventBatch, policy RetryPolicy) []*kube_api.Event {
+	s, data = unwrapProcessors(s, data)
+	reporting, ok := s.(core.ReportingEventSink)
+	if !ok {
+		export(s, data)
+		return nil
+	}
+
+	for retry := 0; ; retry++ {
+		if retry > 0 {
+			time.Sleep(policy.Backoff(retry))
+			exporterRetries.WithLabelValues(s.Name()).Add(float64(len(data.Events)))
+		}
+
+		retryable, lastErr := exportOnce(reporting, data)
+		if len(retryable) == 0 {
+			return nil
+		}
+		if retry >= policy.MaxRetries {
+			giveUp(s, retryable, retry, lastErr)
+			return retryable
+		}
+		klog.V(2).Infof("Retrying %d events to %s", len(retryable), s.Name())
+		data = &core.EventBatch{
+			Timestamp: data.Timestamp,
+			Events:    retryable,
+		}
+	}
+}
+
+// exportOnce exports data and returns the events that failed with a retryable error and the last
+// of these errors. The permanent failures are logged.
+func exportOnce(s core.ReportingEventSink, data *core.EventBatch) ([]*kube_api.Event, error) {
+	startTime := time.Now()
+	failures := s.ExportEventsWithResult(data)
+	observeExportDuration(s, startTime)
+
+	var lastErr error
+	retryable := []*kube_api.Event{}
+	for _, f := range failures {
+		if f.Retryable {
+			retryable = append(retryable, f.Event)
+			lastErr = f.Err
+			continue
+		}
+		klog.Errorf("Failed to export event to %s: %v", s.Name(), f.Err)
+		exporterPermanentFailures.WithLabelValues(s.Name()).Inc()
+	}
+	return retryable, lastErr
+}
+
+func giveUp(s core.EventSink, events []*kube_api.Event, retries int, lastErr error) {
+	klog.Errorf("Giving up exporting %d events to %s after %d retries, last error: %v",
+		len(events), s.Name(), retries, lastErr)
+	exporterGiveUps.WithLabelValues(s.Name()).Add(float64(len(events)))
+}
+
+// pendingRetry is a batch of events waiting for its retry to the sink behind the processors.
+type pendingRetry struct {
+	sink  core.ReportingEventSink
+	batch *core.EventBatch
+	retry int
+	due   time.Time
+}
+
+// retryQueue retries the events a sink failed to export without blocking the goroutine of the
+// sink in between, so that the sink keeps taking the next batches while the retries wait for
+// their backoff. It holds up to maxEvents events, the events over it are given up.
+type retryQueue struct {
+	sink      core.EventSink
+	policy    RetryPolicy
+	maxEvents int
+	// sorted by due.
+	pending []*pendingRetry
+	events  int
+	now     func() time.Time
+}
+
+func newRetryQueue(sink core.EventSink, policy RetryPolicy, maxEvents int) *retryQueue {
+	return &retryQueue{sink: sink, policy: policy, maxEvents: maxEvents, now: time.Now}
+}
+
+// export exports data and queues the events that failed with a retryable error.
+func (q *retryQueue) export(data *core.EventBatch) {
+	s, data := unwrapProcessors(q.sink, data)
+	reporting, ok := s.(core.ReportingEventSink)
+	if !ok {
+		export(s, data)
+		return
+	}
+	retryable, lastErr := exportOnce(reporting, data)
+	q.schedule(reporting, data, retryable, 0, lastErr)
+}
+
+// due returns a channel receiving once the first retry is due, or nil without retries.
+func (q *retryQueue) due() <-chan time.Time {
+	if len(q.pending) == 0 {
+		return nil
+	}
+	return time.After(q.pending[0].due.Sub(q.now()))
+}
+
+// retryDue exports the batches whose retry is due.
+func (q *retryQueue) retryDue() {
+	now := q.now()
+	for len(q.pending) > 0 && !q.pending[0].due.After(now) {
+		p := q.pending[0]
+		q.pending = q.pending[1:]
+		q.events -= len(p.batch.Events)
+		exporterRetries.WithLabelValues(p.sink.Name()).Add(float64(len(p.batch.Events)))
+		retryable, lastErr := exportOnce(p.sink, p.batch)
+		q.schedule(p.sink, p.batch, retryable, p.retry, lastErr)
+	}
+}
+
+func (q *retryQueue) schedule(s core.ReportingEventSink, data *core.EventBatch, retryable []*kube_api.Event, retry int, lastErr error) {
+	if len(retryable) == 0 {
+		return
+	}
+	if retry >= q.policy.MaxRetries {
+		giveUp(s, retryable, retry, lastErr)
+		return
+	}
+	if q.events+len(retryable) > q.maxEvents {
+		klog.Errorf("Retry queue of %s is full, giving up exporting %d events", s.Name(), len(retryable))
+		exporterGiveUps.WithLabelValues(s.Name()).Add(float64(len(retryable)))
+		return
+	}
+	klog.V(2).Infof("Retrying %d events to %s", len(retryable), s.Name())
+	p := &pendingRetry{
+		sink:  s,
+		batch: &core.EventBatch{Timestamp: data.Timestamp, Events: retryable},
+		retry: retry + 1,
+		due:   q.now().Add(q.policy.Backoff(retry + 1)),
+	}
+	i := sort.Search(len(q.pending), func(i int) bool { return q.pending[i].due.After(p.due) })
+	q.pending = append(q.pending, nil)
+	copy(q.pending[i+1:], q.pending[i:])
+	q.pending[i] = p
+	q.events += len(retryable)
+}
+
+// drop gives up the queued events, e.g. when the sink stops.
+func (q *retryQueue) drop() {
+	if q.events > 0 {
+		klog.Warningf("Dropping %d events queued for retry to %s", q.events, q.sink.Name())
+	}
+	q.pending = nil
+	q.events = 0
+}
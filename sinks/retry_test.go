@@ -0,0 +1,139 @@
+package sinks
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/stretchr/testify/assert"
+	kube_api "k8s.io/api/core/v1"
+)
+
+// flakySink fails every event with the given error for the first failCount exports.
+type flakySink struct {
+	mutex     sync.Mutex
+	err       error
+	failCount int
+	exports   int
+	exported  []*kube_api.Event
+}
+
+func (s *flakySink) Name() string { return "flaky" }
+func (s *flakySink) Stop()        {}
+
+func (s *flakySink) ExportEvents(batch *core.EventBatch) {
+	s.ExportEventsWithResult(batch)
+}
+
+func (s *flakySink) ExportEventsWithResult(batch *core.EventBatch) []core.ExportFailure {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.exports++
+	if s.exports <= s.failCount {
+		failures := []core.ExportFailure{}
+		for _, e := range batch.Events {
+			failures = append(failures, core.NewExportFailure(e, s.err))
+		}
+		return failures
+	}
+	s.exported = append(s.exported, batch.Events...)
+	return nil
+}
+
+func (s *flakySink) getExports() (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.exports, len(s.exported)
+}
+
+var testRetryPolicy = RetryPolicy{
+	MaxRetries:     3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     10 * time.Millisecond,
+	Multiplier:     2,
+}
+
+func newTestBatch() *core.EventBatch {
+	return &core.EventBatch{
+		Timestamp: time.Now(),
+		Events:    []*kube_api.Event{{Message: "a"}, {Message: "b"}},
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+	assert.Equal(t, time.Second, policy.Backoff(1))
+	assert.Equal(t, 2*time.Second, policy.Backoff(2))
+	assert.Equal(t, 4*time.Second, policy.Backoff(3))
+	assert.Equal(t, 5*time.Second, policy.Backoff(4))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		backoff := policy.Backoff(1)
+		assert.True(t, backoff >= 500*time.Millisecond && backoff <= 1500*time.Millisecond, "backoff %s out of range", backoff)
+	}
+}
+
+func TestRetryUntilSuccess(t *testing.T) {
+	sink := &flakySink{err: core.NewRetryableError(errors.New("unavailable")), failCount: 2}
+	failed := exportWithRetry(sink, newTestBatch(), testRetryPolicy)
+	assert.Empty(t, failed)
+	exports, exported := sink.getExports()
+	assert.Equal(t, 3, exports)
+	assert.Equal(t, 2, exported)
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	sink := &flakySink{err: core.NewRetryableError(errors.New("unavailable")), failCount: 10}
+	failed := exportWithRetry(sink, newTestBatch(), testRetryPolicy)
+	assert.Equal(t, 2, len(failed))
+	exports, _ := sink.getExports()
+	assert.Equal(t, 1+testRetryPolicy.MaxRetries, exports)
+}
+
+func TestPermanentFailureIsNotRetried(t *testing.T) {
+	sink := &flakySink{err: errors.New("bad request"), failCount: 10}
+	failed := exportWithRetry(sink, newTestBatch(), testRetryPolicy)
+	assert.Empty(t, failed)
+	exports, _ := sink.getExports()
+	assert.Equal(t, 1, exports)
+}
+
+func TestRetryQueue(t *testing.T) {
+	sink := &flakySink{err: core.NewRetryableError(errors.New("unavailable")), failCount: 2}
+	policy := RetryPolicy{MaxRetries: 3, InitialBackoff: time.Second, Multiplier: 2}
+	q := newRetryQueue(sink, policy, 10)
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	q.export(newTestBatch())
+	assert.NotNil(t, q.due())
+	assert.Equal(t, 2, q.events)
+
+	// nothing is retried before the backoff.
+	q.retryDue()
+	exports, _ := sink.getExports()
+	assert.Equal(t, 1, exports)
+
+	now = now.Add(time.Second)
+	q.retryDue()
+	now = now.Add(2 * time.Second)
+	q.retryDue()
+	exports, exported := sink.getExports()
+	assert.Equal(t, 3, exports)
+	assert.Equal(t, 2, exported)
+	assert.Nil(t, q.due())
+	assert.Equal(t, 0, q.events)
+}
+
+func TestRetryQueueGivesUpWhenFull(t *testing.T) {
+	sink := &flakySink{err: core.NewRetryableError(errors.New("unavailable")), failCount: 10}
+	q := newRetryQueue(sink, testRetryPolicy, 3)
+
+	q.export(newTestBatch())
+	q.export(newTestBatch())
+	assert.Equal(t, 2, q.events)
+	assert.Len(t, q.pending, 1)
+}
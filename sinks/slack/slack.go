@@ -0,0 +1,267 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/sinks/ratelimit"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	SinkName = "SlackSink"
+
+	warningColor = "#E01E5A"
+	normalColor  = "#2EB67D"
+	// Slack rejects texts of a section longer than 3000 characters.
+	maxTextLength = 2900
+)
+
+// Message is an incoming webhook message. The blocks are wrapped in an attachment to color it by event type.
+type Message struct {
+	Channel     string       `json:"channel,omitempty"`
+	Text        string       `json:"text"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+type Attachment struct {
+	Color  string  `json:"color"`
+	Blocks []Block `json:"blocks"`
+}
+
+type Block struct {
+	Type     string `json:"type"`
+	Text     *Text  `json:"text,omitempty"`
+	Fields   []Text `json:"fields,omitempty"`
+	Elements []Text `json:"elements,omitempty"`
+}
+
+type Text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+/*
+slack sink usage
+--sink=slack:https://hooks.slack.com/services/<workspace>/<channel>/<token>?level=Warning&channel=%23alerts&namespace_channel=kube-system:%23infra
+
+level: Normal or Warning. The event level greater than global level will emit.
+label: some thing unique when you want to distinguish different k8s clusters.
+channel: channel to post to instead of the channel of the webhook.
+namespace_channel: <namespace>:<channel>, channel to post the events of a namespace to.
+rate_limit: messages sent per s, m or h, e.g. 1/s. Events over the limit are sent in a digest.
+rate_burst: messages sent at once before rate_limit applies.
+*/
+type SlackSink struct {
+	endpoint          string
+	level             int
+	labels            []string
+	channel           string
+	namespaceChannels map[string]string
+	limiter           *ratelimit.Limiter
+	client            *http.Client
+}
+
+func (s *SlackSink) Name() string {
+	return SinkName
+}
+
+func (s *SlackSink) Stop() {
+	// do nothing
+}
+
+func (s *SlackSink) ExportEvents(batch *core.EventBatch) {
+	for _, failure := range s.ExportEventsWithResult(batch) {
+		klog.Errorf("failed to send event to slack, because of %v", failure.Err)
+	}
+}
+
+func (s *SlackSink) ExportEventsWithResult(batch *core.EventBatch) []core.ExportFailure {
+	var failures []core.ExportFailure
+	for _, event := range batch.Events {
+		if util.GetLevel(event.Type) < s.level {
+			continue
+		}
+		if s.limiter != nil && !s.limiter.Admit(event) {
+			continue
+		}
+		if err := s.send(s.createMsgFromEvent(event)); err != nil {
+			failures = append(failures, core.NewExportFailure(event, err))
+		}
+	}
+	if s.limiter != nil {
+		failures = append(failures, s.sendDigest()...)
+	}
+	return failures
+}
+
+// sendDigest sends the events held back by the rate limit once a message is allowed again.
+func (s *SlackSink) sendDigest() []core.ExportFailure {
+	return s.limiter.SendDigest(func(digest *ratelimit.Digest) error {
+		return s.send(s.createMsgFromDigest(digest))
+	})
+}
+
+func (s *SlackSink) send(msg *Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal msg %v: %v", msg, err)
+	}
+	resp, err := s.client.Post(s.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return core.NewRetryableError(fmt.Errorf("failed to send msg to slack: %v", err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		err := fmt.Errorf("failed to send msg to slack, because the response code is %d, body is: %s", resp.StatusCode, string(respBody))
+		if util.IsRetryableStatusCode(resp.StatusCode) {
+			return core.NewRetryableError(err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *SlackSink) createMsgFromEvent(event *v1.Event) *Message {
+	namespace := event.InvolvedObject.Namespace
+	if namespace == "" {
+		namespace = event.Namespace
+	}
+	channel := s.channel
+	if c, found := s.namespaceChannels[namespace]; found {
+		channel = c
+	}
+
+	blocks := []Block{
+		{
+			Type: "header",
+			Text: &Text{Type: "plain_text", Text: fmt.Sprintf("%s: %s", event.Type, event.Reason)},
+		},
+		{
+			Type: "section",
+			Fields: []Text{
+				markdown(fmt.Sprintf("*Kind*\n%s", escape(event.InvolvedObject.Kind))),
+				markdown(fmt.Sprintf("*Namespace*\n%s", escape(namespace))),
+				markdown(fmt.Sprintf("*Name*\n%s", escape(event.InvolvedObject.Name))),
+				markdown(fmt.Sprintf("*Reason*\n%s", escape(event.Reason))),
+			},
+		},
+		{
+			Type: "section",
+			Text: &Text{Type: "mrkdwn", Text: fmt.Sprintf("*Message*\n%s", escape(truncate(event.Message)))},
+		},
+	}
+	context := []Text{markdown(util.GetLastEventTimestamp(event).Format(time.RFC3339))}
+	if cluster := util.GetClusterName(event, ""); cluster != "" {
+		context = append(context, markdown(fmt.Sprintf("Cluster: %s", escape(cluster))))
+	}
+	if event.Source.Host != "" {
+		context = append(context, markdown(fmt.Sprintf("Node: %s", escape(event.Source.Host))))
+	}
+	for _, label := range s.labels {
+		context = append(context, markdown(escape(label)))
+	}
+	blocks = append(blocks, Block{Type: "context", Elements: context})
+
+	return &Message{
+		Channel: channel,
+		Text: fmt.Sprintf("[%s] %s %s/%s %s: %s", event.Type, event.InvolvedObject.Kind,
+			namespace, event.InvolvedObject.Name, event.Reason, event.Message),
+		Attachments: []Attachment{{Color: color(event.Type), Blocks: blocks}},
+	}
+}
+
+func (s *SlackSink) createMsgFromDigest(digest *ratelimit.Digest) *Message {
+	title := fmt.Sprintf("Rate limit reached, %d events were held back", digest.Total)
+	lines := digest.Lines()
+	for i, line := range lines {
+		lines[i] = "• " + escape(line)
+	}
+	blocks := []Block{
+		{Type: "header", Text: &Text{Type: "plain_text", Text: title}},
+		{Type: "section", Text: &Text{Type: "mrkdwn", Text: truncate(strings.Join(lines, "\n"))}},
+	}
+	if len(s.labels) > 0 {
+		var context []Text
+		for _, label := range s.labels {
+			context = append(context, markdown(escape(label)))
+		}
+		blocks = append(blocks, Block{Type: "context", Elements: context})
+	}
+	return &Message{
+		Channel:     s.channel,
+		Text:        title,
+		Attachments: []Attachment{{Color: warningColor, Blocks: blocks}},
+	}
+}
+
+func markdown(text string) Text {
+	return Text{Type: "mrkdwn", Text: text}
+}
+
+// escape escapes the characters with a meaning in slack texts.
+func escape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+func truncate(text string) string {
+	if runes := []rune(text); len(runes) > maxTextLength {
+		return string(runes[:maxTextLength]) + "..."
+	}
+	return text
+}
+
+func color(eventType string) string {
+	if eventType == v1.EventTypeWarning {
+		return warningColor
+	}
+	return normalColor
+}
+
+func NewSlackSink(uri *url.URL) (*SlackSink, error) {
+	if uri.Host == "" {
+		return nil, fmt.Errorf("you must provide the slack incoming webhook url")
+	}
+	s := &SlackSink{
+		endpoint:          (&url.URL{Scheme: uri.Scheme, Host: uri.Host, Path: uri.Path}).String(),
+		level:             util.GetLevel(v1.EventTypeWarning),
+		namespaceChannels: map[string]string{},
+		client:            &http.Client{Timeout: 10 * time.Second},
+	}
+	opts := uri.Query()
+
+	if len(opts["level"]) >= 1 {
+		s.level = util.GetLevel(opts["level"][0])
+	}
+	if len(opts["label"]) >= 1 {
+		s.labels = opts["label"]
+	}
+	if len(opts["channel"]) >= 1 {
+		s.channel = opts["channel"][0]
+	}
+	for _, namespaceChannel := range opts["namespace_channel"] {
+		parts := strings.SplitN(namespaceChannel, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("namespace_channel must look like <namespace>:<channel>, got %q", namespaceChannel)
+		}
+		s.namespaceChannels[parts[0]] = parts[1]
+	}
+
+	limiter, err := ratelimit.NewLimiter(SinkName, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.limiter = limiter
+
+	return s, nil
+}
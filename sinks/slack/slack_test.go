@@ -0,0 +1,127 @@
+package slack
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+)
+
+func newEvent(eventType, namespace string) *v1.Event {
+	event := &v1.Event{
+		Type:    eventType,
+		Reason:  "BackOff",
+		Message: "Back-off restarting failed container <app>",
+		InvolvedObject: v1.ObjectReference{
+			Kind:      "Pod",
+			Namespace: namespace,
+			Name:      "nginx",
+		},
+	}
+	util.SetClusterName(event, "prod")
+	return event
+}
+
+func TestNewSlackSink(t *testing.T) {
+	uri, _ := url.Parse("https://hooks.slack.com/services/T0/B0/XXX?level=Normal&channel=%23alerts&namespace_channel=kube-system:%23infra&label=abcd")
+	s, err := NewSlackSink(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://hooks.slack.com/services/T0/B0/XXX", s.endpoint)
+	assert.Equal(t, 1, s.level)
+	assert.Equal(t, "#alerts", s.channel)
+	assert.Equal(t, map[string]string{"kube-system": "#infra"}, s.namespaceChannels)
+	assert.Equal(t, []string{"abcd"}, s.labels)
+
+	uri, _ = url.Parse("https://hooks.slack.com/services/T0/B0/XXX?namespace_channel=kube-system")
+	_, err = NewSlackSink(uri)
+	assert.Error(t, err)
+}
+
+func TestCreateMsgFromEvent(t *testing.T) {
+	uri, _ := url.Parse("https://hooks.slack.com/services/T0/B0/XXX?channel=%23alerts&namespace_channel=kube-system:%23infra")
+	s, _ := NewSlackSink(uri)
+
+	msg := s.createMsgFromEvent(newEvent(v1.EventTypeWarning, "default"))
+	assert.Equal(t, "#alerts", msg.Channel)
+	assert.Equal(t, warningColor, msg.Attachments[0].Color)
+	blocks := msg.Attachments[0].Blocks
+	assert.Equal(t, "Warning: BackOff", blocks[0].Text.Text)
+	assert.Equal(t, []Text{
+		markdown("*Kind*\nPod"),
+		markdown("*Namespace*\ndefault"),
+		markdown("*Name*\nnginx"),
+		markdown("*Reason*\nBackOff"),
+	}, blocks[1].Fields)
+	assert.Equal(t, "*Message*\nBack-off restarting failed container &lt;app&gt;", blocks[2].Text.Text)
+	assert.Contains(t, blocks[3].Elements, markdown("Cluster: prod"))
+
+	msg = s.createMsgFromEvent(newEvent(v1.EventTypeNormal, "kube-system"))
+	assert.Equal(t, "#infra", msg.Channel)
+	assert.Equal(t, normalColor, msg.Attachments[0].Color)
+}
+
+func TestExportEvents(t *testing.T) {
+	var messages []Message
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		msg := Message{}
+		assert.NoError(t, json.Unmarshal(body, &msg))
+		messages = append(messages, msg)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	uri, _ := url.Parse(server.URL + "/services/T0/B0/XXX?level=Warning")
+	s, err := NewSlackSink(uri)
+	assert.NoError(t, err)
+
+	batch := &core.EventBatch{Events: []*v1.Event{
+		newEvent(v1.EventTypeWarning, "default"),
+		newEvent(v1.EventTypeNormal, "default"),
+	}}
+	assert.Empty(t, s.ExportEventsWithResult(batch))
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "[Warning] Pod default/nginx BackOff: Back-off restarting failed container <app>", messages[0].Text)
+
+	status = http.StatusTooManyRequests
+	failures := s.ExportEventsWithResult(batch)
+	assert.Len(t, failures, 1)
+	assert.True(t, core.IsRetryable(failures[0].Err))
+
+	status = http.StatusNotFound
+	failures = s.ExportEventsWithResult(batch)
+	assert.Len(t, failures, 1)
+	assert.False(t, core.IsRetryable(failures[0].Err))
+}
+
+func TestExportEventsRateLimit(t *testing.T) {
+	var messages []Message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		msg := Message{}
+		assert.NoError(t, json.Unmarshal(body, &msg))
+		messages = append(messages, msg)
+	}))
+	defer server.Close()
+
+	uri, _ := url.Parse(server.URL + "?rate_limit=1/h&rate_burst=2")
+	s, err := NewSlackSink(uri)
+	assert.NoError(t, err)
+
+	batch := &core.EventBatch{Events: []*v1.Event{
+		newEvent(v1.EventTypeWarning, "default"),
+		newEvent(v1.EventTypeWarning, "kube-system"),
+		newEvent(v1.EventTypeWarning, "kube-public"),
+	}}
+	assert.Empty(t, s.ExportEventsWithResult(batch))
+	// the third event waits for a digest.
+	assert.Len(t, messages, 2)
+}
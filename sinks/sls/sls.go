@@ -20,7 +20,6 @@ import (
 	"net/url"
 	"os"
 	"strconv"
-	"strings"
 
 	"github.com/AliyunContainerService/kube-eventer/core"
 	metrics_core "github.com/AliyunContainerService/kube-eventer/metrics/core"
@@ -257,25 +256,12 @@ func parseConfig(uri *url.URL) (*Config, error) {
 
 	if len(opts["label"]) >= 1 {
 		labelsStrs := opts["label"]
-		c.label = parseLabels(labelsStrs)
+		c.label = util.ParseLabels(labelsStrs)
 	}
 
 	return c, nil
 }
 
-func parseLabels(labelsStrs []string) map[string]string {
-	labels := make(map[string]string)
-	for _, kv := range labelsStrs {
-		kvItems := strings.Split(kv, ",")
-		if len(kvItems) == 2 {
-			labels[kvItems[0]] = kvItems[1]
-		} else {
-			klog.Errorf("parse sls labels error. labelsStr: %v, kv format error: %v", labelsStrs, kv)
-		}
-	}
-	return labels
-}
-
 // newProducer create producer with config and new akInfo.
 func newProducer(c *Config) (*sls_producer.Producer, *utils.AKInfo, error) {
 	// get region from env
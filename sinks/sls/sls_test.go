@@ -23,42 +23,6 @@ func TestParseConfig(t *testing.T) {
 	t.Logf("sls sink config: %v", cfg)
 }
 
-func TestParseLabels(t *testing.T) {
-	testCases := []struct {
-		name     string
-		labels   []string
-		expected map[string]string
-	}{
-		{
-			name:     "labels is empty",
-			labels:   []string{},
-			expected: map[string]string{},
-		},
-		{
-			name:     "invalid labels",
-			labels:   []string{"key,value,other"},
-			expected: map[string]string{},
-		},
-		{
-			name:     "valid labels",
-			labels:   []string{"key,value"},
-			expected: map[string]string{"key": "value"},
-		},
-		{
-			name:     "valid and invalid labels",
-			labels:   []string{"key1,value1", "key2,value2,other"},
-			expected: map[string]string{"key1": "value1"},
-		},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			actual := parseLabels(tc.labels)
-			assert.Equal(t, tc.expected, actual)
-		})
-	}
-}
-
 func TestSLSEventToContents(t *testing.T) {
 	newEvent := &v1.Event{
 		ObjectMeta: metav1.ObjectMeta{
@@ -0,0 +1,488 @@
+package smtp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/common/kubernetes"
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/sinks/batch"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	SinkName = "SMTPSink"
+
+	TLSStartTLS = "starttls"
+	TLSImplicit = "tls"
+	TLSNone     = "none"
+
+	// Events listed one by one in the default html template, the others are only counted in the groups.
+	MaxListedEvents = 50
+
+	dialTimeout = 10 * time.Second
+	// Time to deliver an email, from the greeting of the server to QUIT.
+	sessionTimeout = time.Minute
+)
+
+var (
+	defaultSubjectTemplate = `[kube-eventer] {{ .Total }} events{{ if .Cluster }} in {{ .Cluster }}{{ end }}`
+
+	defaultTextTemplate = `{{ .Text }}
+{{ range .Labels }}
+{{ . }}{{ end }}
+`
+
+	defaultHTMLTemplate = `<html>
+<body style="font-family: sans-serif; font-size: 14px;">
+<h3>{{ .Total }} events in {{ len .Groups }} groups{{ if .Cluster }} in {{ .Cluster }}{{ end }}</h3>
+<table cellpadding="6" style="border-collapse: collapse;">
+<tr style="background: #f0f0f0;"><th align="left">Type</th><th align="left">Namespace</th><th align="left">Reason</th><th align="right">Count</th><th align="left">Messages</th></tr>
+{{ range .Groups }}<tr style="border-top: 1px solid #ddd;">
+<td style="color: {{ if eq .Type "Warning" }}#d93026{{ else }}#1e8e3e{{ end }};">{{ .Type }}</td>
+<td>{{ .Namespace }}</td>
+<td>{{ .Reason }}</td>
+<td align="right">{{ .Count }}</td>
+<td>{{ range .Messages }}{{ .Count }}&times; {{ .Message }}<br>{{ end }}{{ if gt .DistinctMessages (len .Messages) }}... and {{ sub .DistinctMessages (len .Messages) }} more messages{{ end }}</td>
+</tr>
+{{ end }}</table>
+<h4>Events</h4>
+<table cellpadding="6" style="border-collapse: collapse;">
+<tr style="background: #f0f0f0;"><th align="left">Time</th><th align="left">Type</th><th align="left">Object</th><th align="left">Reason</th><th align="left">Message</th></tr>
+{{ range first .Events }}<tr style="border-top: 1px solid #ddd;">
+<td>{{ timestamp . }}</td>
+<td>{{ .Type }}</td>
+<td>{{ .InvolvedObject.Kind }} {{ .InvolvedObject.Namespace }}/{{ .InvolvedObject.Name }}</td>
+<td>{{ .Reason }}</td>
+<td>{{ .Message }}</td>
+</tr>
+{{ end }}</table>
+{{ if gt (len .Events) (len (first .Events)) }}<p>... and {{ sub (len .Events) (len (first .Events)) }} more events</p>{{ end }}
+{{ if .Dropped }}<p>{{ .Dropped }} events were dropped</p>{{ end }}
+{{ range .Labels }}<p style="color: #888;">{{ . }}</p>{{ end }}
+</body>
+</html>
+`
+
+	templateFuncs = map[string]interface{}{
+		// first lists the events shown one by one.
+		"first": func(events []*v1.Event) []*v1.Event {
+			if len(events) > MaxListedEvents {
+				return events[:MaxListedEvents]
+			}
+			return events
+		},
+		"timestamp": func(event *v1.Event) string {
+			return util.GetLastEventTimestamp(event).UTC().Format(time.RFC3339)
+		},
+		"sub": func(a, b int) int {
+			return a - b
+		},
+	}
+)
+
+// Digest is what the subject, text and html templates are rendered with.
+type Digest struct {
+	Total   int
+	Groups  []*batch.Group
+	Events  []*v1.Event
+	Dropped int
+	// The summary as plain text.
+	Text    string
+	Cluster string
+	Labels  []string
+}
+
+/*
+smtp sink usage
+--sink=smtp:smtp://<user>:<password>@smtp.example.com:587?from=kube-eventer@example.com&to=ops@example.com&batch_window=10m
+
+from: address of the sender.
+to: address of a recipient, repeat it for more.
+tls: starttls (default), tls for implicit TLS, often on port 465, or none.
+insecure_skip_verify: true to skip the verification of the certificate of the server.
+level: Normal or Warning. The event level greater than global level will emit.
+label: some thing unique when you want to distinguish different k8s clusters.
+batch_window: duration to collect events for before sending them in one email, e.g. 10m. Without it, an email is sent per exported batch.
+batch_top: messages listed per group (default 3).
+custom_body_configmap: configmap with the subject, text and html templates.
+custom_body_configmap_namespace: namespace of the configmap (default default).
+*/
+type SMTPSink struct {
+	host               string
+	port               string
+	username           string
+	password           string
+	from               string
+	to                 []string
+	tlsMode            string
+	insecureSkipVerify bool
+	level              int
+	labels             []string
+	batcher            *batch.Batcher
+	top                int
+	subjectTemplate    *template.Template
+	textTemplate       *template.Template
+	htmlTemplate       *htmltemplate.Template
+}
+
+func (s *SMTPSink) Name() string {
+	return SinkName
+}
+
+func (s *SMTPSink) Stop() {
+	if s.batcher != nil {
+		// send the events collected for the current window.
+		s.batcher.Stop()
+	}
+}
+
+// HeldSince returns when the oldest event collected for the current window was exported.
+func (s *SMTPSink) HeldSince() time.Time {
+	if s.batcher == nil {
+		return time.Time{}
+	}
+	return s.batcher.HeldSince()
+}
+
+func (s *SMTPSink) ExportEvents(batch *core.EventBatch) {
+	for _, failure := range s.ExportEventsWithResult(batch) {
+		klog.Errorf("failed to send event to smtp, because of %v", failure.Err)
+	}
+}
+
+func (s *SMTPSink) ExportEventsWithResult(eventBatch *core.EventBatch) []core.ExportFailure {
+	var events []*v1.Event
+	for _, event := range eventBatch.Events {
+		if util.GetLevel(event.Type) >= s.level {
+			events = append(events, event)
+		}
+	}
+	if s.batcher != nil {
+		return s.batcher.Export(events)
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	return s.sendSummary(batch.Summarize(events, s.top))
+}
+
+// sendSummary sends the events of a summary in one email.
+func (s *SMTPSink) sendSummary(summary *batch.Summary) []core.ExportFailure {
+	if summary == nil {
+		return nil
+	}
+	msg, err := s.createMsgFromSummary(summary)
+	if err == nil {
+		err = s.send(msg)
+	}
+	if err == nil {
+		return nil
+	}
+	failures := make([]core.ExportFailure, 0, len(summary.Events))
+	for _, event := range summary.Events {
+		failures = append(failures, core.NewExportFailure(event, err))
+	}
+	return failures
+}
+
+func (s *SMTPSink) newDigest(summary *batch.Summary) *Digest {
+	digest := &Digest{
+		Total:   summary.Total(),
+		Groups:  summary.Groups,
+		Events:  summary.Events,
+		Dropped: summary.Dropped,
+		Text:    strings.Join(summary.Lines(), "\n"),
+		Labels:  s.labels,
+	}
+	for _, event := range summary.Events {
+		if cluster := util.GetClusterName(event, ""); cluster != "" {
+			digest.Cluster = cluster
+			break
+		}
+	}
+	return digest
+}
+
+// createMsgFromSummary renders the templates into a multipart email with a text and an html part.
+func (s *SMTPSink) createMsgFromSummary(summary *batch.Summary) ([]byte, error) {
+	digest := s.newDigest(summary)
+	var subject, text, html bytes.Buffer
+	if err := s.subjectTemplate.Execute(&subject, digest); err != nil {
+		return nil, fmt.Errorf("failed to render the subject template: %v", err)
+	}
+	if err := s.textTemplate.Execute(&text, digest); err != nil {
+		return nil, fmt.Errorf("failed to render the text template: %v", err)
+	}
+	if err := s.htmlTemplate.Execute(&html, digest); err != nil {
+		return nil, fmt.Errorf("failed to render the html template: %v", err)
+	}
+
+	var msg bytes.Buffer
+	body := multipart.NewWriter(&msg)
+	header := []string{
+		"From: " + s.from,
+		"To: " + strings.Join(s.to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		fmt.Sprintf("Content-Type: multipart/alternative; boundary=%q", body.Boundary()),
+	}
+	msg.WriteString(strings.Join(header, "\r\n") + "\r\n\r\n")
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write(part.content); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+func (s *SMTPSink) tlsConfig() *tls.Config {
+	return &tls.Config{
+		ServerName:         s.host,
+		InsecureSkipVerify: s.insecureSkipVerify,
+	}
+}
+
+func (s *SMTPSink) send(msg []byte) error {
+	addr := net.JoinHostPort(s.host, s.port)
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	var err error
+	if s.tlsMode == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, s.tlsConfig())
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return core.NewRetryableError(fmt.Errorf("failed to connect to smtp server %s: %v", addr, err))
+	}
+	conn.SetDeadline(time.Now().Add(sessionTimeout))
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return classify("failed to greet smtp server", err)
+	}
+	defer c.Close()
+
+	if s.tlsMode == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server %s does not support STARTTLS, set tls=tls or tls=none", addr)
+		}
+		if err := c.StartTLS(s.tlsConfig()); err != nil {
+			return classify("failed to start tls", err)
+		}
+	}
+	if s.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return classify("failed to authenticate", err)
+		}
+	}
+	if err := c.Mail(s.from); err != nil {
+		return classify("failed to set the sender", err)
+	}
+	for _, to := range s.to {
+		if err := c.Rcpt(to); err != nil {
+			return classify(fmt.Sprintf("failed to add the recipient %s", to), err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return classify("failed to send the email", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return classify("failed to send the email", err)
+	}
+	if err := w.Close(); err != nil {
+		return classify("failed to send the email", err)
+	}
+	return c.Quit()
+}
+
+// classify marks the transient errors as retryable: the 4xx replies of the server, network errors
+// and connections closed by the server. Everything else is permanent, e.g. the 5xx replies or
+// authenticating over an unencrypted connection.
+func classify(msg string, err error) error {
+	err = fmt.Errorf("%s: %w", msg, err)
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		if reply.Code >= 400 && reply.Code < 500 {
+			return core.NewRetryableError(err)
+		}
+		return err
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return core.NewRetryableError(err)
+	}
+	return err
+}
+
+// parseTemplates parses the subject, text and html templates, an empty one is left to its default.
+func (s *SMTPSink) parseTemplates(subject, text, html string) error {
+	if subject == "" {
+		subject = defaultSubjectTemplate
+	}
+	if text == "" {
+		text = defaultTextTemplate
+	}
+	if html == "" {
+		html = defaultHTMLTemplate
+	}
+	var err error
+	if s.subjectTemplate, err = template.New("subject").Funcs(templateFuncs).Parse(subject); err != nil {
+		return fmt.Errorf("failed to parse the subject template: %v", err)
+	}
+	if s.textTemplate, err = template.New("text").Funcs(templateFuncs).Parse(text); err != nil {
+		return fmt.Errorf("failed to parse the text template: %v", err)
+	}
+	if s.htmlTemplate, err = htmltemplate.New("html").Funcs(templateFuncs).Parse(html); err != nil {
+		return fmt.Errorf("failed to parse the html template: %v", err)
+	}
+	return nil
+}
+
+// loadTemplates reads the subject, text and html keys of the configmap. The defaults are used
+// if the configmap can't be read, as the webhook sink does.
+func loadTemplates(name, namespace string) (subject, text, html string) {
+	client, err := kubernetes.GetKubernetesClient(nil)
+	if err != nil {
+		klog.Warningf("Failed to get kubernetes client and use default templates instead")
+		return "", "", ""
+	}
+	configmap, err := client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		klog.Warningf("Failed to get configMap %s in namespace %s and use default templates instead,because of %v", name, namespace, err)
+		return "", "", ""
+	}
+	return configmap.Data["subject"], configmap.Data["text"], configmap.Data["html"]
+}
+
+func NewSMTPSink(uri *url.URL) (*SMTPSink, error) {
+	if uri.Hostname() == "" {
+		return nil, fmt.Errorf("you must provide the host of the smtp server")
+	}
+	s := &SMTPSink{
+		host:    uri.Hostname(),
+		port:    uri.Port(),
+		tlsMode: TLSStartTLS,
+		level:   util.GetLevel(v1.EventTypeWarning),
+		top:     batch.DefaultTop,
+	}
+	if uri.User != nil {
+		s.username = uri.User.Username()
+		s.password, _ = uri.User.Password()
+	}
+	opts := uri.Query()
+
+	if len(opts["tls"]) >= 1 {
+		switch opts["tls"][0] {
+		case TLSStartTLS, TLSImplicit, TLSNone:
+			s.tlsMode = opts["tls"][0]
+		default:
+			return nil, fmt.Errorf("tls must be starttls, tls or none, got %q", opts["tls"][0])
+		}
+	} else if s.port == "465" {
+		s.tlsMode = TLSImplicit
+	}
+	if s.port == "" {
+		switch s.tlsMode {
+		case TLSImplicit:
+			s.port = "465"
+		case TLSStartTLS:
+			s.port = "587"
+		default:
+			s.port = "25"
+		}
+	}
+	if len(opts["insecure_skip_verify"]) >= 1 {
+		skip, err := strconv.ParseBool(opts["insecure_skip_verify"][0])
+		if err != nil {
+			return nil, fmt.Errorf("insecure_skip_verify must be true or false, got %q", opts["insecure_skip_verify"][0])
+		}
+		s.insecureSkipVerify = skip
+	}
+
+	if len(opts["from"]) < 1 || opts["from"][0] == "" {
+		return nil, fmt.Errorf("you must provide the from address of the emails")
+	}
+	s.from = opts["from"][0]
+	for _, to := range opts["to"] {
+		if to != "" {
+			s.to = append(s.to, to)
+		}
+	}
+	if len(s.to) == 0 {
+		return nil, fmt.Errorf("you must provide at least one to address of the emails")
+	}
+
+	if len(opts["level"]) >= 1 {
+		s.level = util.GetLevel(opts["level"][0])
+	}
+	if len(opts["label"]) >= 1 {
+		s.labels = opts["label"]
+	}
+
+	batcher, err := batch.NewBatcher(opts, s.sendSummary)
+	if err != nil {
+		return nil, err
+	}
+	s.batcher = batcher
+	if len(opts["batch_top"]) >= 1 {
+		// validated by the batcher.
+		s.top, _ = strconv.Atoi(opts["batch_top"][0])
+	}
+
+	var subject, text, html string
+	if len(opts["custom_body_configmap"]) >= 1 {
+		namespace := "default"
+		if len(opts["custom_body_configmap_namespace"]) >= 1 {
+			namespace = opts["custom_body_configmap_namespace"][0]
+		}
+		subject, text, html = loadTemplates(opts["custom_body_configmap"][0], namespace)
+	}
+	if err := s.parseTemplates(subject, text, html); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
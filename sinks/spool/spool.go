@@ -0,0 +1,397 @@
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/prometheus/client_golang/prometheus"
+	kube_api "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	segmentSuffix = ".log"
+	cursorSuffix  = ".cursor"
+
+	// Upper bound of a single segment file. Segments are the unit of size and age based removal.
+	maxSegmentSize = 16 * 1024 * 1024
+	// Lower bound of a single segment file, so a tiny size cap does not produce a segment per batch.
+	minSegmentSize = 64 * 1024
+)
+
+var (
+	// Bytes currently kept in the spool directory.
+	spoolSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "eventer",
+			Subsystem: "spool",
+			Name:      "size_bytes",
+			Help:      "Bytes currently kept in the spool directory.",
+		})
+	// Segments removed by the size or age cap before the sink read them.
+	spoolSkippedSegments = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "eventer",
+			Subsystem: "spool",
+			Name:      "skipped_segments_total",
+			Help:      "Segments removed by the size or age cap before the sink read them.",
+		},
+		[]string{"exporter"},
+	)
+
+	invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+)
+
+func init() {
+	prometheus.MustRegister(spoolSize)
+	prometheus.MustRegister(spoolSkippedSegments)
+}
+
+// record is the on-disk representation of one EventBatch. Each record is a single line.
+type record struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Events    []*kube_api.Event `json:"events"`
+}
+
+type segment struct {
+	id      uint64
+	size    int64
+	modTime time.Time
+}
+
+// Cursor is the position of a reader in the spool.
+type Cursor struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// Spool is a write-ahead log of event batches kept in a local directory.
+// Batches are appended to segment files and every sink reads them back through
+// its own Reader, whose position survives restarts.
+type Spool struct {
+	dir         string
+	maxSize     int64
+	maxAge      time.Duration
+	segmentSize int64
+
+	lock     sync.Mutex
+	segments []*segment
+	active   *os.File
+}
+
+// Open opens the spool in dir, creating it if needed. maxSize caps the bytes kept on disk and
+// maxAge caps how long a segment is kept; zero disables the respective cap.
+func Open(dir string, maxSize int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory %s: %v", dir, err)
+	}
+	s := &Spool{
+		dir:         dir,
+		maxSize:     maxSize,
+		maxAge:      maxAge,
+		segmentSize: maxSegmentSize,
+	}
+	if maxSize > 0 {
+		s.segmentSize = maxSize / 4
+		if s.segmentSize > maxSegmentSize {
+			s.segmentSize = maxSegmentSize
+		}
+		if s.segmentSize < minSegmentSize {
+			s.segmentSize = minSegmentSize
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), segmentSuffix), 10, 64)
+		if err != nil {
+			klog.Warningf("Ignoring unknown file %s in spool directory", f.Name())
+			continue
+		}
+		s.segments = append(s.segments, &segment{id: id, size: f.Size(), modTime: f.ModTime()})
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].id < s.segments[j].id })
+
+	if len(s.segments) == 0 {
+		if err := s.rotate(); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := s.openActive(); err != nil {
+			return nil, err
+		}
+	}
+	s.trim()
+	s.updateSize()
+
+	klog.Infof("Opened event spool %s with %d segments", dir, len(s.segments))
+	return s, nil
+}
+
+// Append writes the batch to the spool. The batch is on disk once Append returns.
+// Empty batches are written too, they are the clock of sinks that flush periodically.
+func (s *Spool) Append(batch *core.EventBatch) error {
+	data, err := json.Marshal(&record{Timestamp: batch.Timestamp, Events: batch.Events})
+	if err != nil {
+		return fmt.Errorf("failed to encode event batch: %v", err)
+	}
+	data = append(data, '\n')
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if last := s.last(); last.size > 0 && last.size+int64(len(data)) > s.segmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.active.Write(data); err != nil {
+		return fmt.Errorf("failed to write to spool: %v", err)
+	}
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("failed to sync spool: %v", err)
+	}
+	last := s.last()
+	last.size += int64(len(data))
+	last.modTime = time.Now()
+
+	s.trim()
+	s.updateSize()
+	return nil
+}
+
+// Close closes the active segment.
+func (s *Spool) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.active.Close()
+}
+
+// NewReader returns the reader identified by name, positioned at its persisted cursor.
+// A reader seen for the first time starts at the end of the spool.
+func (s *Spool) NewReader(name string) (*Reader, error) {
+	r := &Reader{
+		spool: s,
+		name:  name,
+		path:  filepath.Join(s.dir, invalidNameChars.ReplaceAllString(name, "_")+cursorSuffix),
+	}
+	data, err := ioutil.ReadFile(r.path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &r.cursor); err != nil {
+			return nil, fmt.Errorf("failed to decode cursor %s: %v", r.path, err)
+		}
+	case os.IsNotExist(err):
+		s.lock.Lock()
+		last := s.last()
+		r.next = Cursor{Segment: last.id, Offset: last.size}
+		s.lock.Unlock()
+		if err := r.Commit(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	r.next = r.cursor
+	return r, nil
+}
+
+func (s *Spool) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, segmentSuffix))
+}
+
+func (s *Spool) last() *segment {
+	return s.segments[len(s.segments)-1]
+}
+
+// rotate closes the active segment and starts a new one.
+func (s *Spool) rotate() error {
+	var id uint64 = 1
+	if len(s.segments) > 0 {
+		id = s.last().id + 1
+	}
+	if s.active != nil {
+		s.active.Close()
+	}
+	f, err := os.OpenFile(s.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %v", err)
+	}
+	s.active = f
+	s.segments = append(s.segments, &segment{id: id, modTime: time.Now()})
+	return nil
+}
+
+// openActive reopens the newest segment for appending. A record that was only partially
+// written before a crash is cut off so that new records start on a fresh line.
+func (s *Spool) openActive() error {
+	last := s.last()
+	path := s.segmentPath(last.id)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if end := int64(bytes.LastIndexByte(data, '\n') + 1); end != int64(len(data)) {
+		klog.Warningf("Truncating partially written record at offset %d of %s", end, path)
+		if err := os.Truncate(path, end); err != nil {
+			return err
+		}
+		last.size = end
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.active = f
+	return nil
+}
+
+// trim removes the oldest segments until the spool fits into its size and age caps.
+// The active segment is never removed.
+func (s *Spool) trim() {
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+	for len(s.segments) > 1 {
+		oldest := s.segments[0]
+		expired := s.maxAge > 0 && time.Since(oldest.modTime) > s.maxAge
+		oversize := s.maxSize > 0 && total > s.maxSize
+		if !expired && !oversize {
+			return
+		}
+		if err := os.Remove(s.segmentPath(oldest.id)); err != nil && !os.IsNotExist(err) {
+			klog.Errorf("Failed to remove spool segment %d: %v", oldest.id, err)
+			return
+		}
+		klog.V(2).Infof("Removed spool segment %d (expired: %t, oversize: %t)", oldest.id, expired, oversize)
+		total -= oldest.size
+		s.segments = s.segments[1:]
+	}
+}
+
+func (s *Spool) updateSize() {
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+	spoolSize.Set(float64(total))
+}
+
+// Reader reads batches from the spool for one sink.
+type Reader struct {
+	spool *Spool
+	name  string
+	path  string
+	// Position of the first batch not yet committed.
+	cursor Cursor
+	// Position after the batch returned by the last call to Next.
+	next Cursor
+}
+
+// Next returns the batch after the last one returned, or nil if the reader caught up with the spool.
+// The position only survives a restart once Commit is called.
+func (r *Reader) Next() (*core.EventBatch, error) {
+	for {
+		r.spool.lock.Lock()
+		first, last := r.spool.segments[0].id, r.spool.last().id
+		r.spool.lock.Unlock()
+		if r.next.Segment < first {
+			skipped := first - r.next.Segment
+			klog.Warningf("Spool reader %s fell behind the spool caps, skipping %d removed segments", r.name, skipped)
+			spoolSkippedSegments.WithLabelValues(r.name).Add(float64(skipped))
+			r.next = Cursor{Segment: first}
+		}
+
+		line, err := r.readLine(r.next)
+		if err != nil {
+			return nil, err
+		}
+		if line == nil {
+			if r.next.Segment >= last {
+				return nil, nil
+			}
+			// Reached the end of a finished segment, continue with the following one.
+			r.next = Cursor{Segment: r.next.Segment + 1}
+			continue
+		}
+
+		rec := record{}
+		offset := r.next.Offset + int64(len(line))
+		if err := json.Unmarshal(line, &rec); err != nil {
+			klog.Errorf("Skipping corrupted record at offset %d of spool segment %d: %v", r.next.Offset, r.next.Segment, err)
+			r.next.Offset = offset
+			continue
+		}
+		r.next.Offset = offset
+		return &core.EventBatch{Timestamp: rec.Timestamp, Events: rec.Events}, nil
+	}
+}
+
+// readLine returns the complete record starting at c, or nil if there is none yet.
+func (r *Reader) readLine(c Cursor) ([]byte, error) {
+	f, err := os.Open(r.spool.segmentPath(c.Segment))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(c.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err == io.EOF {
+		// Nothing or only a partially written record.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return line, nil
+}
+
+// Position returns the position after the batch returned by the last call to Next.
+func (r *Reader) Position() Cursor {
+	return r.next
+}
+
+// Commit persists the position after the batch returned by the last call to Next.
+func (r *Reader) Commit() error {
+	return r.CommitAt(r.next)
+}
+
+// CommitAt persists a position returned by Position, e.g. to commit only up to a batch a sink
+// is done with.
+func (r *Reader) CommitAt(c Cursor) error {
+	r.cursor = c
+	data, err := json.Marshal(&r.cursor)
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cursor of %s: %v", r.name, err)
+	}
+	return os.Rename(tmp, r.path)
+}
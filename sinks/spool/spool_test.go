@@ -0,0 +1,145 @@
+package spool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/stretchr/testify/assert"
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newBatch(messages ...string) *core.EventBatch {
+	batch := &core.EventBatch{Timestamp: time.Now()}
+	for _, m := range messages {
+		batch.Events = append(batch.Events, &kube_api.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: m},
+			Message:    m,
+		})
+	}
+	return batch
+}
+
+func TestReaderCursorSurvivesReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, 0, 0)
+	assert.NoError(t, err)
+	r, err := s.NewReader("s1")
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.Append(newBatch("a", "b")))
+	assert.NoError(t, s.Append(newBatch("c")))
+
+	batch, err := r.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(batch.Events))
+	assert.Equal(t, "b", batch.Events[1].Message)
+	assert.NoError(t, r.Commit())
+
+	// The second batch is read but not committed, so it is replayed after a restart.
+	batch, err = r.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "c", batch.Events[0].Message)
+	assert.NoError(t, s.Close())
+
+	s, err = Open(dir, 0, 0)
+	assert.NoError(t, err)
+	r, err = s.NewReader("s1")
+	assert.NoError(t, err)
+	batch, err = r.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "c", batch.Events[0].Message)
+	batch, err = r.Next()
+	assert.NoError(t, err)
+	assert.Nil(t, batch)
+}
+
+func TestNewReaderStartsAtEnd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, 0, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Append(newBatch("old")))
+
+	r, err := s.NewReader("late sink")
+	assert.NoError(t, err)
+	batch, err := r.Next()
+	assert.NoError(t, err)
+	assert.Nil(t, batch)
+
+	assert.NoError(t, s.Append(newBatch("new")))
+	batch, err = r.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "new", batch.Events[0].Message)
+}
+
+func TestPartialRecordIsTruncated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, 0, 0)
+	assert.NoError(t, err)
+	r, err := s.NewReader("s1")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Append(newBatch("a")))
+	assert.NoError(t, s.Close())
+
+	// Simulate a crash in the middle of a write.
+	f, err := os.OpenFile(filepath.Join(dir, "00000000000000000001.log"), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"timestamp":"2020-`)
+	assert.NoError(t, err)
+	f.Close()
+
+	s, err = Open(dir, 0, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Append(newBatch("b")))
+	r, err = s.NewReader("s1")
+	assert.NoError(t, err)
+
+	batch, err := r.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "a", batch.Events[0].Message)
+	batch, err = r.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "b", batch.Events[0].Message)
+}
+
+func TestSizeCapSkipsRemovedSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, 2*minSegmentSize, 0)
+	assert.NoError(t, err)
+	r, err := s.NewReader("s1")
+	assert.NoError(t, err)
+
+	message := string(make([]byte, minSegmentSize/4))
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, s.Append(newBatch(message)))
+	}
+	assert.True(t, len(s.segments) <= 3, "spool should be trimmed, got %d segments", len(s.segments))
+	assert.True(t, s.segments[0].id > 1)
+
+	read := 0
+	for {
+		batch, err := r.Next()
+		assert.NoError(t, err)
+		if batch == nil {
+			break
+		}
+		read++
+	}
+	assert.True(t, read > 0 && read < 20, "reader should skip removed segments, read %d batches", read)
+}
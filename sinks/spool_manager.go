@@ -0,0 +1,217 @@
+package sinks
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/sinks/spool"
+	"k8s.io/klog/v2"
+)
+
+const (
+	minHoldBackInterval = time.Second
+)
+
+type spooledSinkHolder struct {
+	sink          core.EventSink
+	retryPolicy   RetryPolicy
+	reader        *spool.Reader
+	notifyChannel chan struct{}
+	stopChannel   chan bool
+	// Batches exported but not committed yet, as the sink still holds some of their events.
+	uncommitted []exportedBatch
+}
+
+// exportedBatch is the position after a batch read from the spool and when it was exported.
+type exportedBatch struct {
+	position   spool.Cursor
+	exportedAt time.Time
+}
+
+// Spooled Sink Manager - a sink manager that writes every batch to a local spool first.
+// Each sink reads the spool through its own cursor, so a slow or unavailable sink falls
+// behind and catches up later instead of losing batches, also across restarts. Events that
+// a core.ReportingEventSink keeps failing to export with a retryable error hold the cursor
+// back until the sink recovers, and so do the events a core.HoldingEventSink has not sent yet.
+type spooledSinkManager struct {
+	spool       *spool.Spool
+	sinkHolders []*spooledSinkHolder
+	stopTimeout time.Duration
+}
+
+func NewSpooledEventSinkManager(sinks []core.EventSink, sp *spool.Spool, stopTimeout time.Duration, retryPolicy RetryPolicy) (core.EventSink, error) {
+	sinkHolders := []*spooledSinkHolder{}
+	names := map[string]int{}
+	for _, sink := range sinks {
+		// Several sinks of the same type share a name, the cursor has to be unique.
+		name := sink.Name()
+		if n := names[sink.Name()]; n > 0 {
+			name = fmt.Sprintf("%s-%d", sink.Name(), n)
+		}
+		names[sink.Name()]++
+
+		reader, err := sp.NewReader(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create spool reader for %s: %v", name, err)
+		}
+		sh := &spooledSinkHolder{
+			sink:          sink,
+			retryPolicy:   retryPolicy,
+			reader:        reader,
+			notifyChannel: make(chan struct{}, 1),
+			stopChannel:   make(chan bool),
+		}
+		sinkHolders = append(sinkHolders, sh)
+		go func(sh *spooledSinkHolder) {
+			for {
+				if stopped := drain(sh); stopped {
+					return
+				}
+				select {
+				case <-sh.notifyChannel:
+				case isStop := <-sh.stopChannel:
+					klog.V(2).Infof("Stop received: %s", sh.sink.Name())
+					if isStop {
+						sh.sink.Stop()
+						sh.commit()
+						return
+					}
+				}
+			}
+		}(sh)
+	}
+	return &spooledSinkManager{
+		spool:       sp,
+		sinkHolders: sinkHolders,
+		stopTimeout: stopTimeout,
+	}, nil
+}
+
+// drain exports everything the sink has not read from the spool yet.
+// It returns true if the sink was stopped meanwhile.
+func drain(sh *spooledSinkHolder) bool {
+	for {
+		if stopped := sh.waitStop(0); stopped {
+			return true
+		}
+
+		data, err := sh.reader.Next()
+		if err != nil {
+			klog.Errorf("Failed to read spool for sink %s: %v", sh.sink.Name(), err)
+			return false
+		}
+		if data == nil {
+			return false
+		}
+		// Held back events are exported again without running the processors of the sink again.
+		sink, data := unwrapProcessors(sh.sink, data)
+		for failed := exportWithRetry(sink, data, sh.retryPolicy); len(failed) > 0; {
+			klog.Warningf("Sink %s is unavailable, holding back %d events", sh.sink.Name(), len(failed))
+			if stopped := sh.waitStop(sh.holdBackInterval()); stopped {
+				return true
+			}
+			failed = exportWithRetry(sink, &core.EventBatch{Timestamp: data.Timestamp, Events: failed}, sh.retryPolicy)
+		}
+		sh.uncommitted = append(sh.uncommitted, exportedBatch{position: sh.reader.Position(), exportedAt: time.Now()})
+		sh.commit()
+	}
+}
+
+// commit persists the position after the last exported batch none of whose events the sink holds.
+func (sh *spooledSinkHolder) commit() {
+	since := heldSince(sh.sink)
+	n := 0
+	for n < len(sh.uncommitted) && (since.IsZero() || sh.uncommitted[n].exportedAt.Before(since)) {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	position := sh.uncommitted[n-1].position
+	sh.uncommitted = sh.uncommitted[n:]
+	if err := sh.reader.CommitAt(position); err != nil {
+		klog.Errorf("Failed to commit spool cursor of sink %s: %v", sh.sink.Name(), err)
+	}
+}
+
+// heldSince returns when the oldest event a core.HoldingEventSink holds was exported to it, or the
+// zero time if it holds none.
+func heldSince(s core.EventSink) time.Time {
+	switch ps := s.(type) {
+	case *processedSink:
+		s = ps.EventSink
+	case *processedReportingSink:
+		s = ps.EventSink
+	}
+	if holding, ok := s.(core.HoldingEventSink); ok {
+		return holding.HeldSince()
+	}
+	return time.Time{}
+}
+
+// waitStop waits up to timeout for a stop request and stops the sink if one arrives.
+// It returns true if the sink was stopped.
+func (sh *spooledSinkHolder) waitStop(timeout time.Duration) bool {
+	var isStop bool
+	if timeout > 0 {
+		select {
+		case isStop = <-sh.stopChannel:
+		case <-time.After(timeout):
+			return false
+		}
+	} else {
+		select {
+		case isStop = <-sh.stopChannel:
+		default:
+			return false
+		}
+	}
+	klog.V(2).Infof("Stop received: %s", sh.sink.Name())
+	if isStop {
+		sh.sink.Stop()
+		sh.commit()
+	}
+	return isStop
+}
+
+// holdBackInterval is the time to wait before exporting held back events again.
+func (sh *spooledSinkHolder) holdBackInterval() time.Duration {
+	if sh.retryPolicy.MaxBackoff > minHoldBackInterval {
+		return sh.retryPolicy.MaxBackoff
+	}
+	return minHoldBackInterval
+}
+
+// ExportEvents writes the batch to the spool and wakes up the sinks. It does not wait for them.
+func (this *spooledSinkManager) ExportEvents(data *core.EventBatch) {
+	if err := this.spool.Append(data); err != nil {
+		klog.Errorf("Failed to write %d events to spool: %v", len(data.Events), err)
+	}
+	for _, sh := range this.sinkHolders {
+		select {
+		case sh.notifyChannel <- struct{}{}:
+		default:
+			// The sink is busy and will read the spool again anyway.
+		}
+	}
+}
+
+func (this *spooledSinkManager) Name() string {
+	return "Spooled Manager"
+}
+
+func (this *spooledSinkManager) Stop() {
+	for _, sh := range this.sinkHolders {
+		klog.V(2).Infof("Running stop for: %s", sh.sink.Name())
+
+		go func(sh *spooledSinkHolder) {
+			select {
+			case sh.stopChannel <- true:
+				klog.V(2).Infof("Stop sent to sink: %s", sh.sink.Name())
+			case <-time.After(this.stopTimeout):
+				klog.Warningf("Failed to stop sink: %s", sh.sink.Name())
+			}
+		}(sh)
+	}
+}
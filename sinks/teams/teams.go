@@ -0,0 +1,302 @@
+package teams
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/common/filters"
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/processors"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	SinkName = "TeamsSink"
+
+	adaptiveCardContentType = "application/vnd.microsoft.card.adaptive"
+	adaptiveCardSchema      = "http://adaptivecards.io/schemas/adaptive-card.json"
+	adaptiveCardVersion     = "1.4"
+
+	// Teams rejects messages larger than 28 KB.
+	defaultMaxPayloadSize = 28000
+	// Messages longer than this are cut so that a single event fits in a message.
+	maxMessageLength = 2000
+)
+
+// sinkOptions are the options of the sink, which are not sent to Teams. Nor are the filter rules
+// and the dedup_window applied to all sinks.
+var sinkOptions = []string{"label", "max_payload_size"}
+
+// Message is the payload of incoming webhooks and Workflows, a message with an adaptive card attached.
+type Message struct {
+	Type        string       `json:"type"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+type Attachment struct {
+	ContentType string       `json:"contentType"`
+	Content     AdaptiveCard `json:"content"`
+}
+
+type AdaptiveCard struct {
+	Schema  string        `json:"$schema"`
+	Type    string        `json:"type"`
+	Version string        `json:"version"`
+	Body    []CardElement `json:"body"`
+	MSTeams *MSTeams      `json:"msteams,omitempty"`
+}
+
+type MSTeams struct {
+	Width string `json:"width"`
+}
+
+// CardElement is a container, text block or fact set of a card.
+type CardElement struct {
+	Type      string        `json:"type"`
+	Text      string        `json:"text,omitempty"`
+	Weight    string        `json:"weight,omitempty"`
+	Size      string        `json:"size,omitempty"`
+	Color     string        `json:"color,omitempty"`
+	Wrap      bool          `json:"wrap,omitempty"`
+	Style     string        `json:"style,omitempty"`
+	Separator bool          `json:"separator,omitempty"`
+	Items     []CardElement `json:"items,omitempty"`
+	Facts     []Fact        `json:"facts,omitempty"`
+}
+
+type Fact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+/*
+teams sink usage
+--sink=teams:https://<tenant>.webhook.office.com/webhookb2/<id>?level=Warning&label=<label>
+
+level: Normal or Warning. The event level greater than global level will emit.
+label: some thing unique when you want to distinguish different k8s clusters.
+max_payload_size: bytes of a message before the events are split into several messages (default 28000).
+*/
+type TeamsSink struct {
+	endpoint       string
+	level          int
+	labels         []string
+	maxPayloadSize int
+	client         *http.Client
+}
+
+func (t *TeamsSink) Name() string {
+	return SinkName
+}
+
+func (t *TeamsSink) Stop() {
+	// do nothing
+}
+
+func (t *TeamsSink) ExportEvents(batch *core.EventBatch) {
+	for _, failure := range t.ExportEventsWithResult(batch) {
+		klog.Errorf("failed to send event to teams, because of %v", failure.Err)
+	}
+}
+
+func (t *TeamsSink) ExportEventsWithResult(batch *core.EventBatch) []core.ExportFailure {
+	var events []*v1.Event
+	for _, event := range batch.Events {
+		if util.GetLevel(event.Type) >= t.level {
+			events = append(events, event)
+		}
+	}
+
+	var failures []core.ExportFailure
+	for _, chunk := range t.split(events) {
+		if err := t.send(chunk.payload); err != nil {
+			for _, event := range chunk.events {
+				failures = append(failures, core.NewExportFailure(event, err))
+			}
+		}
+	}
+	return failures
+}
+
+// chunk is a message with some of the events of a batch.
+type chunk struct {
+	events  []*v1.Event
+	payload []byte
+}
+
+// split renders the events as a card per message, each event in a container, and starts a new
+// message before one gets larger than the maximum payload size.
+func (t *TeamsSink) split(events []*v1.Event) []chunk {
+	var chunks []chunk
+	var current []*v1.Event
+	var containers []CardElement
+	// the size of a message without containers.
+	envelope, _ := json.Marshal(newMessage(nil))
+	size := len(envelope)
+
+	flush := func() {
+		if len(containers) == 0 {
+			return
+		}
+		payload, err := json.Marshal(newMessage(containers))
+		if err != nil {
+			klog.Errorf("failed to marshal teams message: %v", err)
+			return
+		}
+		chunks = append(chunks, chunk{events: current, payload: payload})
+		current = nil
+		containers = nil
+		size = len(envelope)
+	}
+
+	for _, event := range events {
+		container := t.createContainerFromEvent(event, len(containers) > 0)
+		data, err := json.Marshal(container)
+		if err != nil {
+			klog.Errorf("failed to marshal event %v: %v", event, err)
+			continue
+		}
+		// one more for the comma between containers.
+		if size+len(data)+1 > t.maxPayloadSize {
+			flush()
+			container = t.createContainerFromEvent(event, false)
+		}
+		current = append(current, event)
+		containers = append(containers, container)
+		size += len(data) + 1
+	}
+	flush()
+	return chunks
+}
+
+func newMessage(body []CardElement) *Message {
+	if body == nil {
+		body = []CardElement{}
+	}
+	return &Message{
+		Type: "message",
+		Attachments: []Attachment{{
+			ContentType: adaptiveCardContentType,
+			Content: AdaptiveCard{
+				Schema:  adaptiveCardSchema,
+				Type:    "AdaptiveCard",
+				Version: adaptiveCardVersion,
+				Body:    body,
+				MSTeams: &MSTeams{Width: "Full"},
+			},
+		}},
+	}
+}
+
+func (t *TeamsSink) createContainerFromEvent(event *v1.Event, separator bool) CardElement {
+	namespace := event.InvolvedObject.Namespace
+	if namespace == "" {
+		namespace = event.Namespace
+	}
+	facts := []Fact{
+		{Title: "Level", Value: event.Type},
+		{Title: "Kind", Value: event.InvolvedObject.Kind},
+		{Title: "Namespace", Value: namespace},
+		{Title: "Name", Value: event.InvolvedObject.Name},
+		{Title: "Reason", Value: event.Reason},
+		{Title: "Timestamp", Value: util.GetLastEventTimestamp(event).Format(time.DateTime)},
+	}
+	if event.Count > 1 {
+		facts = append(facts, Fact{Title: "Count", Value: strconv.Itoa(int(event.Count))})
+	}
+	if event.Source.Host != "" {
+		facts = append(facts, Fact{Title: "Node", Value: event.Source.Host})
+	}
+	if cluster := util.GetClusterName(event, ""); cluster != "" {
+		facts = append(facts, Fact{Title: "Cluster", Value: cluster})
+	}
+	for _, label := range t.labels {
+		facts = append(facts, Fact{Title: "Label", Value: label})
+	}
+
+	message := event.Message
+	if runes := []rune(message); len(runes) > maxMessageLength {
+		message = string(runes[:maxMessageLength]) + "..."
+	}
+
+	color := "Good"
+	if event.Type == v1.EventTypeWarning {
+		color = "Attention"
+	}
+	return CardElement{
+		Type:      "Container",
+		Separator: separator,
+		Items: []CardElement{
+			{
+				Type:   "TextBlock",
+				Text:   fmt.Sprintf("%s %s/%s: %s", event.InvolvedObject.Kind, namespace, event.InvolvedObject.Name, event.Reason),
+				Weight: "Bolder",
+				Size:   "Medium",
+				Color:  color,
+				Wrap:   true,
+			},
+			{Type: "FactSet", Facts: facts},
+			{Type: "TextBlock", Text: message, Wrap: true},
+		},
+	}
+}
+
+func (t *TeamsSink) send(payload []byte) error {
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return core.NewRetryableError(fmt.Errorf("failed to send msg to teams: %v", err))
+	}
+	defer resp.Body.Close()
+	// incoming webhooks answer 200, Workflows 202.
+	return util.CheckResponse(resp, "send msg to teams")
+}
+
+// endpoint returns the webhook url without the options of kube-eventer. Workflows urls carry
+// their api-version and signature in the query, so the other parameters are kept.
+func endpoint(uri *url.URL) string {
+	query := uri.Query()
+	for _, options := range [][]string{sinkOptions, filters.RuleOptions, {processors.DedupWindowOption}} {
+		for _, option := range options {
+			query.Del(option)
+		}
+	}
+	endpoint := *uri
+	endpoint.RawQuery = query.Encode()
+	return endpoint.String()
+}
+
+func NewTeamsSink(uri *url.URL) (*TeamsSink, error) {
+	if uri.Host == "" {
+		return nil, fmt.Errorf("you must provide the teams webhook url")
+	}
+	t := &TeamsSink{
+		endpoint:       endpoint(uri),
+		level:          util.GetLevel(v1.EventTypeWarning),
+		maxPayloadSize: defaultMaxPayloadSize,
+		client:         &http.Client{Timeout: 10 * time.Second},
+	}
+	opts := uri.Query()
+
+	if len(opts["level"]) >= 1 {
+		t.level = util.GetLevel(opts["level"][0])
+	}
+	if len(opts["label"]) >= 1 {
+		t.labels = opts["label"]
+	}
+	if len(opts["max_payload_size"]) >= 1 {
+		size, err := strconv.Atoi(opts["max_payload_size"][0])
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("max_payload_size must be a positive number, got %q", opts["max_payload_size"][0])
+		}
+		t.maxPayloadSize = size
+	}
+
+	return t, nil
+}
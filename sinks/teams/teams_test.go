@@ -0,0 +1,82 @@
+package teams
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestNewTeamsSink(t *testing.T) {
+	uri, _ := url.Parse("https://prod-00.westus.logic.azure.com/workflows/abc/triggers/manual/paths/invoke?api-version=2016-06-01&sig=xyz&level=Normal&label=abcd&max_payload_size=1000&namespaces=default&kinds=Pod&exclude_reason=Pulled&dedup_window=5m")
+	s, err := NewTeamsSink(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://prod-00.westus.logic.azure.com/workflows/abc/triggers/manual/paths/invoke?api-version=2016-06-01&sig=xyz", s.endpoint)
+	assert.Equal(t, 1, s.level)
+	assert.Equal(t, []string{"abcd"}, s.labels)
+	assert.Equal(t, 1000, s.maxPayloadSize)
+
+	uri, _ = url.Parse("https://example.webhook.office.com/webhookb2/abc?max_payload_size=big")
+	_, err = NewTeamsSink(uri)
+	assert.Error(t, err)
+}
+
+func TestCreateContainerFromEvent(t *testing.T) {
+	uri, _ := url.Parse("https://example.webhook.office.com/webhookb2/abc?label=abcd")
+	s, _ := NewTeamsSink(uri)
+
+	container := s.createContainerFromEvent(util.NewDummyEvent(v1.EventTypeWarning, "Pod", "nginx", "BackOff"), false)
+	assert.Equal(t, "Container", container.Type)
+	assert.Equal(t, "Pod default/nginx: BackOff", container.Items[0].Text)
+	assert.Equal(t, "Attention", container.Items[0].Color)
+	facts := container.Items[1].Facts
+	assert.Contains(t, facts, Fact{Title: "Kind", Value: "Pod"})
+	assert.Contains(t, facts, Fact{Title: "Count", Value: "3"})
+	assert.Contains(t, facts, Fact{Title: "Cluster", Value: "prod"})
+	assert.Contains(t, facts, Fact{Title: "Label", Value: "abcd"})
+	assert.Equal(t, "Back-off restarting failed container", container.Items[2].Text)
+}
+
+func TestExportEventsSplitsLargeBatches(t *testing.T) {
+	var payloads [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		payloads = append(payloads, body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	uri, _ := url.Parse(server.URL + "?max_payload_size=4000")
+	s, err := NewTeamsSink(uri)
+	assert.NoError(t, err)
+
+	batch := &core.EventBatch{}
+	for i := 0; i < 20; i++ {
+		batch.Events = append(batch.Events, util.NewDummyEvent(v1.EventTypeWarning, "Pod", fmt.Sprintf("pod-%d", i), "BackOff"))
+	}
+	batch.Events = append(batch.Events, util.NewDummyEvent(v1.EventTypeNormal, "Pod", "filtered", "BackOff"))
+	assert.Empty(t, s.ExportEventsWithResult(batch))
+
+	assert.True(t, len(payloads) > 1)
+	events := 0
+	for _, payload := range payloads {
+		assert.True(t, len(payload) <= 4000, "payload of %d bytes", len(payload))
+		msg := Message{}
+		assert.NoError(t, json.Unmarshal(payload, &msg))
+		assert.Equal(t, adaptiveCardContentType, msg.Attachments[0].ContentType)
+		body := msg.Attachments[0].Content.Body
+		assert.False(t, body[0].Separator)
+		events += len(body)
+		assert.False(t, strings.Contains(string(payload), "filtered"))
+	}
+	assert.Equal(t, 20, events)
+}
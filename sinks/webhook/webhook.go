@@ -2,6 +2,7 @@ package webhook
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/AliyunContainerService/kube-eventer/util"
 	"io/ioutil"
@@ -11,9 +12,10 @@ import (
 	"text/template"
 	"time"
 
-	"github.com/AliyunContainerService/kube-eventer/common/filters"
 	"github.com/AliyunContainerService/kube-eventer/common/kubernetes"
 	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/sinks/batch"
+	"github.com/AliyunContainerService/kube-eventer/sinks/dingtalk"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
@@ -35,47 +37,111 @@ var (
 	"EventTime": "{{ .LastTimestamp }}",
 	"EventMessage": "{{ .Message }}"
 }`
+	// body template of a batch of events
+	defaultBatchBodyTemplate = `
+{
+	"EventCount": {{ .Total }},
+	"Groups": [{{ range $i, $group := .Groups }}{{ if $i }},{{ end }}
+		{"Namespace": {{ json $group.Namespace }}, "Reason": {{ json $group.Reason }}, "Type": {{ json $group.Type }}, "Count": {{ $group.Count }}}{{ end }}
+	],
+	"Text": {{ json .Text }}
+}`
+
+	templateFuncs = template.FuncMap{
+		// json quotes a value, e.g. a message, for a JSON body.
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
 )
 
+// BatchBody is what the body template is rendered with when the events are sent in batches.
+type BatchBody struct {
+	Total  int
+	Groups []*batch.Group
+	Events []*v1.Event
+	// The summary as plain text and markdown.
+	Text     string
+	Markdown string
+}
+
 type WebHookSink struct {
-	filters                map[string]filters.Filter
 	headerMap              map[string]string
 	endpoint               string
 	method                 string
 	bodyTemplate           string
+	batchBodyTemplate      string
 	bodyConfigMapName      string
 	bodyConfigMapNamespace string
+	batcher                *batch.Batcher
 }
 
 func (ws *WebHookSink) Name() string {
 	return SinkName
 }
 
+// HeldSince returns when the oldest event collected for the current window was exported.
+func (ws *WebHookSink) HeldSince() time.Time {
+	if ws.batcher == nil {
+		return time.Time{}
+	}
+	return ws.batcher.HeldSince()
+}
+
 func (ws *WebHookSink) ExportEvents(batch *core.EventBatch) {
+	for _, failure := range ws.ExportEventsWithResult(batch) {
+		klog.Warningf("Failed to send event to WebHook sink,because of %v", failure.Err)
+	}
+}
+
+func (ws *WebHookSink) ExportEventsWithResult(batch *core.EventBatch) []core.ExportFailure {
+	if ws.batcher != nil {
+		return ws.batcher.Export(batch.Events)
+	}
+
+	var failures []core.ExportFailure
 	for _, event := range batch.Events {
 		err := ws.Send(event)
 		if err != nil {
-			klog.Warningf("Failed to send event to WebHook sink,because of %v", err)
+			failures = append(failures, core.NewExportFailure(event, err))
 		}
 		time.Sleep(50 * time.Millisecond)
 	}
 	klog.V(1).Infof("Webhook %v Exporting %v events.", ws.endpoint, len(batch.Events))
+	return failures
 }
 
-// send msg to generic webHook
-func (ws *WebHookSink) Send(event *v1.Event) (err error) {
-	for _, v := range ws.filters {
-		if !v.Filter(event) {
-			return
+// sendSummary sends the events collected by the batcher in one request.
+func (ws *WebHookSink) sendSummary(summary *batch.Summary) []core.ExportFailure {
+	if summary == nil {
+		return nil
+	}
+	var failures []core.ExportFailure
+	body, err := ws.RenderBatchBodyTemplate(summary)
+	if err == nil {
+		err = ws.post(body)
+	}
+	if err != nil {
+		for _, event := range summary.Events {
+			failures = append(failures, core.NewExportFailure(event, err))
 		}
 	}
+	klog.V(1).Infof("Webhook %v Exporting a batch of %v events.", ws.endpoint, summary.Total())
+	return failures
+}
 
+// send msg to generic webHook
+func (ws *WebHookSink) Send(event *v1.Event) (err error) {
 	body, err := ws.RenderBodyTemplate(event)
 	if err != nil {
 		klog.Errorf("Failed to RenderBodyTemplate,because of %v", err)
 		return err
 	}
+	return ws.post(body)
+}
 
+func (ws *WebHookSink) post(body string) error {
 	bodyBuffer := bytes.NewBuffer([]byte(body))
 	req, err := http.NewRequest(ws.method, ws.endpoint, bodyBuffer)
 
@@ -94,7 +160,7 @@ func (ws *WebHookSink) Send(event *v1.Event) (err error) {
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		klog.Errorf("Failed to send event to sink,because of %v", err)
-		return err
+		return core.NewRetryableError(err)
 	}
 	defer resp.Body.Close()
 
@@ -105,6 +171,9 @@ func (ws *WebHookSink) Send(event *v1.Event) (err error) {
 		}
 		err = fmt.Errorf("failed to send msg to sink, because the response code is %d, body is : %v", resp.StatusCode, string(body))
 		klog.Errorln(err)
+		if util.IsRetryableStatusCode(resp.StatusCode) {
+			return core.NewRetryableError(err)
+		}
 		return err
 	}
 	return nil
@@ -126,28 +195,42 @@ func (ws *WebHookSink) RenderBodyTemplate(event *v1.Event) (body string, err err
 	return tpl.String(), nil
 }
 
-func (ws *WebHookSink) Stop() {
-	// not implement
-	return
+// RenderBatchBodyTemplate renders the batch body template with a summary of the events.
+func (ws *WebHookSink) RenderBatchBodyTemplate(summary *batch.Summary) (body string, err error) {
+	var tpl bytes.Buffer
+	tp, err := template.New("batch_body").Funcs(templateFuncs).Parse(ws.batchBodyTemplate)
+	if err != nil {
+		klog.Errorf("Failed to parse template,because of %v", err)
+		return "", err
+	}
+	data := BatchBody{
+		Total:    summary.Total(),
+		Groups:   summary.Groups,
+		Events:   summary.Events,
+		Text:     strings.Join(summary.Lines(), "\n"),
+		Markdown: dingtalk.NewBatchMarkdownMsgBuilder("", "", summary).Build(),
+	}
+	if err := tp.Execute(&tpl, data); err != nil {
+		klog.Errorf("Failed to renderTemplate,because of %v", err)
+		return "", err
+	}
+	return tpl.String(), nil
 }
 
-func getLevels(level string) []string {
-	switch level {
-	case Normal:
-		return []string{Normal, Warning}
-	case Warning:
-		return []string{Warning}
+func (ws *WebHookSink) Stop() {
+	if ws.batcher != nil {
+		// send the events collected for the current window.
+		ws.batcher.Stop()
 	}
-	return []string{Warning}
 }
 
 // init WebHookSink with url params
 func NewWebHookSink(uri *url.URL) (*WebHookSink, error) {
 	s := &WebHookSink{
 		// default http method
-		method:       http.MethodGet,
-		bodyTemplate: defaultBodyTemplate,
-		filters:      make(map[string]filters.Filter),
+		method:            http.MethodGet,
+		bodyTemplate:      defaultBodyTemplate,
+		batchBodyTemplate: defaultBatchBodyTemplate,
 	}
 
 	if len(uri.Host) > 0 {
@@ -166,30 +249,11 @@ func NewWebHookSink(uri *url.URL) (*WebHookSink, error) {
 	// set header of webHook
 	s.headerMap = parseHeaders(opts["header"])
 
-	level := Warning
-	if len(opts["level"]) >= 1 {
-		level = opts["level"][0]
-		s.filters["LevelFilter"] = filters.NewGenericFilter("Type", getLevels(level), false)
-	}
-
-	if len(opts["namespaces"]) >= 1 {
-		// namespace filter doesn't support regexp
-		namespaces := filters.GetValues(opts["namespaces"])
-		s.filters["NamespacesFilter"] = filters.NewGenericFilter("Namespace", namespaces, false)
-	}
-
-	if len(opts["kinds"]) >= 1 {
-		// such as node,pod,component and so on
-		// kinds:https://github.com/kubernetes/community/blob/master/contributors/devel/sig-architecture/api-conventions.md#lists-and-simple-kinds
-		kinds := filters.GetValues(opts["kinds"])
-		s.filters["KindsFilter"] = filters.NewGenericFilter("Kind", kinds, false)
-	}
-
-	if len(opts["reason"]) >= 1 {
-		// reason filter support regexp.
-		reasons := filters.GetValues(opts["reason"])
-		s.filters["ReasonsFilter"] = filters.NewGenericFilter("Reason", reasons, true)
+	batcher, err := batch.NewBatcher(opts, s.sendSummary)
+	if err != nil {
+		return nil, err
 	}
+	s.batcher = batcher
 
 	if len(opts["custom_body_configmap"]) >= 1 {
 		s.bodyConfigMapName = opts["custom_body_configmap"][0]
@@ -219,6 +283,9 @@ func NewWebHookSink(uri *url.URL) (*WebHookSink, error) {
 		} else {
 			s.bodyTemplate = content
 		}
+		if content, ok := configmap.Data["batch_content"]; ok {
+			s.batchBodyTemplate = content
+		}
 	}
 
 	return s, nil
@@ -1,17 +1,21 @@
 package webhook
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 
+	"github.com/AliyunContainerService/kube-eventer/core"
 	"github.com/stretchr/testify/assert"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
-	webhookSink            = "https://oapi.dingtalk.com/robot/send?access_token=token&level=Warning&namespaces=kube-system&kinds=Pod&header=contentType=demo&header=content2=3"
-	webhookSinkReasonRegex = "https://oapi.dingtalk.com/robot/send?access_token=token&level=Warning&namespaces=kube-system&reason=[^Failed*]&kinds=Pod&header=contentType=demo&header=content2=3"
+	webhookSink = "https://oapi.dingtalk.com/robot/send?access_token=token&level=Warning&namespaces=kube-system&kinds=Pod&header=contentType=demo&header=content2=3"
 )
 
 var (
@@ -29,19 +33,6 @@ var (
 	}
 )
 
-func TestNewWebhookSinkReasonRegex(t *testing.T) {
-	uri, err := url.Parse(webhookSinkReasonRegex)
-	if err != nil {
-		t.Fatalf("Failed to prase webhookSinkValid,err: %v", err)
-	}
-	w, err := NewWebHookSink(uri)
-	if err != nil {
-		t.Fatalf("Failed to create NewWebhookSink,err: %v", err)
-	}
-
-	assert.True(t, w.MockSend(newEvent), "newEvent should be matched.")
-}
-
 func TestNewWebhookSink(t *testing.T) {
 	uri, err := url.Parse(webhookSink)
 	if err != nil {
@@ -55,19 +46,6 @@ func TestNewWebhookSink(t *testing.T) {
 	assert.True(t, webhookSink == w.endpoint, "endpoint should be the same")
 }
 
-func TestNewWebhookFilter(t *testing.T) {
-	uri, err := url.Parse(webhookSink)
-	if err != nil {
-		t.Fatalf("Failed to prase webhookSinkFilter,err: %v", err)
-	}
-	w, err := NewWebHookSink(uri)
-	if err != nil {
-		t.Fatalf("Failed to create NewWebhookSink,err: %v", err)
-	}
-
-	assert.True(t, w.MockSend(newEvent), "newEvent should be matched.")
-}
-
 func TestRenderMessageWithDoubleQuote(t *testing.T) {
 	uri, err := url.Parse(webhookSink)
 	if err != nil {
@@ -86,13 +64,32 @@ func TestRenderMessageWithDoubleQuote(t *testing.T) {
 	assert.Equal(t, `{"EventMessage": "pod demo-1rare3 OOMKilled"}`, template)
 }
 
-func (ws *WebHookSink) MockSend(event *v1.Event) (matched bool) {
-	for _, v := range ws.filters {
-		if !v.Filter(event) {
-			// this event should not be send
-			return false
-		}
-	}
-	// this event should be send, this return value just for testing
-	return true
+func TestExportBatch(t *testing.T) {
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(data, &body), string(data))
+		bodies = append(bodies, body)
+	}))
+	defer server.Close()
+
+	uri, _ := url.Parse(server.URL + "?method=POST&batch=true")
+	w, err := NewWebHookSink(uri)
+	assert.NoError(t, err)
+
+	quoted := newEvent.DeepCopy()
+	quoted.Message = `pod "demo" OOMKilled`
+	failures := w.ExportEventsWithResult(&core.EventBatch{Events: []*v1.Event{newEvent, quoted}})
+	assert.Empty(t, failures)
+
+	assert.Len(t, bodies, 1)
+	assert.Equal(t, float64(2), bodies[0]["EventCount"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"Namespace": "kube-system",
+		"Reason":    "FailedStartUp",
+		"Type":      Warning,
+		"Count":     float64(2),
+	}}, bodies[0]["Groups"])
+	assert.Contains(t, bodies[0]["Text"], `1x pod "demo" OOMKilled`)
 }
@@ -17,6 +17,7 @@ package wechat
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/AliyunContainerService/kube-eventer/util"
 	"io/ioutil"
@@ -24,9 +25,14 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/sinks/batch"
+	"github.com/AliyunContainerService/kube-eventer/sinks/dingtalk"
+	"github.com/AliyunContainerService/kube-eventer/sinks/ratelimit"
 	"k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
@@ -36,17 +42,43 @@ const (
 	WARNING           int = 2
 	NORMAL            int = 1
 	DEFAULT_MSG_TYPE      = "text"
+	MARKDOWN_MSG_TYPE     = "markdown"
 	CONTENT_TYPE_JSON     = "application/json"
 	LABEL_TEMPLATE        = "%s\n"
+	CLUSTER_TEMPLATE      = "Cluster:%s \n"
+	DIGEST_TEMPLATE       = "Rate limit reached, %d events were held back:\n%s"
 	//发送消息使用的url
 	SEND_MSG_URL = `https://qyapi.weixin.qq.com/cgi-bin/message/send?access_token=`
 	//获取token使用的url
 	GET_TOKEN_URL = `https://qyapi.weixin.qq.com/cgi-bin/gettoken?corpid=`
+	//群机器人使用的url
+	ROBOT_URL = `https://qyapi.weixin.qq.com/cgi-bin/webhook/send`
+)
+
+const (
+	// Bytes of the content of text and markdown messages.
+	maxTextLength     = 2048
+	maxMarkdownLength = 4096
+	// A token is fetched again this long before it expires.
+	tokenExpiryMargin = 5 * time.Minute
+
+	// Error codes of the API.
+	errCodeSystemBusy         = -1
+	errCodeInvalidCredential  = 40001
+	errCodeInvalidAccessToken = 40014
+	errCodeAccessTokenExpired = 42001
+	errCodeFrequencyLimited   = 45009
+
+	// pendingAnnotation lists the users left to send an event to which failed with a retryable
+	// error, so that its retry is not sent again to the users who got it.
+	pendingAnnotation = "kube-eventer/wechat-pending"
 )
 
 var (
 	MSG_TEMPLATE = "Level:%s \nKind:%s \nNamespace:%s \nName:%s \nReason:%s \nTimestamp:%s \nMessage:%s"
 
+	MARKDOWN_TEMPLATE = "### Kubernetes Event\n> Level: <font color=\"%s\">%s</font>\n> Kind: %s\n> Namespace: %s\n> Name: %s\n> Reason: %s\n> Timestamp: %s\n> Message: %s"
+
 	MSG_TEMPLATE_ARR = [][]string{
 		{"Level"},
 		{"Kind"},
@@ -62,13 +94,21 @@ var (
 wechat msg struct
 */
 type WechatMsg struct {
-	ToUser  string     `json:"touser"`
-	ToParty string     `json:"toparty"`
-	ToTag   string     `json:"totag"`
-	MsgType string     `json:"msgtype"`
-	AgentID int        `json:"agentid"`
-	Text    WechatText `json:"text"`
-	Safe    int        `json:"safe"`
+	ToUser   string      `json:"touser"`
+	ToParty  string      `json:"toparty"`
+	ToTag    string      `json:"totag"`
+	MsgType  string      `json:"msgtype"`
+	AgentID  int         `json:"agentid"`
+	Text     *WechatText `json:"text,omitempty"`
+	Markdown *WechatText `json:"markdown,omitempty"`
+	Safe     int         `json:"safe"`
+}
+
+// WechatRobotMsg is the msg of the group robot, text or markdown.
+type WechatRobotMsg struct {
+	MsgType  string      `json:"msgtype"`
+	Text     *WechatText `json:"text,omitempty"`
+	Markdown *WechatText `json:"markdown,omitempty"`
 }
 
 type WechatText struct {
@@ -76,26 +116,54 @@ type WechatText struct {
 }
 
 type Token struct {
+	ErrCode     int    `json:"errcode"`
+	ErrMsg      string `json:"errmsg"`
 	AccessToken string `json:"access_token"`
 	ExpiresIn   int    `json:"expires_in"`
 }
 
+// WechatResponse is the answer of the API, an errcode other than 0 is an error.
+type WechatResponse struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
 /**
 wechat sink usage
---sink:wechat:https://qyapi.weixin.qq.com/cgi-bin/message/send?access_token=[access_token]&level=Warning&label=[label]
+--sink:wechat:?corp_id=[corp_id]&corp_secret=[corp_secret]&agent_id=[agent_id]&level=Warning&label=[label]
+or, to send to a group robot
+--sink:wechat:https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=[key]&level=Warning&label=[label]
 
+key: key of the group robot, messages are sent to the robot rather than by the application.
+msg_type: text or markdown.
 level: Normal or Warning. The event level greater than global level will emit.
 label: some thing unique when you want to distinguish different k8s clusters.
+rate_limit: messages sent per s, m or h, e.g. 20/m. Events over the limit are sent in a digest.
+rate_burst: messages sent at once before rate_limit applies.
+batch: true to send the events of an export in one message, grouped by namespace and reason.
+batch_window: duration to collect events for before sending them in one message, e.g. 5m.
+batch_top: messages listed per group of a batch (default 3).
 */
 type WechatSink struct {
-	Namespaces []string
-	Kinds      []string
 	CorpID     string
 	CorpSecret string
 	AgentID    int
 	ToUser     []string
+	RobotKey   string
+	MsgType    string
 	Level      int
 	Labels     []string
+	limiter    *ratelimit.Limiter
+	batcher    *batch.Batcher
+
+	sendMsgURL  string
+	getTokenURL string
+	robotURL    string
+
+	// The access token of the application, fetched again once it expires.
+	tokenLock      sync.Mutex
+	token          string
+	tokenExpiresAt time.Time
 }
 
 func (d *WechatSink) Name() string {
@@ -103,91 +171,347 @@ func (d *WechatSink) Name() string {
 }
 
 func (d *WechatSink) Stop() {
-	//do nothing
+	if d.batcher != nil {
+		// send the events collected for the current window.
+		d.batcher.Stop()
+	}
+}
+
+// HeldSince returns when the oldest event collected for the current window was exported.
+func (d *WechatSink) HeldSince() time.Time {
+	if d.batcher == nil {
+		return time.Time{}
+	}
+	return d.batcher.HeldSince()
 }
 
 func (d *WechatSink) ExportEvents(batch *core.EventBatch) {
+	for _, failure := range d.ExportEventsWithResult(batch) {
+		klog.Errorf("failed to send event to wechat, because of %v", failure.Err)
+	}
+}
+
+func (d *WechatSink) ExportEventsWithResult(batch *core.EventBatch) []core.ExportFailure {
+	if d.batcher != nil {
+		var events []*v1.Event
+		for _, event := range batch.Events {
+			if d.isEventLevelDangerous(event.Type) {
+				events = append(events, event)
+			}
+		}
+		return d.batcher.Export(events)
+	}
+
+	var failures []core.ExportFailure
 	for _, event := range batch.Events {
 		if d.isEventLevelDangerous(event.Type) {
-			d.Send(event)
-			// add threshold
-			time.Sleep(time.Millisecond * 50)
+			if d.limiter != nil && !d.limiter.Admit(event) {
+				continue
+			}
+			if failure := d.Send(event); failure != nil {
+				failures = append(failures, *failure)
+			}
+			if d.limiter == nil {
+				// add threshold
+				time.Sleep(time.Millisecond * 50)
+			}
+		}
+	}
+	if d.limiter != nil {
+		failures = append(failures, d.sendDigest()...)
+	}
+	return failures
+}
+
+// sendSummary sends the events collected by the batcher in one message per user, summarizing
+// for every user the events left to send to it.
+func (d *WechatSink) sendSummary(summary *batch.Summary) []core.ExportFailure {
+	var failures []core.ExportFailure
+	if summary != nil && (d.limiter == nil || d.limiter.AdmitAll(summary.Events)) {
+		deliveries := make([]delivery, len(summary.Events))
+		for _, user := range d.summaryRecipients(summary.Events) {
+			var events []*v1.Event
+			var indexes []int
+			for i, event := range summary.Events {
+				if contains(d.recipients(event), user) {
+					events = append(events, event)
+					indexes = append(indexes, i)
+				}
+			}
+			userSummary := summary
+			if len(events) < len(summary.Events) {
+				userSummary = summary.Subset(events)
+			}
+			err := d.send(createMsgFromSummary(d, userSummary), user)
+			for _, i := range indexes {
+				deliveries[i].add(user, err)
+			}
+		}
+		for i, event := range summary.Events {
+			if failure := deliveries[i].failure(event); failure != nil {
+				failures = append(failures, *failure)
+			}
 		}
 	}
+	if d.limiter != nil {
+		failures = append(failures, d.sendDigest()...)
+	}
+	return failures
+}
+
+// sendDigest sends the events held back by the rate limit once a message is allowed again. A
+// digest is sent to all users, and held back again if it fails with a retryable error for any.
+func (d *WechatSink) sendDigest() []core.ExportFailure {
+	return d.limiter.SendDigest(func(digest *ratelimit.Digest) error {
+		msg := createMsgFromDigest(d, digest)
+		var result delivery
+		for _, user := range d.recipients(nil) {
+			result.add(user, d.send(msg, user))
+		}
+		if result.retryableErr != nil {
+			return result.retryableErr
+		}
+		return result.permanentErr
+	})
 }
 
 func (d *WechatSink) isEventLevelDangerous(level string) bool {
-	score := getLevel(level)
+	score := util.GetLevel(level)
 	if score >= d.Level {
 		return true
 	}
 	return false
 }
 
-func (d *WechatSink) Send(event *v1.Event) {
-	if d.Namespaces != nil {
-		skip := true
-		for _, namespace := range d.Namespaces {
-			if namespace == event.Namespace {
-				skip = false
-				break
+// Send sends the event to its recipients and returns its failure, if any.
+func (d *WechatSink) Send(event *v1.Event) *core.ExportFailure {
+	msg := createMsgFromEvent(d, event)
+	if msg == nil {
+		failure := core.NewExportFailure(event, fmt.Errorf("failed to create msg from event %v", event))
+		return &failure
+	}
+	var result delivery
+	for _, user := range d.recipients(event) {
+		result.add(user, d.send(msg, user))
+	}
+	return result.failure(event)
+}
+
+// recipients returns the users to send the event to: the users left to send it to if it is
+// retried, or all users. The group robot is the only recipient in robot mode, with no name.
+func (d *WechatSink) recipients(event *v1.Event) []string {
+	if d.RobotKey != "" {
+		return []string{""}
+	}
+	if event != nil {
+		if pending, found := event.Annotations[pendingAnnotation]; found {
+			return strings.Split(pending, ",")
+		}
+	}
+	return d.ToUser
+}
+
+// summaryRecipients returns the users to send any of the events to, in the order of their first event.
+func (d *WechatSink) summaryRecipients(events []*v1.Event) []string {
+	var users []string
+	for _, event := range events {
+		for _, user := range d.recipients(event) {
+			if !contains(users, user) {
+				users = append(users, user)
 			}
 		}
-		if skip {
-			return
+	}
+	return users
+}
+
+func contains(users []string, user string) bool {
+	for _, u := range users {
+		if u == user {
+			return true
 		}
 	}
+	return false
+}
 
-	if d.Kinds != nil {
-		skip := true
-		for _, kind := range d.Kinds {
-			if kind == event.InvolvedObject.Kind {
-				skip = false
-				break
-			}
+// delivery collects the results of sending an event to its recipients.
+type delivery struct {
+	// The users the event failed to be sent to with a retryable error.
+	pending      []string
+	retryableErr error
+	permanentErr error
+}
+
+func (r *delivery) add(user string, err error) {
+	switch {
+	case err == nil:
+	case core.IsRetryable(err):
+		if user != "" {
+			r.pending = append(r.pending, user)
+		}
+		r.retryableErr = err
+	default:
+		r.permanentErr = err
+	}
+}
+
+// failure returns the failure of the event, nil if it was sent to all its recipients. An event
+// which failed with a retryable error is retried for the users left to send it to only.
+func (r *delivery) failure(event *v1.Event) *core.ExportFailure {
+	var failure core.ExportFailure
+	switch {
+	case r.retryableErr != nil:
+		if r.permanentErr != nil {
+			klog.Errorf("failed to send event to wechat, because of %v", r.permanentErr)
 		}
-		if skip {
-			return
+		if len(r.pending) > 0 {
+			event = withPending(event, r.pending)
 		}
+		failure = core.NewExportFailure(event, r.retryableErr)
+	case r.permanentErr != nil:
+		failure = core.NewExportFailure(event, r.permanentErr)
+	default:
+		return nil
 	}
+	return &failure
+}
 
-	msg := createMsgFromEvent(d, event)
-	if msg == nil {
-		klog.Warningf("failed to create msg from event,because of %v", event)
-		return
+// withPending returns a copy of the event with the users left to send it to.
+func withPending(event *v1.Event, users []string) *v1.Event {
+	event = event.DeepCopy()
+	if event.Annotations == nil {
+		event.Annotations = map[string]string{}
 	}
+	event.Annotations[pendingAnnotation] = strings.Join(users, ",")
+	return event
+}
 
-	token, err := getToken(d.CorpID, d.CorpSecret)
+// send sends the message to the user, or to the group robot in robot mode.
+func (d *WechatSink) send(msg *WechatMsg, user string) error {
+	if d.RobotKey != "" {
+		return d.sendToRobot(msg)
+	}
+
+	token, err := d.accessToken()
 	if err != nil {
-		klog.Warningf("failed to get token,because of %v", err)
-		return
+		return core.NewRetryableError(fmt.Errorf("failed to get token,because of %v", err))
 	}
 
-	for _, user := range d.ToUser {
-		msg.ToUser = user
+	msg.ToUser = user
+	msg_bytes, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal msg %v", msg)
+	}
 
-		msg_bytes, err := json.Marshal(msg)
-		if err != nil {
-			klog.Warningf("failed to marshal msg %v", msg)
-			return
+	if err := post(d.sendMsgURL+token, msg_bytes); err != nil {
+		if isTokenError(err) {
+			// fetch a new token for the retry.
+			d.invalidateToken()
+			return core.NewRetryableError(err)
 		}
+		return err
+	}
+	return nil
+}
+
+func (d *WechatSink) sendToRobot(msg *WechatMsg) error {
+	msg_bytes, err := json.Marshal(&WechatRobotMsg{
+		MsgType:  msg.MsgType,
+		Text:     msg.Text,
+		Markdown: msg.Markdown,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal msg %v", msg)
+	}
+	return post(d.robotURL, msg_bytes)
+}
 
-		b := bytes.NewBuffer(msg_bytes)
-		resp, err := http.Post(SEND_MSG_URL+token.AccessToken, CONTENT_TYPE_JSON, b)
-		if err != nil {
-			klog.Errorf("failed to send msg to dingtalk. error: %s", err.Error())
-			return
+// apiError is an errcode answered by the API.
+type apiError struct {
+	code    int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("the errcode is %d: %s", e.code, e.message)
+}
+
+func isTokenError(err error) bool {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.code {
+	case errCodeInvalidCredential, errCodeInvalidAccessToken, errCodeAccessTokenExpired:
+		return true
+	}
+	return false
+}
+
+func post(url string, body []byte) error {
+	resp, err := http.Post(url, CONTENT_TYPE_JSON, bytes.NewReader(body))
+	if err != nil {
+		return core.NewRetryableError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("the response code is %d", resp.StatusCode)
+		if util.IsRetryableStatusCode(resp.StatusCode) {
+			return core.NewRetryableError(err)
 		}
-		if resp != nil && resp.StatusCode != http.StatusOK {
-			klog.Errorf("failed to send msg to dingtalk, because the response code is %d", resp.StatusCode)
-			return
+		return err
+	}
+
+	// errors are answered with 200 and an errcode.
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return core.NewRetryableError(err)
+	}
+	result := WechatResponse{}
+	if err := json.Unmarshal(buf, &result); err != nil {
+		return fmt.Errorf("failed to parse response %q: %v", string(buf), err)
+	}
+	if result.ErrCode != 0 {
+		err := &apiError{code: result.ErrCode, message: result.ErrMsg}
+		if result.ErrCode == errCodeSystemBusy || result.ErrCode == errCodeFrequencyLimited {
+			return core.NewRetryableError(err)
 		}
+		return err
 	}
+	return nil
+}
 
+// accessToken returns the cached access token of the application, or fetches a new one once it expired.
+func (d *WechatSink) accessToken() (string, error) {
+	d.tokenLock.Lock()
+	defer d.tokenLock.Unlock()
+
+	now := time.Now()
+	if d.token != "" && now.Before(d.tokenExpiresAt) {
+		return d.token, nil
+	}
+	token, err := getToken(d.getTokenURL, d.CorpID, d.CorpSecret)
+	if err != nil {
+		return "", err
+	}
+	expiresIn := time.Duration(token.ExpiresIn) * time.Second
+	margin := tokenExpiryMargin
+	if margin > expiresIn/2 {
+		margin = expiresIn / 2
+	}
+	d.token = token.AccessToken
+	d.tokenExpiresAt = now.Add(expiresIn - margin)
+	return d.token, nil
+}
+
+func (d *WechatSink) invalidateToken() {
+	d.tokenLock.Lock()
+	defer d.tokenLock.Unlock()
+
+	d.token = ""
 }
 
-func getToken(corp_id, corp_secret string) (at Token, err error) {
-	resp, err := http.Get(GET_TOKEN_URL + corp_id + "&corpsecret=" + corp_secret)
+func getToken(get_token_url, corp_id, corp_secret string) (at Token, err error) {
+	resp, err := http.Get(get_token_url + url.QueryEscape(corp_id) + "&corpsecret=" + url.QueryEscape(corp_secret))
 	if err != nil {
 		return at, err
 	}
@@ -195,29 +519,35 @@ func getToken(corp_id, corp_secret string) (at Token, err error) {
 	if resp.StatusCode != 200 {
 		return at, fmt.Errorf("get wechat token request error")
 	}
-	buf, _ := ioutil.ReadAll(resp.Body)
-	err = json.Unmarshal(buf, &at)
-	return at, nil
-}
-
-func getLevel(level string) int {
-	score := 0
-	switch level {
-	case v1.EventTypeWarning:
-		score += 2
-	case v1.EventTypeNormal:
-		score += 1
-	default:
-		//score will remain 0
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return at, err
 	}
-	return score
+	if err = json.Unmarshal(buf, &at); err != nil {
+		return at, err
+	}
+	if at.ErrCode != 0 || at.AccessToken == "" {
+		return at, fmt.Errorf("get wechat token error, errcode is %d: %s", at.ErrCode, at.ErrMsg)
+	}
+	return at, nil
 }
 
 func createMsgFromEvent(d *WechatSink, event *v1.Event) *WechatMsg {
-
-	msg := &WechatMsg{}
-	msg.MsgType = DEFAULT_MSG_TYPE
-	msg.AgentID = d.AgentID
+	timestamp := util.GetLastEventTimestamp(event).String()
+	if d.MsgType == MARKDOWN_MSG_TYPE {
+		color := "info"
+		if event.Type == v1.EventTypeWarning {
+			color = "warning"
+		}
+		template := MARKDOWN_TEMPLATE
+		for _, label := range d.Labels {
+			template = fmt.Sprintf(LABEL_TEMPLATE, label) + template
+		}
+		if cluster := util.GetClusterName(event, ""); cluster != "" {
+			template = fmt.Sprintf(CLUSTER_TEMPLATE, cluster) + template
+		}
+		return newMsg(d, fmt.Sprintf(template, color, event.Type, event.InvolvedObject.Kind, event.Namespace, event.Name, event.Reason, timestamp, event.Message))
+	}
 
 	//默认按文本模式推送
 	template := MSG_TEMPLATE
@@ -226,40 +556,132 @@ func createMsgFromEvent(d *WechatSink, event *v1.Event) *WechatMsg {
 			template = fmt.Sprintf(LABEL_TEMPLATE, label) + template
 		}
 	}
+	if cluster := util.GetClusterName(event, ""); cluster != "" {
+		template = fmt.Sprintf(CLUSTER_TEMPLATE, cluster) + template
+	}
+	return newMsg(d, fmt.Sprintf(template, event.Type, event.InvolvedObject.Kind, event.Namespace, event.Name, event.Reason, timestamp, event.Message))
+}
 
-	msg.Text = WechatText{
-		Content: fmt.Sprintf(template, event.Type, event.InvolvedObject.Kind, event.Namespace, event.Name, event.Reason, util.GetLastEventTimestamp(event).String(), event.Message),
+func createMsgFromSummary(d *WechatSink, summary *batch.Summary) *WechatMsg {
+	template := "%s"
+	for _, label := range d.Labels {
+		template = fmt.Sprintf(LABEL_TEMPLATE, label) + template
+	}
+	if d.MsgType == MARKDOWN_MSG_TYPE {
+		return newMsg(d, fmt.Sprintf(template, dingtalk.NewBatchMarkdownMsgBuilder("", "", summary).Build()))
 	}
+	return newMsg(d, fmt.Sprintf(template, strings.Join(summary.Lines(), "\n")))
+}
 
+func createMsgFromDigest(d *WechatSink, digest *ratelimit.Digest) *WechatMsg {
+	template := DIGEST_TEMPLATE
+	for _, label := range d.Labels {
+		template = fmt.Sprintf(LABEL_TEMPLATE, label) + template
+	}
+	return newMsg(d, fmt.Sprintf(template, digest.Total, strings.Join(digest.Lines(), "\n")))
+}
+
+// newMsg returns a text or markdown message, as configured, cut to the size the API accepts.
+func newMsg(d *WechatSink, content string) *WechatMsg {
+	msg := &WechatMsg{}
+	msg.MsgType = d.MsgType
+	msg.AgentID = d.AgentID
+	if d.MsgType == MARKDOWN_MSG_TYPE {
+		msg.Markdown = &WechatText{Content: truncate(content, maxMarkdownLength)}
+	} else {
+		msg.Text = &WechatText{Content: truncate(content, maxTextLength)}
+	}
 	return msg
 }
 
+// truncate cuts the content to at most max bytes without splitting a character.
+func truncate(content string, max int) string {
+	if len(content) <= max {
+		return content
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(content[cut]) {
+		cut--
+	}
+	return content[:cut]
+}
+
 func NewWechatSink(uri *url.URL) (*WechatSink, error) {
 	d := &WechatSink{
-		Level: WARNING,
+		Level:       WARNING,
+		MsgType:     DEFAULT_MSG_TYPE,
+		sendMsgURL:  SEND_MSG_URL,
+		getTokenURL: GET_TOKEN_URL,
 	}
 	opts := uri.Query()
 
+	if len(opts["key"]) >= 1 && opts["key"][0] != "" {
+		// group robot mode
+		d.RobotKey = opts["key"][0]
+		robotURL := ROBOT_URL
+		if len(uri.Host) > 0 {
+			robotURL = (&url.URL{Scheme: uri.Scheme, Host: uri.Host, Path: uri.Path}).String()
+		}
+		d.robotURL = robotURL + "?key=" + url.QueryEscape(d.RobotKey)
+	} else if err := parseAppOptions(d, opts); err != nil {
+		return nil, err
+	}
+
+	if msgType := opts["msg_type"]; len(msgType) >= 1 {
+		switch msgType[0] {
+		case DEFAULT_MSG_TYPE, MARKDOWN_MSG_TYPE:
+			d.MsgType = msgType[0]
+		default:
+			return nil, fmt.Errorf("msg_type must be %s or %s, got %q", DEFAULT_MSG_TYPE, MARKDOWN_MSG_TYPE, msgType[0])
+		}
+	}
+
+	if len(opts["level"]) >= 1 {
+		d.Level = util.GetLevel(opts["level"][0])
+	}
+
+	//add extra labels
+	if len(opts["label"]) >= 1 {
+		d.Labels = opts["label"]
+	}
+
+	limiter, err := ratelimit.NewLimiter(WECHAT_SINK, opts)
+	if err != nil {
+		return nil, err
+	}
+	d.limiter = limiter
+
+	batcher, err := batch.NewBatcher(opts, d.sendSummary)
+	if err != nil {
+		return nil, err
+	}
+	d.batcher = batcher
+
+	return d, nil
+}
+
+// parseAppOptions reads the options of the application message mode.
+func parseAppOptions(d *WechatSink, opts url.Values) error {
 	if len(opts["corp_id"]) >= 1 {
 		d.CorpID = opts["corp_id"][0]
 	} else {
-		return nil, fmt.Errorf("you must provide wechat corpid")
+		return fmt.Errorf("you must provide wechat corpid")
 	}
 
 	if len(opts["corp_secret"]) >= 1 {
 		d.CorpSecret = opts["corp_secret"][0]
 	} else {
-		return nil, fmt.Errorf("you must provide wechat corpsecret")
+		return fmt.Errorf("you must provide wechat corpsecret")
 	}
 
 	if len(opts["agent_id"]) >= 1 {
 		if AgentID, err := strconv.Atoi(opts["agent_id"][0]); err == nil {
 			d.AgentID = AgentID
 		} else {
-			return nil, fmt.Errorf("you must provide wechat agentid is number")
+			return fmt.Errorf("you must provide wechat agentid is number")
 		}
 	} else {
-		return nil, fmt.Errorf("you must provide wechat agentid")
+		return fmt.Errorf("you must provide wechat agentid")
 	}
 
 	//使用逗号分隔需要通知的用户，如果为空则通知所有当前组下的所有用户
@@ -270,28 +692,5 @@ func NewWechatSink(uri *url.URL) (*WechatSink, error) {
 	} else {
 		d.ToUser = append(d.ToUser, "@all")
 	}
-
-	if len(opts["level"]) >= 1 {
-		d.Level = getLevel(opts["level"][0])
-	}
-
-	//add extra labels
-	if len(opts["label"]) >= 1 {
-		d.Labels = opts["label"]
-	}
-
-	d.Namespaces = getValues(opts["namespaces"])
-	d.Kinds = getValues(opts["kinds"])
-
-	return d, nil
-}
-
-func getValues(o []string) []string {
-	if len(o) >= 1 {
-		if len(o[0]) == 0 {
-			return nil
-		}
-		return strings.Split(o[0], ",")
-	}
 	return nil
 }
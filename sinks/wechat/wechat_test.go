@@ -2,18 +2,27 @@ package wechat
 
 import (
 	"encoding/json"
+	"fmt"
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/sinks/batch"
+	"github.com/AliyunContainerService/kube-eventer/util"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 func TestGetLevel(t *testing.T) {
-	warning := getLevel(v1.EventTypeWarning)
-	normal := getLevel(v1.EventTypeNormal)
-	none := getLevel("")
+	warning := util.GetLevel(v1.EventTypeWarning)
+	normal := util.GetLevel(v1.EventTypeNormal)
+	none := util.GetLevel("")
 	assert.True(t, warning > normal)
 	assert.True(t, warning == WARNING)
 	assert.True(t, normal == NORMAL)
@@ -34,6 +43,115 @@ func TestCreateMsgFromEvent(t *testing.T) {
 	assert.True(t, msg != nil)
 }
 
+func TestNewWechatSinkRobot(t *testing.T) {
+	u, _ := url.Parse("wechat:https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=abc&msg_type=markdown")
+	d, err := NewWechatSink(u)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", d.RobotKey)
+	assert.Equal(t, "https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=abc", d.robotURL)
+	assert.Equal(t, MARKDOWN_MSG_TYPE, d.MsgType)
+
+	u, _ = url.Parse("wechat:?key=abc")
+	d, err = NewWechatSink(u)
+	assert.NoError(t, err)
+	assert.Equal(t, ROBOT_URL+"?key=abc", d.robotURL)
+
+	u, _ = url.Parse("wechat:?key=abc&msg_type=news")
+	_, err = NewWechatSink(u)
+	assert.Error(t, err)
+
+	// without a key the application options are required.
+	u, _ = url.Parse("wechat:?msg_type=markdown")
+	_, err = NewWechatSink(u)
+	assert.Error(t, err)
+}
+
+func TestCreateMarkdownMsgFromEvent(t *testing.T) {
+	u, _ := url.Parse("wechat:?key=abc&msg_type=markdown&label=abcd")
+	d, _ := NewWechatSink(u)
+	event := createTestEvent()
+	util.SetClusterName(event, "prod")
+	msg := createMsgFromEvent(d, event)
+	assert.Nil(t, msg.Text)
+	assert.True(t, strings.HasPrefix(msg.Markdown.Content, "Cluster:prod \nabcd\n### Kubernetes Event\n> Level: <font color=\"warning\">Warning</font>"))
+
+	event.Message = strings.Repeat("很长", 2000)
+	msg = createMsgFromEvent(d, event)
+	assert.True(t, len(msg.Markdown.Content) <= maxMarkdownLength)
+}
+
+func TestSendToRobot(t *testing.T) {
+	var msgs []map[string]interface{}
+	response := `{"errcode":0,"errmsg":"ok"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "abc", r.URL.Query().Get("key"))
+		body, _ := ioutil.ReadAll(r.Body)
+		msg := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(body, &msg))
+		msgs = append(msgs, msg)
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/cgi-bin/webhook/send?key=abc&msg_type=markdown")
+	d, err := NewWechatSink(u)
+	assert.NoError(t, err)
+
+	assert.NoError(t, d.send(createMsgFromEvent(d, createTestEvent()), ""))
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, MARKDOWN_MSG_TYPE, msgs[0]["msgtype"])
+	assert.NotNil(t, msgs[0]["markdown"])
+	assert.Nil(t, msgs[0]["touser"])
+
+	response = `{"errcode":45009,"errmsg":"api freq out of limit"}`
+	err = d.send(createMsgFromEvent(d, createTestEvent()), "")
+	assert.True(t, core.IsRetryable(err))
+}
+
+func TestAccessTokenIsCached(t *testing.T) {
+	tokens := 0
+	expired := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cgi-bin/gettoken":
+			tokens++
+			fmt.Fprintf(w, `{"errcode":0,"errmsg":"ok","access_token":"token-%d","expires_in":7200}`, tokens)
+		case "/cgi-bin/message/send":
+			if expired {
+				expired = false
+				w.Write([]byte(`{"errcode":42001,"errmsg":"access_token expired"}`))
+				return
+			}
+			assert.Equal(t, fmt.Sprintf("token-%d", tokens), r.URL.Query().Get("access_token"))
+			w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+		}
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse("wechat:?corp_id=corp&corp_secret=secret&agent_id=1000012&to_user=a,b")
+	d, err := NewWechatSink(u)
+	assert.NoError(t, err)
+	d.getTokenURL = server.URL + "/cgi-bin/gettoken?corpid="
+	d.sendMsgURL = server.URL + "/cgi-bin/message/send?access_token="
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, d.Send(createTestEvent()))
+	}
+	assert.Equal(t, 1, tokens)
+
+	// an expired token is fetched again for the next user.
+	expired = true
+	failure := d.Send(createTestEvent())
+	assert.NotNil(t, failure)
+	assert.True(t, failure.Retryable)
+	assert.Equal(t, 2, tokens)
+
+	// as is a token about to expire.
+	d.tokenExpiresAt = time.Now()
+	assert.Nil(t, d.Send(createTestEvent()))
+	assert.Equal(t, 3, tokens)
+}
+
 func createTestEvent() *v1.Event {
 	now := time.Now()
 	event := &v1.Event{
@@ -46,3 +164,96 @@ func createTestEvent() *v1.Event {
 	}
 	return event
 }
+
+// appServer is a stand-in for the message API of an application, failing the users in fail.
+type appServer struct {
+	*httptest.Server
+	lock  sync.Mutex
+	fail  map[string]string
+	users []string
+	texts []string
+}
+
+func newAppServer(t *testing.T) *appServer {
+	s := &appServer{fail: map[string]string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cgi-bin/gettoken" {
+			w.Write([]byte(`{"errcode":0,"errmsg":"ok","access_token":"token","expires_in":7200}`))
+			return
+		}
+		var msg WechatMsg
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if response, found := s.fail[msg.ToUser]; found {
+			w.Write([]byte(response))
+			return
+		}
+		s.users = append(s.users, msg.ToUser)
+		s.texts = append(s.texts, msg.Text.Content)
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	return s
+}
+
+func newAppSink(t *testing.T, server *appServer, query string) *WechatSink {
+	u, _ := url.Parse("wechat:?corp_id=corp&corp_secret=secret&agent_id=1000012&to_user=a,b&" + query)
+	d, err := NewWechatSink(u)
+	assert.NoError(t, err)
+	d.getTokenURL = server.URL + "/cgi-bin/gettoken?corpid="
+	d.sendMsgURL = server.URL + "/cgi-bin/message/send?access_token="
+	return d
+}
+
+func TestExportEventsRetriesFailedUsers(t *testing.T) {
+	server := newAppServer(t)
+	defer server.Close()
+	d := newAppSink(t, server, "")
+
+	server.fail["b"] = `{"errcode":-1,"errmsg":"system busy"}`
+	failures := d.ExportEventsWithResult(&core.EventBatch{Events: []*v1.Event{createTestEvent()}})
+	assert.Len(t, failures, 1)
+	assert.True(t, failures[0].Retryable)
+	assert.Equal(t, "b", failures[0].Event.Annotations[pendingAnnotation])
+	assert.Equal(t, []string{"a"}, server.users)
+
+	// the retry is only sent to the user which did not get the event.
+	delete(server.fail, "b")
+	assert.Empty(t, d.ExportEventsWithResult(&core.EventBatch{Events: []*v1.Event{failures[0].Event}}))
+	assert.Equal(t, []string{"a", "b"}, server.users)
+
+	server.fail["a"] = `{"errcode":60020,"errmsg":"not allow to access from your ip"}`
+	failures = d.ExportEventsWithResult(&core.EventBatch{Events: []*v1.Event{createTestEvent()}})
+	assert.Len(t, failures, 1)
+	assert.False(t, failures[0].Retryable)
+	assert.Equal(t, []string{"a", "b", "b"}, server.users)
+}
+
+func TestSendSummaryRetriesFailedUsers(t *testing.T) {
+	server := newAppServer(t)
+	defer server.Close()
+	d := newAppSink(t, server, "batch=true")
+
+	retried := withPending(createTestEvent(), []string{"b"})
+	retried.Reason = "Retried"
+	summary := batch.Summarize([]*v1.Event{createTestEvent(), retried}, batch.DefaultTop)
+	server.fail["a"] = `{"errcode":45009,"errmsg":"api freq out of limit"}`
+	failures := d.sendSummary(summary)
+
+	// a gets the event which was not sent yet, and b both events.
+	assert.Len(t, failures, 1)
+	assert.Equal(t, "996 work schedule", failures[0].Event.Reason)
+	assert.Equal(t, "a", failures[0].Event.Annotations[pendingAnnotation])
+	assert.Equal(t, []string{"b"}, server.users)
+	assert.True(t, strings.HasPrefix(server.texts[0], "2 events in 2 groups"))
+}
+
+func TestCreateTextMsgFromEvent(t *testing.T) {
+	u, _ := url.Parse("wechat:?key=abc&label=abcd")
+	d, _ := NewWechatSink(u)
+	event := createTestEvent()
+	util.SetClusterName(event, "prod")
+	msg := createMsgFromEvent(d, event)
+	assert.True(t, strings.HasPrefix(msg.Text.Content, "Cluster:prod \nabcd\nLevel:Warning \n"))
+}
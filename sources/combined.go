@@ -0,0 +1,32 @@
+package sources
+
+import (
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	kubeapi "k8s.io/api/core/v1"
+)
+
+// combinedSource merges the events of several sources, e.g. of several clusters.
+type combinedSource struct {
+	sources []core.EventSource
+}
+
+// NewCombinedSource returns a source reading the events of all the given sources.
+func NewCombinedSource(sources []core.EventSource) core.EventSource {
+	if len(sources) == 1 {
+		return sources[0]
+	}
+	return &combinedSource{sources: sources}
+}
+
+func (this *combinedSource) GetNewEvents() *core.EventBatch {
+	result := &core.EventBatch{
+		Timestamp: time.Now(),
+		Events:    []*kubeapi.Event{},
+	}
+	for _, source := range this.sources {
+		result.Events = append(result.Events, source.GetNewEvents().Events...)
+	}
+	return result
+}
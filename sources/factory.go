@@ -37,8 +37,8 @@ func (this *SourceFactory) Build(uri flags.Uri, exportMetric bool) (core.EventSo
 }
 
 func (this *SourceFactory) BuildAll(uris flags.Uris, exportMetric bool) ([]core.EventSource, error) {
-	if len(uris) != 1 {
-		return nil, fmt.Errorf("Only one source is supported")
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("No source specified")
 	}
 	result := []core.EventSource{}
 	for _, uri := range uris {
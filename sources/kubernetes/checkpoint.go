@@ -0,0 +1,152 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/util"
+	kubeapi "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclient "k8s.io/client-go/kubernetes"
+	kubev1core "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	checkpointConfigMapKey = "checkpoint"
+)
+
+// checkpoint is the position of the source in the event stream.
+type checkpoint struct {
+	// The resourceVersion of the last delivered event for each watch, keyed by namespace.
+	ResourceVersions map[string]string `json:"resourceVersions"`
+	// The latest timestamp of all delivered events.
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// covers reports whether the event was delivered before the checkpoint. ResourceVersions are opaque and can
+// not be ordered, so the event at the saved resourceVersion is the only one known to be delivered, the others
+// are compared by time. Events at the time of the checkpoint are not covered, they may be delivered twice.
+func (c *checkpoint) covers(event *kubeapi.Event) bool {
+	saved, ok := c.ResourceVersions[event.Namespace]
+	if !ok {
+		saved, ok = c.ResourceVersions[kubeapi.NamespaceAll]
+	}
+	if ok && event.ResourceVersion == saved {
+		return true
+	}
+	return util.GetLastEventTimestamp(event).Before(c.Timestamp)
+}
+
+// checkpointStore persists the checkpoint so that it survives restarts.
+type checkpointStore interface {
+	// Load returns the stored checkpoint or nil if there is none.
+	Load() (*checkpoint, error)
+	Save(*checkpoint) error
+}
+
+type fileCheckpointStore struct {
+	path string
+}
+
+func (f *fileCheckpointStore) Load() (*checkpoint, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &checkpoint{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint %s: %v", f.path, err)
+	}
+	return c, nil
+}
+
+func (f *fileCheckpointStore) Save(c *checkpoint) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+type configMapCheckpointStore struct {
+	client kubev1core.ConfigMapInterface
+	name   string
+}
+
+func (c *configMapCheckpointStore) Load() (*checkpoint, error) {
+	cm, err := c.client.Get(c.name, metav1.GetOptions{})
+	if kubeerrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, ok := cm.Data[checkpointConfigMapKey]
+	if !ok {
+		return nil, nil
+	}
+	cp := &checkpoint{}
+	if err := json.Unmarshal([]byte(data), cp); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint in configmap %s: %v", c.name, err)
+	}
+	return cp, nil
+}
+
+func (c *configMapCheckpointStore) Save(cp *checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	cm, err := c.client.Get(c.name, metav1.GetOptions{})
+	if kubeerrors.IsNotFound(err) {
+		_, err = c.client.Create(&kubeapi.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: c.name},
+			Data:       map[string]string{checkpointConfigMapKey: string(data)},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[checkpointConfigMapKey] = string(data)
+	_, err = c.client.Update(cm)
+	return err
+}
+
+// newCheckpointStore returns the store configured by the checkpoint_file or
+// checkpoint_configmap (<namespace>/<name>) option, or nil if none is set.
+func newCheckpointStore(opts map[string][]string, kubeClient kubeclient.Interface) (checkpointStore, error) {
+	if len(opts["checkpoint_file"]) >= 1 && opts["checkpoint_file"][0] != "" {
+		return &fileCheckpointStore{path: opts["checkpoint_file"][0]}, nil
+	}
+	if len(opts["checkpoint_configmap"]) >= 1 && opts["checkpoint_configmap"][0] != "" {
+		parts := strings.SplitN(opts["checkpoint_configmap"][0], "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("checkpoint_configmap must be <namespace>/<name>, got %s", opts["checkpoint_configmap"][0])
+		}
+		return &configMapCheckpointStore{
+			client: kubeClient.CoreV1().ConfigMaps(parts[0]),
+			name:   parts[1],
+		}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,223 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/AliyunContainerService/kube-eventer/common/kubernetes"
+	kubeapi "k8s.io/api/core/v1"
+	eventsv1beta1 "k8s.io/api/events/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	kubewatch "k8s.io/apimachinery/pkg/watch"
+	kubeclient "k8s.io/client-go/kubernetes"
+	eventsclient "k8s.io/client-go/kubernetes/typed/events/v1beta1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// The legacy Event API, the default.
+	CoreEventsAPI = "v1"
+	// The Event API of newer components, served since Kubernetes 1.19.
+	EventsV1API      = "events.k8s.io/v1"
+	EventsV1beta1API = "events.k8s.io/v1beta1"
+)
+
+var eventsV1GroupVersion = schema.GroupVersion{Group: "events.k8s.io", Version: "v1"}
+
+// eventInterface lists and watches events as core/v1 Events, whichever API they are read from.
+type eventInterface interface {
+	List(opts metav1.ListOptions) (*kubeapi.EventList, error)
+	Watch(opts metav1.ListOptions) (kubewatch.Interface, error)
+}
+
+// newEventInterface returns the client of the Event API selected by the api option.
+func newEventInterface(uri *url.URL, kubeClient kubeclient.Interface, namespace string) (eventInterface, error) {
+	switch api := uri.Query().Get("api"); api {
+	case "", CoreEventsAPI:
+		return kubeClient.CoreV1().Events(namespace), nil
+	case EventsV1beta1API:
+		return &eventsV1beta1Client{client: kubeClient.EventsV1beta1().Events(namespace)}, nil
+	case EventsV1API:
+		client, err := newEventsV1Client(uri, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s client: %v", EventsV1API, err)
+		}
+		return client, nil
+	default:
+		return nil, fmt.Errorf("unsupported event api %q, supported: %s, %s, %s", api, CoreEventsAPI, EventsV1API, EventsV1beta1API)
+	}
+}
+
+// eventsV1Client reads events.k8s.io/v1 Events with a scheme of its own, as the vendored client-go
+// has no types of the API.
+type eventsV1Client struct {
+	client         rest.Interface
+	parameterCodec runtime.ParameterCodec
+	namespace      string
+}
+
+func newEventsV1Client(uri *url.URL, namespace string) (*eventsV1Client, error) {
+	config, err := kubernetes.GetKubeClientConfig(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	gv := eventsV1GroupVersion
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(gv.WithKind("Event"), &eventsV1Event{})
+	scheme.AddKnownTypeWithName(gv.WithKind("EventList"), &eventsV1EventList{})
+	metav1.AddToGroupVersion(scheme, gv)
+
+	restConfig := rest.CopyConfig(config)
+	restConfig.GroupVersion = &gv
+	restConfig.APIPath = "/apis"
+	restConfig.ContentType = runtime.ContentTypeJSON
+	restConfig.AcceptContentTypes = runtime.ContentTypeJSON
+	restConfig.NegotiatedSerializer = serializer.NewCodecFactory(scheme).WithoutConversion()
+	if restConfig.UserAgent == "" {
+		restConfig.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+	client, err := rest.RESTClientFor(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &eventsV1Client{client: client, parameterCodec: runtime.NewParameterCodec(scheme), namespace: namespace}, nil
+}
+
+func (c *eventsV1Client) List(opts metav1.ListOptions) (*kubeapi.EventList, error) {
+	events := &eventsV1EventList{}
+	err := c.client.Get().
+		Namespace(c.namespace).
+		Resource("events").
+		VersionedParams(&opts, c.parameterCodec).
+		Do().
+		Into(events)
+	if err != nil {
+		return nil, err
+	}
+	result := &kubeapi.EventList{ListMeta: events.ListMeta}
+	for i := range events.Items {
+		result.Items = append(result.Items, *convertEvent(&events.Items[i]))
+	}
+	return result, nil
+}
+
+func (c *eventsV1Client) Watch(opts metav1.ListOptions) (kubewatch.Interface, error) {
+	opts.Watch = true
+	watcher, err := c.client.Get().
+		Namespace(c.namespace).
+		Resource("events").
+		VersionedParams(&opts, c.parameterCodec).
+		Watch()
+	if err != nil {
+		return nil, err
+	}
+	return kubewatch.Filter(watcher, func(in kubewatch.Event) (kubewatch.Event, bool) {
+		if event, ok := in.Object.(*eventsV1Event); ok {
+			in.Object = convertEvent(event)
+		}
+		return in, true
+	}), nil
+}
+
+// eventsV1beta1Client reads events.k8s.io/v1beta1 Events.
+type eventsV1beta1Client struct {
+	client eventsclient.EventInterface
+}
+
+func (c *eventsV1beta1Client) List(opts metav1.ListOptions) (*kubeapi.EventList, error) {
+	events, err := c.client.List(opts)
+	if err != nil {
+		return nil, err
+	}
+	result := &kubeapi.EventList{ListMeta: events.ListMeta}
+	for i := range events.Items {
+		result.Items = append(result.Items, *convertEvent(fromV1beta1(&events.Items[i])))
+	}
+	return result, nil
+}
+
+func (c *eventsV1beta1Client) Watch(opts metav1.ListOptions) (kubewatch.Interface, error) {
+	watcher, err := c.client.Watch(opts)
+	if err != nil {
+		return nil, err
+	}
+	return kubewatch.Filter(watcher, func(in kubewatch.Event) (kubewatch.Event, bool) {
+		if event, ok := in.Object.(*eventsv1beta1.Event); ok {
+			in.Object = convertEvent(fromV1beta1(event))
+		}
+		return in, true
+	}), nil
+}
+
+// fromV1beta1 maps a v1beta1 Event to v1, which only dropped the state of the series.
+func fromV1beta1(event *eventsv1beta1.Event) *eventsV1Event {
+	result := &eventsV1Event{
+		TypeMeta:                 event.TypeMeta,
+		ObjectMeta:               event.ObjectMeta,
+		EventTime:                event.EventTime,
+		ReportingController:      event.ReportingController,
+		ReportingInstance:        event.ReportingInstance,
+		Action:                   event.Action,
+		Reason:                   event.Reason,
+		Regarding:                event.Regarding,
+		Related:                  event.Related,
+		Note:                     event.Note,
+		Type:                     event.Type,
+		DeprecatedSource:         event.DeprecatedSource,
+		DeprecatedFirstTimestamp: event.DeprecatedFirstTimestamp,
+		DeprecatedLastTimestamp:  event.DeprecatedLastTimestamp,
+		DeprecatedCount:          event.DeprecatedCount,
+	}
+	if event.Series != nil {
+		result.Series = &eventsV1EventSeries{Count: event.Series.Count, LastObservedTime: event.Series.LastObservedTime}
+	}
+	return result
+}
+
+// convertEvent maps an events.k8s.io Event to the core/v1 Event all sinks render.
+func convertEvent(event *eventsV1Event) *kubeapi.Event {
+	result := &kubeapi.Event{
+		ObjectMeta:          event.ObjectMeta,
+		InvolvedObject:      event.Regarding,
+		Related:             event.Related,
+		Reason:              event.Reason,
+		Message:             event.Note,
+		Type:                event.Type,
+		Source:              event.DeprecatedSource,
+		FirstTimestamp:      event.DeprecatedFirstTimestamp,
+		LastTimestamp:       event.DeprecatedLastTimestamp,
+		Count:               event.DeprecatedCount,
+		EventTime:           event.EventTime,
+		Action:              event.Action,
+		ReportingController: event.ReportingController,
+		ReportingInstance:   event.ReportingInstance,
+	}
+	result.Kind = "Event"
+	result.APIVersion = "v1"
+
+	if result.Source.Component == "" {
+		result.Source.Component = event.ReportingController
+	}
+	if result.FirstTimestamp.IsZero() {
+		result.FirstTimestamp = metav1.NewTime(event.EventTime.Time)
+	}
+	if event.Series != nil {
+		result.Series = &kubeapi.EventSeries{
+			Count:            event.Series.Count,
+			LastObservedTime: event.Series.LastObservedTime,
+		}
+		result.Count = event.Series.Count
+		result.LastTimestamp = metav1.NewTime(event.Series.LastObservedTime.Time)
+	}
+	if result.LastTimestamp.IsZero() {
+		result.LastTimestamp = metav1.NewTime(event.EventTime.Time)
+	}
+	if result.Count == 0 {
+		result.Count = 1
+	}
+	return result
+}
@@ -0,0 +1,100 @@
+package kubernetes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/util"
+	"github.com/stretchr/testify/assert"
+	kubeapi "k8s.io/api/core/v1"
+	eventsv1beta1 "k8s.io/api/events/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+func TestConvertEventSeries(t *testing.T) {
+	first := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	last := first.Add(time.Hour)
+	event := &eventsv1beta1.Event{
+		ObjectMeta:          metav1.ObjectMeta{Name: "nginx.1", Namespace: "default"},
+		EventTime:           metav1.NewMicroTime(first),
+		Series:              &eventsv1beta1.EventSeries{Count: 5, LastObservedTime: metav1.NewMicroTime(last)},
+		ReportingController: "kubelet",
+		ReportingInstance:   "kubelet-node-1",
+		Action:              "Pulling",
+		Reason:              "BackOff",
+		Regarding:           kubeapi.ObjectReference{Kind: "Pod", Name: "nginx"},
+		Related:             &kubeapi.ObjectReference{Kind: "Node", Name: "node-1"},
+		Note:                "Back-off pulling image",
+		Type:                kubeapi.EventTypeWarning,
+	}
+
+	result := convertEvent(fromV1beta1(event))
+	assert.Equal(t, "nginx.1", result.Name)
+	assert.Equal(t, "Pod", result.InvolvedObject.Kind)
+	assert.Equal(t, "node-1", result.Related.Name)
+	assert.Equal(t, "Back-off pulling image", result.Message)
+	assert.Equal(t, "kubelet", result.Source.Component)
+	assert.Equal(t, "kubelet", result.ReportingController)
+	assert.Equal(t, int32(5), result.Count)
+	assert.True(t, first.Equal(result.FirstTimestamp.Time))
+	assert.True(t, last.Equal(result.LastTimestamp.Time))
+	assert.True(t, last.Equal(util.GetLastEventTimestamp(result)))
+
+	event.Series = nil
+	result = convertEvent(fromV1beta1(event))
+	assert.Equal(t, int32(1), result.Count)
+	assert.True(t, first.Equal(result.LastTimestamp.Time))
+}
+
+func TestEventsV1ClientDecodesEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/apis/events.k8s.io/v1/namespaces/default/events", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"apiVersion":"events.k8s.io/v1","kind":"EventList","metadata":{"resourceVersion":"42"},
+			"items":[{"metadata":{"name":"nginx.1","namespace":"default"},"eventTime":"2021-01-01T00:00:00.000000Z",
+			"reason":"Started","note":"Started container","type":"Normal","regarding":{"kind":"Pod","name":"nginx"}}]}`))
+	}))
+	defer server.Close()
+
+	uri, err := url.Parse(server.URL + "?inClusterConfig=false&api=events.k8s.io/v1")
+	assert.NoError(t, err)
+	client, err := newEventInterface(uri, nil, "default")
+	assert.NoError(t, err)
+
+	events, err := client.List(metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "42", events.ResourceVersion)
+	assert.Equal(t, 1, len(events.Items))
+	assert.Equal(t, "Started container", events.Items[0].Message)
+	assert.Equal(t, "nginx", events.Items[0].InvolvedObject.Name)
+	assert.False(t, clientscheme.Scheme.IsVersionRegistered(eventsV1GroupVersion))
+}
+
+func TestEventsV1ClientWatchesEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.URL.Query().Get("watch"))
+		assert.Equal(t, "42", r.URL.Query().Get("resourceVersion"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"type":"ADDED","object":{"apiVersion":"events.k8s.io/v1","kind":"Event",` +
+			`"metadata":{"name":"nginx.1","resourceVersion":"43"},"reason":"Started","note":"Started container"}}` + "\n"))
+	}))
+	defer server.Close()
+
+	uri, err := url.Parse(server.URL + "?inClusterConfig=false&api=events.k8s.io/v1")
+	assert.NoError(t, err)
+	client, err := newEventInterface(uri, nil, kubeapi.NamespaceAll)
+	assert.NoError(t, err)
+
+	watcher, err := client.Watch(metav1.ListOptions{Watch: true, ResourceVersion: "42"})
+	assert.NoError(t, err)
+	defer watcher.Stop()
+	update := <-watcher.ResultChan()
+	event, ok := update.Object.(*kubeapi.Event)
+	assert.True(t, ok, "unexpected object %#v", update.Object)
+	assert.Equal(t, "43", event.ResourceVersion)
+	assert.Equal(t, "Started container", event.Message)
+}
@@ -0,0 +1,79 @@
+package kubernetes
+
+import (
+	kubeapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// eventsV1Event is the Event of events.k8s.io/v1, which the vendored k8s.io/api does not have yet.
+// It is only registered in the scheme of the eventsV1Client.
+type eventsV1Event struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	EventTime                metav1.MicroTime         `json:"eventTime"`
+	Series                   *eventsV1EventSeries     `json:"series,omitempty"`
+	ReportingController      string                   `json:"reportingController,omitempty"`
+	ReportingInstance        string                   `json:"reportingInstance,omitempty"`
+	Action                   string                   `json:"action,omitempty"`
+	Reason                   string                   `json:"reason,omitempty"`
+	Regarding                kubeapi.ObjectReference  `json:"regarding,omitempty"`
+	Related                  *kubeapi.ObjectReference `json:"related,omitempty"`
+	Note                     string                   `json:"note,omitempty"`
+	Type                     string                   `json:"type,omitempty"`
+	DeprecatedSource         kubeapi.EventSource      `json:"deprecatedSource,omitempty"`
+	DeprecatedFirstTimestamp metav1.Time              `json:"deprecatedFirstTimestamp,omitempty"`
+	DeprecatedLastTimestamp  metav1.Time              `json:"deprecatedLastTimestamp,omitempty"`
+	DeprecatedCount          int32                    `json:"deprecatedCount,omitempty"`
+}
+
+type eventsV1EventSeries struct {
+	Count            int32            `json:"count"`
+	LastObservedTime metav1.MicroTime `json:"lastObservedTime"`
+}
+
+type eventsV1EventList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []eventsV1Event `json:"items"`
+}
+
+func (in *eventsV1Event) DeepCopyInto(out *eventsV1Event) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.EventTime.DeepCopyInto(&out.EventTime)
+	if in.Series != nil {
+		out.Series = &eventsV1EventSeries{Count: in.Series.Count}
+		in.Series.LastObservedTime.DeepCopyInto(&out.Series.LastObservedTime)
+	}
+	out.Regarding = in.Regarding
+	if in.Related != nil {
+		out.Related = new(kubeapi.ObjectReference)
+		*out.Related = *in.Related
+	}
+	out.DeprecatedSource = in.DeprecatedSource
+	in.DeprecatedFirstTimestamp.DeepCopyInto(&out.DeprecatedFirstTimestamp)
+	in.DeprecatedLastTimestamp.DeepCopyInto(&out.DeprecatedLastTimestamp)
+}
+
+func (in *eventsV1Event) DeepCopyObject() runtime.Object {
+	out := new(eventsV1Event)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *eventsV1EventList) DeepCopyObject() runtime.Object {
+	out := new(eventsV1EventList)
+	*out = *in
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]eventsV1Event, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
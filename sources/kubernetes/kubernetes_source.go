@@ -15,8 +15,13 @@
 package kubernetes
 
 import (
+	"fmt"
 	metrics "github.com/AliyunContainerService/kube-eventer/metrics/prometheus"
+	"github.com/AliyunContainerService/kube-eventer/util"
+	"net/http"
 	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -24,16 +29,22 @@ import (
 	"github.com/AliyunContainerService/kube-eventer/common/kubernetes"
 	"github.com/AliyunContainerService/kube-eventer/core"
 	kubeapi "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	kubewatch "k8s.io/apimachinery/pkg/watch"
 
-	kubev1core "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/klog/v2"
 )
 
 const (
 	// Number of object pointers. Big enough so it won't be hit anytime soon with reasonable GetNewEvents frequency.
 	LocalEventsBufferSize = 100000
+	// Number of exported events remembered to skip them when the events have to be listed again.
+	MaxRememberedEvents = 20000
+	// Page size when listing all events after the watch expired.
+	relistPageSize = 500
 )
 
 var (
@@ -67,16 +78,51 @@ func init() {
 	prometheus.MustRegister(scrapEventsDuration)
 }
 
+// bufferedEvent is an event together with the position of the watch after the event.
+type bufferedEvent struct {
+	event           *kubeapi.Event
+	namespace       string
+	resourceVersion string
+}
+
 // Implements core.EventSource interface.
 type KubernetesEventSource struct {
 	// Large local buffer, periodically read.
-	localEventsBuffer chan *kubeapi.Event
+	localEventsBuffer chan *bufferedEvent
 
 	stopChannel chan struct{}
 
-	eventClient kubev1core.EventInterface
+	// One watch per namespace.
+	watchers []*namespaceWatcher
 
 	exportMetric bool
+	// Name of the cluster the events are tagged with, empty for no tag.
+	clusterName string
+
+	// Position of the last event handed out by GetNewEvents.
+	checkpoint      *checkpoint
+	checkpointStore checkpointStore
+	// 1 while the position is not persisted, e.g. on a standby.
+	checkpointDisabled int32
+}
+
+func (this *KubernetesEventSource) SetCheckpointing(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&this.checkpointDisabled, 0)
+	} else {
+		atomic.StoreInt32(&this.checkpointDisabled, 1)
+	}
+}
+
+func (this *KubernetesEventSource) LoadDelivered() (func(*kubeapi.Event) bool, error) {
+	if this.checkpointStore == nil {
+		return nil, nil
+	}
+	cp, err := this.checkpointStore.Load()
+	if err != nil || cp == nil {
+		return nil, err
+	}
+	return cp.covers, nil
 }
 
 func (this *KubernetesEventSource) GetNewEvents() *core.EventBatch {
@@ -93,8 +139,12 @@ func (this *KubernetesEventSource) GetNewEvents() *core.EventBatch {
 event_loop:
 	for {
 		select {
-		case event := <-this.localEventsBuffer:
-			result.Events = append(result.Events, event)
+		case buffered := <-this.localEventsBuffer:
+			result.Events = append(result.Events, buffered.event)
+			this.checkpoint.ResourceVersions[buffered.namespace] = buffered.resourceVersion
+			if ts := util.GetLastEventTimestamp(buffered.event); ts.After(this.checkpoint.Timestamp) {
+				this.checkpoint.Timestamp = ts
+			}
 		default:
 			break event_loop
 		}
@@ -102,32 +152,161 @@ event_loop:
 
 	totalEventsNum.Add(float64(len(result.Events)))
 
+	if this.checkpointStore != nil && len(result.Events) > 0 && atomic.LoadInt32(&this.checkpointDisabled) == 0 {
+		if err := this.checkpointStore.Save(this.checkpoint); err != nil {
+			klog.Errorf("Failed to save checkpoint: %v", err)
+		}
+	}
+
 	return &result
 }
 
-func (this *KubernetesEventSource) watch() {
+// namespaceWatcher watches the events of one namespace, or of all namespaces.
+type namespaceWatcher struct {
+	source      *KubernetesEventSource
+	eventClient eventInterface
+	namespace   string
+	// Field and label selectors of the list and watch requests.
+	fieldSelector string
+	labelSelector string
+
+	// Position of the watch, only used by the watch goroutine.
+	resourceVersion string
+	relist          bool
+	since           time.Time
+	exported        *exportedEvents
+}
+
+func (this *namespaceWatcher) listOptions() metav1.ListOptions {
+	return metav1.ListOptions{
+		FieldSelector: this.fieldSelector,
+		LabelSelector: this.labelSelector,
+	}
+}
+
+// startVersion returns the resourceVersion to start the watch from.
+func (this *namespaceWatcher) startVersion() (string, error) {
+	if this.relist {
+		return this.relistEvents()
+	}
+	if this.resourceVersion != "" {
+		// Resume where the previous watch stopped.
+		return this.resourceVersion, nil
+	}
+
+	opts := this.listOptions()
+	opts.Limit = 1
+	events, err := this.eventClient.List(opts)
+	if err != nil {
+		return "", err
+	}
+	// Do not write old events.
+	klog.V(9).Infof("kubernetes source watch event. list event first. raw events: %v", events)
+	return events.ResourceVersion, nil
+}
+
+// relistEvents lists all events after the watch position expired. Events that were
+// already delivered are skipped. It returns the resourceVersion of the list.
+func (this *namespaceWatcher) relistEvents() (string, error) {
+	klog.Warningf("Listing all events of namespace %q to catch up with the events missed while the watch was broken", this.namespace)
+	var (
+		items           []kubeapi.Event
+		resourceVersion string
+		continueToken   string
+	)
+	for {
+		opts := this.listOptions()
+		opts.Limit = relistPageSize
+		opts.Continue = continueToken
+		events, err := this.eventClient.List(opts)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, events.Items...)
+		resourceVersion = events.ResourceVersion
+		continueToken = events.Continue
+		if continueToken == "" {
+			break
+		}
+	}
+
+	// The list is not ordered by time and deliver moves since forward, so the events are
+	// compared with the position before the list.
+	since := this.since
+	missed := 0
+	for i := range items {
+		event := &items[i]
+		if this.exported.contains(event) {
+			continue
+		}
+		if !since.IsZero() && util.GetLastEventTimestamp(event).Before(since) {
+			continue
+		}
+		this.deliver(event, resourceVersion)
+		missed++
+	}
+	klog.Infof("Recovered %d missed events of %d listed events", missed, len(items))
+	this.relist = false
+	return resourceVersion, nil
+}
+
+// deliver puts the event into the buffer and records it as exported.
+func (this *namespaceWatcher) deliver(event *kubeapi.Event, resourceVersion string) {
+	if this.source.clusterName != "" {
+		util.SetClusterName(event, this.source.clusterName)
+	}
+	if this.source.exportMetric {
+		metrics.RecordEvent(event)
+	}
+	select {
+	case this.source.localEventsBuffer <- &bufferedEvent{event: event, namespace: this.namespace, resourceVersion: resourceVersion}:
+		// Ok, buffer not full.
+		this.exported.add(event)
+		if ts := util.GetLastEventTimestamp(event); ts.After(this.since) {
+			this.since = ts
+		}
+	default:
+		// Buffer full, need to drop the event.
+		klog.Errorf("Event buffer full, dropping event")
+	}
+}
+
+// expire makes the next watch start with a full relist.
+func (this *namespaceWatcher) expire() {
+	klog.Warningf("Watch position %s expired", this.resourceVersion)
+	this.resourceVersion = ""
+	this.relist = true
+}
+
+func isExpired(status *metav1.Status) bool {
+	return status.Code == http.StatusGone ||
+		status.Reason == metav1.StatusReasonExpired ||
+		status.Reason == metav1.StatusReasonGone
+}
+
+func (this *namespaceWatcher) watch() {
 	// Outer loop, for reconnections.
 	for {
-		events, err := this.eventClient.List(metav1.ListOptions{Limit: 1})
+		resourceVersion, err := this.startVersion()
 		if err != nil {
 			klog.Errorf("Failed to load events: %v", err)
 			time.Sleep(time.Second)
 			continue
 		}
-		// Do not write old events.
-		klog.V(9).Infof("kubernetes source watch event. list event first. raw events: %v", events)
-
-		resourceVersion := events.ResourceVersion
 
-		watcher, err := this.eventClient.Watch(
-			metav1.ListOptions{
-				Watch:           true,
-				ResourceVersion: resourceVersion})
+		opts := this.listOptions()
+		opts.Watch = true
+		opts.ResourceVersion = resourceVersion
+		watcher, err := this.eventClient.Watch(opts)
 		if err != nil {
 			klog.Errorf("Failed to start watch for new events: %v", err)
+			if kubeerrors.IsResourceExpired(err) || kubeerrors.IsGone(err) {
+				this.expire()
+			}
 			time.Sleep(time.Second)
 			continue
 		}
+		this.resourceVersion = resourceVersion
 
 		watchChannel := watcher.ResultChan()
 		// Inner loop, for update processing.
@@ -139,12 +318,15 @@ func (this *KubernetesEventSource) watch() {
 				klog.V(10).Infof("kubernetes source watch channel update. watch channel update. watchChanObject: %v", watchUpdate)
 
 				if !ok {
-					klog.Errorf("Event watch channel closed")
+					klog.Errorf("Event watch channel of namespace %q closed", this.namespace)
 					break inner_loop
 				}
 				if watchUpdate.Type == kubewatch.Error {
 					if status, ok := watchUpdate.Object.(*metav1.Status); ok {
 						klog.Errorf("Error during watch: %#v", status)
+						if isExpired(status) {
+							this.expire()
+						}
 						break inner_loop
 					}
 					klog.Errorf("Received unexpected error: %#v", watchUpdate.Object)
@@ -157,17 +339,10 @@ func (this *KubernetesEventSource) watch() {
 
 					switch watchUpdate.Type {
 					case kubewatch.Added, kubewatch.Modified:
-						if this.exportMetric {
-							metrics.RecordEvent(event)
-						}
-						select {
-						case this.localEventsBuffer <- event:
-							// Ok, buffer not full.
-						default:
-							// Buffer full, need to drop the event.
-							klog.Errorf("Event buffer full, dropping event")
-						}
+						this.resourceVersion = event.ResourceVersion
+						this.deliver(event, event.ResourceVersion)
 					case kubewatch.Deleted:
+						this.resourceVersion = event.ResourceVersion
 					default:
 						klog.Warningf("Unknown watchUpdate.Type: %#v", watchUpdate.Type)
 					}
@@ -175,31 +350,121 @@ func (this *KubernetesEventSource) watch() {
 					klog.Errorf("Wrong object received: %v", watchUpdate)
 				}
 
-			case <-this.stopChannel:
+			case <-this.source.stopChannel:
 				watcher.Stop()
-				klog.Infof("Event watching stopped")
+				klog.Infof("Event watching of namespace %q stopped", this.namespace)
 				return
 			}
 		}
 	}
 }
 
+// exportedEvents remembers the resourceVersion of the latest delivered events by UID.
+type exportedEvents struct {
+	versions map[string]string
+	order    []string
+	max      int
+}
+
+func newExportedEvents(max int) *exportedEvents {
+	return &exportedEvents{
+		versions: make(map[string]string),
+		max:      max,
+	}
+}
+
+func (e *exportedEvents) add(event *kubeapi.Event) {
+	uid := string(event.UID)
+	if _, ok := e.versions[uid]; !ok {
+		e.order = append(e.order, uid)
+		if len(e.order) > e.max {
+			delete(e.versions, e.order[0])
+			e.order = e.order[1:]
+		}
+	}
+	e.versions[uid] = event.ResourceVersion
+}
+
+func (e *exportedEvents) contains(event *kubeapi.Event) bool {
+	version, ok := e.versions[string(event.UID)]
+	return ok && version == event.ResourceVersion
+}
+
+// getNamespaces returns the namespaces given by the namespaces option, or all namespaces.
+func getNamespaces(opts url.Values) []string {
+	namespaces := []string{}
+	for _, value := range opts["namespaces"] {
+		for _, namespace := range strings.Split(value, ",") {
+			if namespace = strings.TrimSpace(namespace); namespace != "" {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+	}
+	if len(namespaces) == 0 {
+		return []string{kubeapi.NamespaceAll}
+	}
+	return namespaces
+}
+
 func NewKubernetesSource(uri *url.URL, exportMetric bool) (*KubernetesEventSource, error) {
 	kubeClient, err := kubernetes.GetKubernetesClient(uri)
 	if err != nil {
 		klog.Errorf("Failed to create kubernetes client,because of %v", err)
 		return nil, err
 	}
-	eventClient := kubeClient.CoreV1().Events(kubeapi.NamespaceAll)
+	opts := uri.Query()
+	store, err := newCheckpointStore(opts, kubeClient)
+	if err != nil {
+		return nil, err
+	}
 	result := KubernetesEventSource{
-		localEventsBuffer: make(chan *kubeapi.Event, LocalEventsBufferSize),
+		localEventsBuffer: make(chan *bufferedEvent, LocalEventsBufferSize),
 		stopChannel:       make(chan struct{}),
-		eventClient:       eventClient,
 		exportMetric:      exportMetric,
+		clusterName:       opts.Get("cluster_name"),
+		checkpoint:        &checkpoint{ResourceVersions: map[string]string{}},
+		checkpointStore:   store,
+	}
+	if store != nil {
+		cp, err := store.Load()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load checkpoint: %v", err)
+		}
+		if cp != nil {
+			if cp.ResourceVersions == nil {
+				cp.ResourceVersions = map[string]string{}
+			}
+			klog.Infof("Resuming event watch from checkpoint %v", cp.ResourceVersions)
+			result.checkpoint = cp
+		}
+	}
+	if _, err := fields.ParseSelector(opts.Get("field_selector")); err != nil {
+		return nil, fmt.Errorf("invalid field_selector: %v", err)
+	}
+	if _, err := labels.Parse(opts.Get("label_selector")); err != nil {
+		return nil, fmt.Errorf("invalid label_selector: %v", err)
+	}
+	for _, namespace := range getNamespaces(opts) {
+		eventClient, err := newEventInterface(uri, kubeClient, namespace)
+		if err != nil {
+			return nil, err
+		}
+		result.watchers = append(result.watchers, &namespaceWatcher{
+			source:          &result,
+			eventClient:     eventClient,
+			namespace:       namespace,
+			fieldSelector:   opts.Get("field_selector"),
+			labelSelector:   opts.Get("label_selector"),
+			resourceVersion: result.checkpoint.ResourceVersions[namespace],
+			since:           result.checkpoint.Timestamp,
+			exported:        newExportedEvents(MaxRememberedEvents),
+		})
 	}
 	if exportMetric {
 		metrics.InitMetrics()
 	}
-	go result.watch()
+	for _, watcher := range result.watchers {
+		go watcher.watch()
+	}
 	return &result, nil
 }
@@ -0,0 +1,229 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	kubeapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubev1core "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// fakeEventClient returns the given pages on List.
+type fakeEventClient struct {
+	kubev1core.EventInterface
+	pages   []*kubeapi.EventList
+	options []metav1.ListOptions
+}
+
+func (f *fakeEventClient) List(opts metav1.ListOptions) (*kubeapi.EventList, error) {
+	f.options = append(f.options, opts)
+	page := f.pages[0]
+	f.pages = f.pages[1:]
+	return page, nil
+}
+
+func newEvent(uid, resourceVersion string, lastTimestamp time.Time) kubeapi.Event {
+	return kubeapi.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			UID:             types.UID(uid),
+			ResourceVersion: resourceVersion,
+		},
+		LastTimestamp: metav1.NewTime(lastTimestamp),
+	}
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := &fileCheckpointStore{path: filepath.Join(dir, "state", "checkpoint.json")}
+	cp, err := store.Load()
+	assert.NoError(t, err)
+	assert.Nil(t, cp)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	assert.NoError(t, store.Save(&checkpoint{ResourceVersions: map[string]string{"": "42"}, Timestamp: now}))
+	cp, err = store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "42", cp.ResourceVersions[""])
+	assert.True(t, now.Equal(cp.Timestamp))
+}
+
+func TestCheckpointCovers(t *testing.T) {
+	now := time.Now()
+	cp := &checkpoint{ResourceVersions: map[string]string{"default": "100"}, Timestamp: now}
+
+	delivered := newEvent("a", "100", now)
+	delivered.Namespace = "default"
+	assert.True(t, cp.covers(&delivered))
+	// resourceVersions are not ordered, the others are compared by time.
+	earlier := newEvent("b", "101", now.Add(-time.Minute))
+	earlier.Namespace = "default"
+	assert.True(t, cp.covers(&earlier))
+	later := newEvent("b", "99", now.Add(time.Minute))
+	later.Namespace = "default"
+	assert.False(t, cp.covers(&later))
+
+	// No position for the namespace, compared by time.
+	old := newEvent("c", "50", now.Add(-time.Minute))
+	old.Namespace = "kube-system"
+	assert.True(t, cp.covers(&old))
+	recent := newEvent("d", "50", now)
+	recent.Namespace = "kube-system"
+	assert.False(t, cp.covers(&recent))
+
+	cp = &checkpoint{ResourceVersions: map[string]string{"": "50"}}
+	assert.True(t, cp.covers(&old))
+}
+
+func TestStandbyDoesNotSaveCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := &fileCheckpointStore{path: filepath.Join(dir, "checkpoint.json")}
+	source := &KubernetesEventSource{
+		localEventsBuffer: make(chan *bufferedEvent, 10),
+		checkpoint:        &checkpoint{ResourceVersions: map[string]string{}},
+		checkpointStore:   store,
+	}
+	event := newEvent("a", "10", time.Now())
+	source.SetCheckpointing(false)
+	source.localEventsBuffer <- &bufferedEvent{event: &event, resourceVersion: "10"}
+	assert.Equal(t, 1, len(source.GetNewEvents().Events))
+	cp, err := store.Load()
+	assert.NoError(t, err)
+	assert.Nil(t, cp)
+
+	source.SetCheckpointing(true)
+	source.localEventsBuffer <- &bufferedEvent{event: &event, resourceVersion: "11"}
+	source.GetNewEvents()
+	cp, err = store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "11", cp.ResourceVersions[""])
+}
+
+func TestRelistSkipsDeliveredEvents(t *testing.T) {
+	now := time.Now()
+	delivered := newEvent("a", "10", now.Add(-time.Minute))
+	client := &fakeEventClient{pages: []*kubeapi.EventList{
+		{
+			ListMeta: metav1.ListMeta{ResourceVersion: "20", Continue: "next"},
+			Items: []kubeapi.Event{
+				delivered,
+				// Updated after it was delivered.
+				newEvent("a", "15", now),
+				// Older than everything delivered.
+				newEvent("b", "5", now.Add(-time.Hour)),
+			},
+		},
+		{
+			ListMeta: metav1.ListMeta{ResourceVersion: "20"},
+			Items:    []kubeapi.Event{newEvent("c", "18", now)},
+		},
+	}}
+	source := &KubernetesEventSource{
+		localEventsBuffer: make(chan *bufferedEvent, 10),
+		checkpoint:        &checkpoint{ResourceVersions: map[string]string{}},
+	}
+	watcher := &namespaceWatcher{
+		source:      source,
+		eventClient: client,
+		exported:    newExportedEvents(MaxRememberedEvents),
+		relist:      true,
+	}
+	watcher.deliver(&delivered, "10")
+	source.GetNewEvents()
+
+	resourceVersion, err := watcher.startVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, "20", resourceVersion)
+	assert.False(t, watcher.relist)
+
+	batch := source.GetNewEvents()
+	assert.Equal(t, 2, len(batch.Events))
+	assert.Equal(t, "15", batch.Events[0].ResourceVersion)
+	assert.Equal(t, types.UID("c"), batch.Events[1].UID)
+	assert.Equal(t, "20", source.checkpoint.ResourceVersions[""])
+}
+
+func TestRelistKeepsMissedEventsOutOfTimeOrder(t *testing.T) {
+	now := time.Now()
+	delivered := newEvent("a", "10", now.Add(-10*time.Minute))
+	client := &fakeEventClient{pages: []*kubeapi.EventList{
+		{
+			ListMeta: metav1.ListMeta{ResourceVersion: "20"},
+			Items: []kubeapi.Event{
+				// Missed events, the newest listed first.
+				newEvent("c", "14", now),
+				newEvent("b", "12", now.Add(-5*time.Minute)),
+			},
+		},
+	}}
+	source := &KubernetesEventSource{
+		localEventsBuffer: make(chan *bufferedEvent, 10),
+		checkpoint:        &checkpoint{ResourceVersions: map[string]string{}},
+	}
+	watcher := &namespaceWatcher{
+		source:      source,
+		eventClient: client,
+		exported:    newExportedEvents(MaxRememberedEvents),
+		relist:      true,
+	}
+	watcher.deliver(&delivered, "10")
+	source.GetNewEvents()
+
+	_, err := watcher.startVersion()
+	assert.NoError(t, err)
+
+	batch := source.GetNewEvents()
+	assert.Equal(t, 2, len(batch.Events))
+	assert.Equal(t, types.UID("c"), batch.Events[0].UID)
+	assert.Equal(t, types.UID("b"), batch.Events[1].UID)
+	assert.True(t, now.Equal(watcher.since))
+}
+
+func TestExportedEventsEvictsOldest(t *testing.T) {
+	exported := newExportedEvents(2)
+	events := []kubeapi.Event{
+		newEvent("a", "1", time.Now()),
+		newEvent("b", "2", time.Now()),
+		newEvent("c", "3", time.Now()),
+	}
+	for i := range events {
+		exported.add(&events[i])
+	}
+	assert.False(t, exported.contains(&events[0]))
+	assert.True(t, exported.contains(&events[1]))
+	assert.True(t, exported.contains(&events[2]))
+}
+
+func TestGetNamespaces(t *testing.T) {
+	assert.Equal(t, []string{kubeapi.NamespaceAll}, getNamespaces(url.Values{}))
+	assert.Equal(t, []string{"default", "kube-system", "team-a"},
+		getNamespaces(url.Values{"namespaces": {"default, kube-system", "team-a"}}))
+}
+
+func TestListOptionsUseSelectors(t *testing.T) {
+	client := &fakeEventClient{pages: []*kubeapi.EventList{{ListMeta: metav1.ListMeta{ResourceVersion: "7"}}}}
+	watcher := &namespaceWatcher{
+		eventClient:   client,
+		namespace:     "team-a",
+		fieldSelector: "type=Warning",
+		labelSelector: "app=web",
+	}
+	resourceVersion, err := watcher.startVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, "7", resourceVersion)
+	assert.Equal(t, "type=Warning", client.options[0].FieldSelector)
+	assert.Equal(t, "app=web", client.options[0].LabelSelector)
+	assert.Equal(t, int64(1), client.options[0].Limit)
+}
@@ -1,12 +1,24 @@
 package util
 
 import (
-	"k8s.io/api/core/v1"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
 	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
 )
 
 func GetLastEventTimestamp(event *v1.Event) time.Time {
 
+	// Events written with the events.k8s.io API only update the series of repeated events.
+	if event.Series != nil && event.Series.LastObservedTime.After(event.LastTimestamp.Time) {
+		return event.Series.LastObservedTime.Time
+	}
+
 	if !event.LastTimestamp.IsZero() {
 		return event.LastTimestamp.Time
 	}
@@ -17,3 +29,72 @@ func GetLastEventTimestamp(event *v1.Event) time.Time {
 
 	return time.Now()
 }
+
+// ClusterNameAnnotation is the annotation of an event with the name of the cluster it was read
+// from, set by sources configured with a cluster name.
+const ClusterNameAnnotation = "kube-eventer/cluster-name"
+
+// SetClusterName tags the event with the name of the cluster it was read from.
+func SetClusterName(event *v1.Event, clusterName string) {
+	annotations := make(map[string]string, len(event.Annotations)+1)
+	for key, value := range event.Annotations {
+		annotations[key] = value
+	}
+	annotations[ClusterNameAnnotation] = clusterName
+	event.Annotations = annotations
+}
+
+// GetClusterName returns the name of the cluster the event was read from, or fallback if the
+// source is not configured with a cluster name.
+func GetClusterName(event *v1.Event, fallback string) string {
+	if clusterName := event.Annotations[ClusterNameAnnotation]; clusterName != "" {
+		return clusterName
+	}
+	return fallback
+}
+
+// GetLevel returns the severity of an event type to compare with the level option of a sink:
+// 2 for Warning, 1 for Normal and 0 for any other type.
+func GetLevel(level string) int {
+	switch level {
+	case v1.EventTypeWarning:
+		return 2
+	case v1.EventTypeNormal:
+		return 1
+	}
+	return 0
+}
+
+// IsRetryableStatusCode reports whether a request answered with the status code may succeed when sent again.
+func IsRetryableStatusCode(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// CheckResponse returns nil for a 2xx response and an error with the status code and body of the
+// response otherwise, retryable if sending the request again may succeed. action is what the
+// request failed to do, e.g. "send alerts to alertmanager".
+func CheckResponse(resp *http.Response, action string) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	err := fmt.Errorf("failed to %s, because the response code is %d, body is: %s", action, resp.StatusCode, string(body))
+	if IsRetryableStatusCode(resp.StatusCode) {
+		return core.NewRetryableError(err)
+	}
+	return err
+}
+
+// ParseLabels parses the label options of a sink, each a <key>,<value> pair. Malformed pairs are logged and skipped.
+func ParseLabels(labelsStrs []string) map[string]string {
+	labels := make(map[string]string)
+	for _, kv := range labelsStrs {
+		kvItems := strings.Split(kv, ",")
+		if len(kvItems) == 2 {
+			labels[kvItems[0]] = kvItems[1]
+		} else {
+			klog.Errorf("parse labels error. labelsStr: %v, kv format error: %v", labelsStrs, kv)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,96 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestParseLabels(t *testing.T) {
+	testCases := []struct {
+		name     string
+		labels   []string
+		expected map[string]string
+	}{
+		{
+			name:     "labels is empty",
+			labels:   []string{},
+			expected: map[string]string{},
+		},
+		{
+			name:     "invalid labels",
+			labels:   []string{"key,value,other"},
+			expected: map[string]string{},
+		},
+		{
+			name:     "valid labels",
+			labels:   []string{"key,value"},
+			expected: map[string]string{"key": "value"},
+		},
+		{
+			name:     "valid and invalid labels",
+			labels:   []string{"key1,value1", "key2,value2,other"},
+			expected: map[string]string{"key1": "value1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := ParseLabels(tc.labels)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	testCases := []struct {
+		code      int
+		err       bool
+		retryable bool
+	}{
+		{code: http.StatusOK},
+		{code: http.StatusAccepted},
+		{code: http.StatusNoContent},
+		{code: http.StatusMovedPermanently, err: true},
+		{code: http.StatusBadRequest, err: true},
+		{code: http.StatusUnauthorized, err: true},
+		{code: http.StatusNotFound, err: true},
+		{code: http.StatusRequestEntityTooLarge, err: true},
+		{code: http.StatusTooManyRequests, err: true, retryable: true},
+		{code: http.StatusInternalServerError, err: true, retryable: true},
+		{code: http.StatusBadGateway, err: true, retryable: true},
+		{code: http.StatusServiceUnavailable, err: true, retryable: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(http.StatusText(tc.code), func(t *testing.T) {
+			resp := &http.Response{StatusCode: tc.code, Body: ioutil.NopCloser(strings.NewReader("details"))}
+			err := CheckResponse(resp, "send events")
+			if !tc.err {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, fmt.Sprintf("failed to send events, because the response code is %d, body is: details", tc.code))
+			assert.Equal(t, tc.retryable, core.IsRetryable(err))
+		})
+	}
+}
+
+func TestClusterName(t *testing.T) {
+	event := &v1.Event{}
+	event.Annotations = map[string]string{"owner": "team-a"}
+	assert.Equal(t, "default", GetClusterName(event, "default"))
+
+	annotations := event.Annotations
+	SetClusterName(event, "prod")
+	assert.Equal(t, "prod", GetClusterName(event, "default"))
+	assert.Equal(t, "team-a", event.Annotations["owner"])
+	// the annotations the event shared are left as they were.
+	assert.Len(t, annotations, 1)
+}
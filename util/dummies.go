@@ -19,6 +19,8 @@ import (
 	"time"
 
 	"github.com/AliyunContainerService/kube-eventer/core"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type DummySink struct {
@@ -82,3 +84,29 @@ func NewDummySource(eventBatch *core.EventBatch) *DummyEventSource {
 		eventBatch: eventBatch,
 	}
 }
+
+// NewDummyEvent returns an event of the object of the kind and name in the default namespace of
+// the prod cluster, repeated 3 times between 10:00 and 10:05 on 2024-01-01 UTC.
+func NewDummyEvent(eventType, kind, name, reason string) *v1.Event {
+	firstSeen := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + ".17a",
+			Namespace: "default",
+		},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        "Back-off restarting failed container",
+		Count:          3,
+		FirstTimestamp: metav1.NewTime(firstSeen),
+		LastTimestamp:  metav1.NewTime(firstSeen.Add(5 * time.Minute)),
+		Source:         v1.EventSource{Component: "kubelet", Host: "node-1"},
+		InvolvedObject: v1.ObjectReference{
+			Kind:      kind,
+			Namespace: "default",
+			Name:      name,
+		},
+	}
+	SetClusterName(event, "prod")
+	return event
+}